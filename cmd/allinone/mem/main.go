@@ -1,8 +1,11 @@
 package main
 
 import (
+	"context"
+	"database/sql"
 	"time"
 
+	_ "github.com/mattn/go-sqlite3"
 	"github.com/s-min-sys/protorepo/gens/userpb"
 	"github.com/s-min-sys/userbe/internal/authenticatorserver/admin"
 	"github.com/s-min-sys/userbe/internal/authenticatorserver/google2fa"
@@ -12,6 +15,10 @@ import (
 	"github.com/s-min-sys/userbe/internal/server"
 	"github.com/s-min-sys/userbe/internal/userserver"
 	"github.com/s-min-sys/userbe/internal/usertokenmanager"
+	"github.com/s-min-sys/userbe/internal/usertokenmanager/sqlstorage"
+	"github.com/s-min-sys/userbe/internal/usertokenmanager/usertokenmanagerinters"
+	"github.com/s-min-sys/userbe/pkg/grpctoken"
+	authenticatorinters "github.com/sbasestarter/bizuserlib/bizuserinters/model/authenticator"
 	"github.com/sbasestarter/bizuserlib/model/authenticator/model"
 	"github.com/sbasestarter/bizuserlib/tokenmanager"
 	"github.com/sgostarter/libservicetoolset/servicetoolset"
@@ -23,6 +30,10 @@ func main() {
 
 	logger := cfg.Logger
 
+	if err := grpctoken.SetTrustedProxies(cfg.TrustedProxies); err != nil {
+		logger.Fatal(err)
+	}
+
 	tlsConfig, err := servicetoolset.GRPCTlsConfigMap(cfg.GRPCTLSConfig)
 	if err != nil {
 		logger.Fatal(err)
@@ -43,18 +54,48 @@ func main() {
 		return
 	}
 
+	// the bizuserlib token manager only holds flows in progress, they are lost on restart
 	tokenManager := tokenmanager.NewMemoryTokenManager()
 	jwtDataStorage := usertokenmanager.NewMemoryJWTDataStorage()
+	sessionStorage := usertokenmanager.NewMemorySessionStorage()
 	dbModel := model.NewMemoryDBModel(tokenManager)
-	instances := server.NewInstances(tokenManager, jwtDataStorage, dbModel, cfg)
 
-	us := userserver.NewServer(instances.UserManager, instances.UserTokenManager, cfg.DefaultDomain)
+	if cfg.DataFile != "" {
+		jwtDataStorage, sessionStorage, dbModel, err = newFileStorages(cfg)
+		if err != nil {
+			logger.Fatal(err)
+
+			return
+		}
+	}
+
+	instances, err := server.NewInstances(tokenManager, nil, jwtDataStorage, sessionStorage, dbModel, cfg)
+	if err != nil {
+		logger.Fatal(err)
+
+		return
+	}
+
+	oAuthGrantStore := usertokenmanager.NewMemoryOAuthGrantStore()
+	oAuthTokenStore := usertokenmanager.NewMemoryOAuthTokenStore()
+	bizFlowStorage := usertokenmanager.NewMemoryBizFlowStorage()
+
+	us := userserver.NewServer(instances.UserManager, instances.UserTokenManager, cfg.DefaultDomain,
+		userserver.TokenLifetimes{
+			Expiration:           cfg.UserToken.Expiration,
+			RememberMeExpiration: cfg.UserToken.RememberMeExpiration,
+			ClientExpirations:    cfg.UserToken.ClientExpirations,
+		}, userserver.StepUpPolicy{
+			RecentAuthAge:   cfg.StepUp.RecentAuthAge,
+			AuthMethods:     cfg.StepUp.AuthMethods,
+			TokenExpiration: cfg.StepUp.TokenExpiration,
+		}, oAuthGrantStore, oAuthTokenStore, bizFlowStorage)
 
 	err = s.Start(func(s *grpc.Server) error {
 		userpb.RegisterUserServicerServer(s, us)
-		userpb.RegisterAuthenticatorUserPassServer(s, userpass.NewServer(instances.UserPassAuthenticator))
-		userpb.RegisterAuthenticatorGoogle2FaServer(s, google2fa.NewServer(instances.Google2FAAuthenticator))
-		userpb.RegisterAuthenticatorAdminServer(s, admin.NewServer(instances.AdminAuthenticator))
+		userpb.RegisterAuthenticatorUserPassServer(s, userpass.NewServer(instances.UserPassAuthenticator, bizFlowStorage))
+		userpb.RegisterAuthenticatorGoogle2FaServer(s, google2fa.NewServer(instances.Google2FAAuthenticator, bizFlowStorage))
+		userpb.RegisterAuthenticatorAdminServer(s, admin.NewServer(instances.AdminAuthenticator, instances.UserTokenManager))
 
 		return nil
 	})
@@ -71,11 +112,38 @@ func main() {
 			oAuthServer := oauthserver.NewOAuth2Server(oauthserver.OAuth2ServerConfigs{
 				URLLogin:          "/biz?op=login&redirected=oauth",
 				URLAuth:           "/auth",
+				URLConsent:        "/biz?op=consent&redirected=oauth",
 				ClientCredentials: cfg.OAuthClientCredentials,
-			}, oauthserver.NewLoginHelper(instances.UserTokenManager), nil)
+				Issuer:            cfg.OAuthIssuer,
+				IDTokenExpiration: cfg.OAuthIDTokenExpiration,
+				TokenStore:        oAuthTokenStore,
+				GrantStore:        oAuthGrantStore,
+			}, oauthserver.NewLoginHelper(instances.UserTokenManager, instances.UserManager,
+				instances.UserPassAuthenticator), instances.JWTKeyring, nil)
 			oAuthServer.Go(cfg.OAuthListen)
 		}()
 	}
 
 	s.Wait()
 }
+
+// newFileStorages users, token revocations and sessions survive restarts in cfg.DataFile.
+// The users go through sqlstorage.NewSQLDBModel, still unchecked against bizuserlib, see docs/protorepo.md.
+func newFileStorages(cfg *config.Config) (usertokenmanagerinters.JWTDataStorage, usertokenmanagerinters.SessionStorage,
+	authenticatorinters.DBModel, error) {
+	db, err := sql.Open(string(sqlstorage.DialectSQLite), "file:"+cfg.DataFile)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	db.SetMaxOpenConns(1)
+
+	err = sqlstorage.Migrate(context.Background(), db, sqlstorage.DialectSQLite)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	return sqlstorage.NewSQLJWTDataStorage(context.Background(), db, sqlstorage.DialectSQLite, 0, cfg.Logger),
+		sqlstorage.NewSQLSessionStorage(db, sqlstorage.DialectSQLite, cfg.Logger),
+		sqlstorage.NewSQLDBModel(db, sqlstorage.DialectSQLite, cfg.Logger), nil
+}
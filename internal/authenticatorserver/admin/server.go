@@ -5,27 +5,33 @@ import (
 
 	"github.com/s-min-sys/protorepo/gens/userpb"
 	"github.com/s-min-sys/userbe/internal/po"
+	"github.com/s-min-sys/userbe/internal/usertokenmanager/usertokenmanagerinters"
 	"github.com/sbasestarter/bizuserlib/authenticator/admin"
 	"github.com/sbasestarter/bizuserlib/bizuserinters"
 	"github.com/sgostarter/libeasygo/crypt/simencrypt"
 )
 
-func NewServer(authenticator admin.Authenticator) userpb.AuthenticatorAdminServer {
-	if authenticator == nil {
+func NewServer(authenticator admin.Authenticator, userTokenManager usertokenmanagerinters.UserTokenManager) userpb.AuthenticatorAdminServer {
+	if authenticator == nil || userTokenManager == nil {
 		return nil
 	}
 
 	return &serverImpl{
-		authenticator: authenticator,
+		authenticator:    authenticator,
+		userTokenManager: userTokenManager,
 	}
 }
 
 type serverImpl struct {
 	userpb.UnimplementedAuthenticatorAdminServer
 
-	authenticator admin.Authenticator
+	authenticator    admin.Authenticator
+	userTokenManager usertokenmanagerinters.UserTokenManager
 }
 
+// SetAdminFlag tokens carry the admin flag, so the user's tokens are revoked before the flag changes. The other
+// order leaves a window, or after a failed revocation no end, in which old tokens hold the old flag. A failed
+// SetAdmin leaves the user logged out, which is the safe side.
 func (impl *serverImpl) SetAdminFlag(ctx context.Context, request *userpb.SetAdminFlagRequest) (*userpb.SetAdminFlagResponse, error) {
 	if request == nil || request.ValidateAll() != nil {
 		return &userpb.SetAdminFlagResponse{
@@ -42,7 +48,10 @@ func (impl *serverImpl) SetAdminFlag(ctx context.Context, request *userpb.SetAdm
 		}, nil
 	}
 
-	status := impl.authenticator.SetAdmin(ctx, request.GetBizId(), userID, request.GetAdminFlag())
+	status := impl.userTokenManager.RevokeUserTokens(ctx, userID)
+	if status.Code == bizuserinters.StatusCodeOk {
+		status = impl.authenticator.SetAdmin(ctx, request.GetBizId(), userID, request.GetAdminFlag())
+	}
 
 	return &userpb.SetAdminFlagResponse{
 		Status: po.Status2Pb(status),
@@ -5,23 +5,28 @@ import (
 
 	"github.com/s-min-sys/protorepo/gens/userpb"
 	"github.com/s-min-sys/userbe/internal/po"
+	"github.com/s-min-sys/userbe/internal/usertokenmanager/usertokenmanagerinters"
 	"github.com/sbasestarter/bizuserlib/authenticator/google2fa"
+	"github.com/sbasestarter/bizuserlib/bizuserinters"
 )
 
-func NewServer(authenticator google2fa.Authenticator) userpb.AuthenticatorGoogle2FaServer {
-	if authenticator == nil {
+// NewServer completed authentications are recorded in bizFlowStorage, the user server puts them in the token.
+func NewServer(authenticator google2fa.Authenticator, bizFlowStorage usertokenmanagerinters.BizFlowStorage) userpb.AuthenticatorGoogle2FaServer {
+	if authenticator == nil || bizFlowStorage == nil {
 		return nil
 	}
 
 	return &serverImpl{
-		authenticator: authenticator,
+		authenticator:  authenticator,
+		bizFlowStorage: bizFlowStorage,
 	}
 }
 
 type serverImpl struct {
 	userpb.UnimplementedAuthenticatorGoogle2FaServer
 
-	authenticator google2fa.Authenticator
+	authenticator  google2fa.Authenticator
+	bizFlowStorage usertokenmanagerinters.BizFlowStorage
 }
 
 func (impl *serverImpl) GetSetupInfo(ctx context.Context, request *userpb.GetSetupInfoRequest) (*userpb.GetSetupInfoResponse, error) {
@@ -53,6 +58,8 @@ func (impl *serverImpl) DoSetup(ctx context.Context, request *userpb.DoSetupRequ
 
 	status := impl.authenticator.DoSetup(ctx, request.GetBizId(), request.GetCode())
 
+	status = impl.recordAuthMethod(ctx, request.GetBizId(), bizuserinters.AuthenticatorGoogle2FA, status)
+
 	return &userpb.DoSetupResponse{
 		Status: po.Status2Pb(status),
 	}, nil
@@ -69,7 +76,27 @@ func (impl *serverImpl) Verify(ctx context.Context, request *userpb.VerifyReques
 
 	status := impl.authenticator.Verify(ctx, request.GetBizId(), request.GetCode())
 
+	status = impl.recordAuthMethod(ctx, request.GetBizId(), bizuserinters.AuthenticatorGoogle2FA, status)
+
 	return &userpb.VerifyResponse{
 		Status: po.Status2Pb(status),
 	}, nil
 }
+
+//
+//
+//
+
+func (impl *serverImpl) recordAuthMethod(ctx context.Context, bizID string, authenticator bizuserinters.AuthenticatorIdentity,
+	status bizuserinters.Status) bizuserinters.Status {
+	if status.Code != bizuserinters.StatusCodeOk {
+		return status
+	}
+
+	err := impl.bizFlowStorage.AddAuthMethod(ctx, bizID, string(authenticator))
+	if err != nil {
+		return bizuserinters.MakeStatusByError(bizuserinters.StatusCodeInternalError, err)
+	}
+
+	return status
+}
@@ -5,23 +5,28 @@ import (
 
 	"github.com/s-min-sys/protorepo/gens/userpb"
 	"github.com/s-min-sys/userbe/internal/po"
+	"github.com/s-min-sys/userbe/internal/usertokenmanager/usertokenmanagerinters"
 	"github.com/sbasestarter/bizuserlib/authenticator/userpass"
+	"github.com/sbasestarter/bizuserlib/bizuserinters"
 )
 
-func NewServer(authenticator userpass.Authenticator) userpb.AuthenticatorUserPassServer {
-	if authenticator == nil {
+// NewServer completed authentications are recorded in bizFlowStorage, the user server puts them in the token.
+func NewServer(authenticator userpass.Authenticator, bizFlowStorage usertokenmanagerinters.BizFlowStorage) userpb.AuthenticatorUserPassServer {
+	if authenticator == nil || bizFlowStorage == nil {
 		return nil
 	}
 
 	return &serverImpl{
-		authenticator: authenticator,
+		authenticator:  authenticator,
+		bizFlowStorage: bizFlowStorage,
 	}
 }
 
 type serverImpl struct {
 	userpb.UnimplementedAuthenticatorUserPassServer
 
-	authenticator userpass.Authenticator
+	authenticator  userpass.Authenticator
+	bizFlowStorage usertokenmanagerinters.BizFlowStorage
 }
 
 func (impl *serverImpl) Register(ctx context.Context, request *userpb.RegisterRequest) (*userpb.RegisterResponse, error) {
@@ -35,6 +40,8 @@ func (impl *serverImpl) Register(ctx context.Context, request *userpb.RegisterRe
 
 	status := impl.authenticator.Register(ctx, request.GetBizId(), request.GetUserName(), request.GetPassword())
 
+	status = impl.recordAuthMethod(ctx, request.GetBizId(), bizuserinters.AuthenticatorUserPass, status)
+
 	return &userpb.RegisterResponse{
 		Status: po.Status2Pb(status),
 	}, nil
@@ -51,6 +58,8 @@ func (impl *serverImpl) Login(ctx context.Context, request *userpb.LoginRequest)
 
 	status := impl.authenticator.Login(ctx, request.GetBizId(), request.GetUserName(), request.GetPassword())
 
+	status = impl.recordAuthMethod(ctx, request.GetBizId(), bizuserinters.AuthenticatorUserPass, status)
+
 	return &userpb.LoginResponse{
 		Status: po.Status2Pb(status),
 	}, nil
@@ -87,3 +96,21 @@ func (impl *serverImpl) ChangePassword(ctx context.Context, request *userpb.Chan
 		Status: po.Status2Pb(status),
 	}, nil
 }
+
+//
+//
+//
+
+func (impl *serverImpl) recordAuthMethod(ctx context.Context, bizID string, authenticator bizuserinters.AuthenticatorIdentity,
+	status bizuserinters.Status) bizuserinters.Status {
+	if status.Code != bizuserinters.StatusCodeOk {
+		return status
+	}
+
+	err := impl.bizFlowStorage.AddAuthMethod(ctx, bizID, string(authenticator))
+	if err != nil {
+		return bizuserinters.MakeStatusByError(bizuserinters.StatusCodeInternalError, err)
+	}
+
+	return status
+}
@@ -2,6 +2,7 @@ package config
 
 import (
 	"sync"
+	"time"
 
 	"github.com/sgostarter/i/l"
 	"github.com/sgostarter/libconfig"
@@ -19,11 +20,29 @@ type Config struct {
 	RedisDSN     string `yaml:"RedisDSN"`
 	UserMongoDSN string `yaml:"UserMongoDSN"`
 
+	// SQLDriver is postgres or sqlite3, used by cmd/allinone/sql and by OAuthStorage sql
+	SQLDriver string `yaml:"SQLDriver"`
+	SQLDSN    string `yaml:"SQLDSN"`
+	// DataFile keeps the users, token blacklist and sessions of cmd/allinone/mem in a sqlite file across restarts
+	// (the user model is pending the bizuserlib check in docs/protorepo.md)
+	DataFile string `yaml:"DataFile"`
+
 	DefaultDomain string `yaml:"DefaultDomain"`
+	// TrustedProxies are the cidrs or ips whose x-forwarded-for and x-real-ip headers name the client ip
+	TrustedProxies []string `yaml:"TrustedProxies"`
+
+	UserTokenKeys UserTokenKeys   `yaml:"UserTokenKeys"`
+	UserToken     UserTokenConfig `yaml:"UserToken"`
+	StepUp        StepUpConfig    `yaml:"StepUp"`
 
 	DebugCfg DebugCfg `yaml:"DebugCfg"`
 
 	OAuthListen string `yaml:"OAuthListen"`
+	// OAuthStorage is memory, redis or sql(SQLDriver and SQLDSN), the production binary defaults to redis
+	OAuthStorage string `yaml:"OAuthStorage"`
+	// OAuthIssuer is the external URL of the OAuth server, the OpenID Connect iss
+	OAuthIssuer            string        `yaml:"OAuthIssuer"`
+	OAuthIDTokenExpiration time.Duration `yaml:"OAuthIDTokenExpiration"`
 
 	OAuthClientCredentials map[string]OAuthClientCredential `yaml:"OAuthClientCredentials"`
 }
@@ -37,9 +56,87 @@ type DebugCfg struct {
 	AuthenticatorGoogle2FA *DebugCfgAuthenticatorGoogle2FA `yaml:"AuthenticatorGoogle2FA"`
 }
 
+// UserTokenKey Algorithm is one of HS256(default), RS256, ES256 and EdDSA. HS256 uses Secret,
+// the others read PEM files, a verification-only key needs PublicKeyFile only.
+type UserTokenKey struct {
+	ID             string `yaml:"ID"`
+	Algorithm      string `yaml:"Algorithm"`
+	Secret         string `yaml:"Secret"`
+	PrivateKeyFile string `yaml:"PrivateKeyFile"`
+	PublicKeyFile  string `yaml:"PublicKeyFile"`
+}
+
+// UserTokenKeys keys other than SigningKeyID only verify tokens, keep them until the tokens they signed expire.
+// With opaque user tokens the keys are optional, they only sign OpenID Connect id tokens.
+type UserTokenKeys struct {
+	SigningKeyID string         `yaml:"SigningKeyID"`
+	Keys         []UserTokenKey `yaml:"Keys"`
+}
+
+type UserTokenConfig struct {
+	// Type is jwt(default) or opaque, opaque tokens are kept in redis and need no keys
+	Type string `yaml:"Type"`
+	// Expiration is the session lifetime, renewals slide it
+	Expiration time.Duration `yaml:"Expiration"`
+	// RememberMeExpiration replaces Expiration when the login asks to be remembered
+	RememberMeExpiration time.Duration `yaml:"RememberMeExpiration"`
+	// ClientExpirations replace Expiration per x-client-type header or origin domain
+	ClientExpirations map[string]time.Duration `yaml:"ClientExpirations"`
+	// MaxSessionAge bounds renewals from the login on, zero means no bound
+	MaxSessionAge         time.Duration `yaml:"MaxSessionAge"`
+	AccessTokenExpiration time.Duration `yaml:"AccessTokenExpiration"`
+	// StorageKeyPrefix namespaces the token keys, set it when deployments share one redis
+	StorageKeyPrefix string `yaml:"StorageKeyPrefix"`
+	// RevocationCacheExpiration bounds how long a replica may miss a revocation whose pub/sub message was lost
+	RevocationCacheExpiration time.Duration `yaml:"RevocationCacheExpiration"`
+	// StorageFailurePolicy is closed(default), open or grace, it decides whether tokens are accepted while the
+	// revocation storage is down
+	StorageFailurePolicy string `yaml:"StorageFailurePolicy"`
+	// StorageFailureGrace is how long grace accepts tokens after the storage last answered
+	StorageFailureGrace time.Duration `yaml:"StorageFailureGrace"`
+	// Issuer and Audience should differ between environments, tokens of one are rejected by the others
+	Issuer          string        `yaml:"Issuer"`
+	Audience        string        `yaml:"Audience"`
+	ClockSkewLeeway time.Duration `yaml:"ClockSkewLeeway"`
+}
+
+// StepUpConfig ChangeBegin and DeleteBegin need a login within RecentAuthAge or one that verified any of
+// AuthMethods, otherwise the client runs a step-up login and gets a token living TokenExpiration.
+type StepUpConfig struct {
+	RecentAuthAge   time.Duration `yaml:"RecentAuthAge"`
+	AuthMethods     []string      `yaml:"AuthMethods"`
+	TokenExpiration time.Duration `yaml:"TokenExpiration"`
+}
+
+const (
+	UserTokenTypeJWT    = "jwt"
+	UserTokenTypeOpaque = "opaque"
+)
+
+const (
+	OAuthStorageMemory = "memory"
+	OAuthStorageRedis  = "redis"
+	OAuthStorageSQL    = "sql"
+)
+
+// OAuthClientCredential Name is shown on the consent screen, the client id is shown when it's empty.
+// RedirectURIs must match exactly, without them redirect uris must be under Domain.
+// GrantTypes are authorization_code, password, client_credentials, refresh_token and implicit, the default is
+// authorization_code and refresh_token. The password grant is honored for FirstParty clients only.
+// Empty Scopes allow any scope. Public clients have no secret and always need PKCE with S256.
+// Zero token expirations keep the server defaults.
 type OAuthClientCredential struct {
-	Secret string `yaml:"Secret"`
-	Domain string `yaml:"Domain"`
+	Name                   string        `yaml:"Name"`
+	Secret                 string        `yaml:"Secret"`
+	Domain                 string        `yaml:"Domain"`
+	RedirectURIs           []string      `yaml:"RedirectURIs"`
+	GrantTypes             []string      `yaml:"GrantTypes"`
+	Scopes                 []string      `yaml:"Scopes"`
+	Public                 bool          `yaml:"Public"`
+	RequirePKCE            bool          `yaml:"RequirePKCE"`
+	FirstParty             bool          `yaml:"FirstParty"`
+	AccessTokenExpiration  time.Duration `yaml:"AccessTokenExpiration"`
+	RefreshTokenExpiration time.Duration `yaml:"RefreshTokenExpiration"`
 }
 
 var (
@@ -0,0 +1,219 @@
+package oauthserver
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/go-oauth2/oauth2/v4"
+	"github.com/go-oauth2/oauth2/v4/errors"
+	"github.com/go-oauth2/oauth2/v4/manage"
+	"github.com/go-oauth2/oauth2/v4/server"
+	"github.com/s-min-sys/userbe/internal/config"
+)
+
+// grantTypes config.OAuthClientCredential names grant types as in RFC 6749.
+var grantTypes = map[string]oauth2.GrantType{
+	"authorization_code": oauth2.AuthorizationCode,
+	"password":           oauth2.PasswordCredentials,
+	"client_credentials": oauth2.ClientCredentials,
+	"refresh_token":      oauth2.Refreshing,
+	"implicit":           oauth2.Implicit,
+}
+
+var defaultGrantTypes = []oauth2.GrantType{oauth2.AuthorizationCode, oauth2.Refreshing}
+
+// clientAuthorizedHandler clients which aren't configured get the default grant types.
+func (impl *oAuthServer2Impl) clientAuthorizedHandler(clientID string, grant oauth2.GrantType) (allowed bool, err error) {
+	client := impl.configs.ClientCredentials[clientID]
+
+	switch {
+	case grant == oauth2.PasswordCredentials && !client.FirstParty:
+		return
+	case grant == oauth2.ClientCredentials && client.Public:
+		return
+	}
+
+	if len(client.GrantTypes) == 0 {
+		for _, gt := range defaultGrantTypes {
+			if gt == grant {
+				allowed = true
+
+				return
+			}
+		}
+
+		return
+	}
+
+	for _, name := range client.GrantTypes {
+		if grantTypes[name] == grant {
+			allowed = true
+
+			return
+		}
+	}
+
+	return
+}
+
+// clientScopeHandler clients without configured scopes may ask for any scope.
+func (impl *oAuthServer2Impl) clientScopeHandler(tgr *oauth2.TokenGenerateRequest) (allowed bool, err error) {
+	client := impl.configs.ClientCredentials[tgr.ClientID]
+	if len(client.Scopes) == 0 {
+		allowed = true
+
+		return
+	}
+
+	for _, s := range strings.Fields(tgr.Scope) {
+		if !containsScope(client.Scopes, s) {
+			return
+		}
+	}
+
+	allowed = true
+
+	return
+}
+
+// refreshingScopeHandler a refreshed token can't get more scopes than the refresh token has.
+func (impl *oAuthServer2Impl) refreshingScopeHandler(tgr *oauth2.TokenGenerateRequest, oldScope string) (allowed bool, err error) {
+	for _, s := range strings.Fields(tgr.Scope) {
+		if !hasScope(oldScope, s) {
+			return
+		}
+	}
+
+	allowed = true
+
+	return
+}
+
+// checkTokenRequest go-oauth2 checks the client secret after the password and never for refresh tokens, nor
+// does it check that the refresh token belongs to the client.
+func (impl *oAuthServer2Impl) checkTokenRequest(r *http.Request, srv *server.Server, manager *manage.Manager) error {
+	grantType := oauth2.GrantType(r.FormValue("grant_type"))
+	if grantType != oauth2.PasswordCredentials && grantType != oauth2.Refreshing {
+		return nil
+	}
+
+	clientID, err := impl.authenticateClient(r, srv, manager)
+	if err != nil {
+		return errors.ErrInvalidClient
+	}
+
+	if grantType != oauth2.Refreshing {
+		return nil
+	}
+
+	ti, err := manager.LoadRefreshToken(r.Context(), r.FormValue("refresh_token"))
+	if err != nil || ti.GetClientID() != clientID {
+		return errors.ErrInvalidGrant
+	}
+
+	return nil
+}
+
+// checkAuthorizeRequest a request with a bad redirect uri is answered here, go-oauth2 would redirect the error
+// to it. go-oauth2 checks grant type and scopes too but only after the user consented.
+func (impl *oAuthServer2Impl) checkAuthorizeRequest(ctx context.Context, w http.ResponseWriter, r *http.Request) (ok bool, err error) {
+	clientID := r.FormValue("client_id")
+
+	client, err := impl.configs.ClientStore.GetByID(ctx, clientID)
+	if err != nil || !impl.validRedirectURI(clientID, client.GetDomain(), r.FormValue("redirect_uri")) {
+		http.Error(w, errors.ErrInvalidRequest.Error(), http.StatusBadRequest)
+
+		err = nil
+
+		return
+	}
+
+	responseType := oauth2.ResponseType(r.FormValue("response_type"))
+
+	grantType := oauth2.AuthorizationCode
+	if responseType == oauth2.Token {
+		grantType = oauth2.Implicit
+	}
+
+	if allowed, _ := impl.clientAuthorizedHandler(clientID, grantType); !allowed {
+		err = errors.ErrUnauthorizedClient
+
+		return
+	}
+
+	if allowed, _ := impl.clientScopeHandler(&oauth2.TokenGenerateRequest{
+		ClientID: clientID,
+		Scope:    r.FormValue("scope"),
+	}); !allowed {
+		err = errors.ErrInvalidScope
+
+		return
+	}
+
+	clientCfg := impl.configs.ClientCredentials[clientID]
+	if responseType == oauth2.Code && (clientCfg.RequirePKCE || clientCfg.Public) && r.FormValue("code_challenge") == "" {
+		err = errors.ErrCodeChallengeRquired
+
+		return
+	}
+
+	// only S256 is advertised, plain would hand the verifier to whoever sees the authorize request
+	if r.FormValue("code_challenge") != "" &&
+		oauth2.CodeChallengeMethod(r.FormValue("code_challenge_method")) != oauth2.CodeChallengeS256 {
+		err = errors.ErrUnsupportedCodeChallengeMethod
+
+		return
+	}
+
+	ok = true
+
+	return
+}
+
+//
+//
+//
+
+// validRedirectURI configured redirect uris must match exactly, otherwise the uri must be under the client domain.
+// An empty uri means the client domain, go-oauth2 redirects to it.
+func (impl *oAuthServer2Impl) validRedirectURI(clientID, domain, redirectURI string) bool {
+	redirectURIs := impl.configs.ClientCredentials[clientID].RedirectURIs
+	if len(redirectURIs) == 0 {
+		return redirectURI == "" || manage.DefaultValidateURI(domain, redirectURI) == nil
+	}
+
+	if redirectURI == "" {
+		redirectURI = domain
+	}
+
+	for _, uri := range redirectURIs {
+		if uri == redirectURI {
+			return true
+		}
+	}
+
+	return false
+}
+
+// clientLifetimeAccessGenerate the manager knows token lifetimes per grant type only, the generator sees the
+// token before it's stored.
+type clientLifetimeAccessGenerate struct {
+	oauth2.AccessGenerate
+	clients map[string]config.OAuthClientCredential
+}
+
+func (g *clientLifetimeAccessGenerate) Token(ctx context.Context, data *oauth2.GenerateBasic, isGenRefresh bool) (
+	access, refresh string, err error) {
+	client := g.clients[data.Client.GetID()]
+
+	if client.AccessTokenExpiration > 0 {
+		data.TokenInfo.SetAccessExpiresIn(client.AccessTokenExpiration)
+	}
+
+	if isGenRefresh && client.RefreshTokenExpiration > 0 {
+		data.TokenInfo.SetRefreshExpiresIn(client.RefreshTokenExpiration)
+	}
+
+	return g.AccessGenerate.Token(ctx, data, isGenRefresh)
+}
@@ -0,0 +1,221 @@
+package oauthserver
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-oauth2/oauth2/v4/errors"
+	"github.com/go-oauth2/oauth2/v4/manage"
+	"github.com/go-session/session"
+	"github.com/s-min-sys/userbe/internal/usertokenmanager/usertokenmanagerinters"
+	"github.com/sgostarter/i/l"
+)
+
+// scopesGranted a client which asks for no scope still needs to be approved once.
+func (impl *oAuthServer2Impl) scopesGranted(ctx context.Context, userID, clientID, scope string) (granted bool, err error) {
+	if impl.configs.URLConsent == "" {
+		granted = true
+
+		return
+	}
+
+	uid, err := strconv.ParseUint(userID, 10, 64)
+	if err != nil {
+		return
+	}
+
+	grant, err := impl.configs.GrantStore.GetGrant(ctx, uid, clientID)
+	if err != nil || grant == nil {
+		return
+	}
+
+	for _, s := range strings.Fields(scope) {
+		if !containsScope(grant.Scopes, s) {
+			return
+		}
+	}
+
+	granted = true
+
+	return
+}
+
+// handleConsentInfo tells the consent page which client asks for which scopes, consent_token must be posted
+// back with the decision.
+func (impl *oAuthServer2Impl) handleConsentInfo(w http.ResponseWriter, r *http.Request, manager *manage.Manager) {
+	storage, err := session.Start(r.Context(), w, r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+
+		return
+	}
+
+	_, form, consentToken, ok := pendingConsent(storage)
+	if !ok {
+		impl.writeJSON(w, http.StatusBadRequest, map[string]interface{}{
+			"error": errors.ErrInvalidRequest.Error(),
+		})
+
+		return
+	}
+
+	clientName, err := impl.consentClientName(r.Context(), manager, form)
+	if err != nil {
+		impl.writeJSON(w, http.StatusBadRequest, map[string]interface{}{
+			"error": err.Error(),
+		})
+
+		return
+	}
+
+	impl.writeJSON(w, http.StatusOK, map[string]interface{}{
+		"client_id":     form.Get("client_id"),
+		"client_name":   clientName,
+		"scopes":        strings.Fields(form.Get("scope")),
+		"consent_token": consentToken,
+	})
+}
+
+// handleConsent approve=true saves the grant, either way the user goes back to /oauth/authorize which finishes
+// the pending request.
+func (impl *oAuthServer2Impl) handleConsent(w http.ResponseWriter, r *http.Request, manager *manage.Manager) {
+	storage, err := session.Start(r.Context(), w, r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+
+		return
+	}
+
+	userID, form, consentToken, ok := pendingConsent(storage)
+	if !ok || r.PostFormValue("consent_token") != consentToken {
+		http.Error(w, errors.ErrInvalidRequest.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	clientName, err := impl.consentClientName(r.Context(), manager, form)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	storage.Delete(SessionKeyConsentToken)
+
+	if approved, _ := strconv.ParseBool(r.PostFormValue("approve")); approved {
+		err = impl.saveGrant(r.Context(), userID, form.Get("client_id"), clientName, strings.Fields(form.Get("scope")))
+		if err != nil {
+			impl.logger.WithFields(l.ErrorField(err)).Error("save grant failed")
+
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+
+			return
+		}
+	} else {
+		storage.Set(SessionKeyConsentDenied, true)
+	}
+
+	err = storage.Save()
+	if err != nil {
+		impl.logger.WithFields(l.ErrorField(err)).Error("save consent failed")
+
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+
+		return
+	}
+
+	impl.httpLocationTo(w, "/oauth/authorize")
+}
+
+//
+//
+//
+
+// consentClientName the consent page must not be shown for a request which would fail.
+func (impl *oAuthServer2Impl) consentClientName(ctx context.Context, manager *manage.Manager, form url.Values) (
+	clientName string, err error) {
+	clientID := form.Get("client_id")
+
+	client, err := manager.GetClient(ctx, clientID)
+	if err != nil {
+		return
+	}
+
+	if !impl.validRedirectURI(clientID, client.GetDomain(), form.Get("redirect_uri")) {
+		err = errors.ErrInvalidRequest
+
+		return
+	}
+
+	clientName = impl.configs.ClientCredentials[clientID].Name
+	if clientName == "" {
+		clientName = clientID
+	}
+
+	return
+}
+
+// saveGrant scopes approved before are kept.
+func (impl *oAuthServer2Impl) saveGrant(ctx context.Context, userID uint64, clientID, clientName string, scopes []string) error {
+	grant, err := impl.configs.GrantStore.GetGrant(ctx, userID, clientID)
+	if err != nil {
+		return err
+	}
+
+	if grant != nil {
+		for _, s := range grant.Scopes {
+			if !containsScope(scopes, s) {
+				scopes = append(scopes, s)
+			}
+		}
+	}
+
+	return impl.configs.GrantStore.SaveGrant(ctx, &usertokenmanagerinters.OAuthGrant{
+		UserID:     userID,
+		ClientID:   clientID,
+		ClientName: clientName,
+		Scopes:     scopes,
+		GrantedAt:  time.Now(),
+	})
+}
+
+func pendingConsent(storage session.Store) (userID uint64, form url.Values, consentToken string, ok bool) {
+	uid, ok := storage.Get(SessionKeyLoggedInUserID)
+	if !ok {
+		return
+	}
+
+	v, ok := storage.Get(SessionKeyReturnURI)
+	if !ok {
+		return
+	}
+
+	t, ok := storage.Get(SessionKeyConsentToken)
+	if !ok {
+		return
+	}
+
+	uidS, _ := uid.(string)
+	form, _ = v.(url.Values)
+	consentToken, _ = t.(string)
+
+	userID, err := strconv.ParseUint(uidS, 10, 64)
+
+	ok = err == nil && form != nil && consentToken != ""
+
+	return
+}
+
+func containsScope(scopes []string, s string) bool {
+	for _, v := range scopes {
+		if v == s {
+			return true
+		}
+	}
+
+	return false
+}
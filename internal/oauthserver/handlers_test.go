@@ -0,0 +1,472 @@
+package oauthserver
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/go-oauth2/oauth2/v4"
+	"github.com/go-oauth2/oauth2/v4/errors"
+	"github.com/gorilla/mux"
+	"github.com/s-min-sys/userbe/internal/config"
+	"github.com/s-min-sys/userbe/internal/usertokenmanager"
+	"github.com/s-min-sys/userbe/internal/usertokenmanager/usertokenmanagerinters"
+	"github.com/sbasestarter/bizuserlib/bizuserinters"
+	"github.com/sgostarter/libeasygo/crypt/simencrypt"
+)
+
+const (
+	testUserID      = 7
+	testRedirectURI = "http://client.test/cb"
+	testVerifier    = "0123456789012345678901234567890123456789012345"
+)
+
+type fakeLoginHelper struct{}
+
+func (fakeLoginHelper) CheckHTTPLogin(*http.Request) (*usertokenmanagerinters.UserTokenInfo, bool) {
+	return &usertokenmanagerinters.UserTokenInfo{
+		ID:          testUserID,
+		UserName:    "alice",
+		AuthAt:      time.Unix(1000, 0),
+		AuthMethods: []string{"pwd"},
+	}, true
+}
+
+func (fakeLoginHelper) GetUser(_ context.Context, userID uint64) (*bizuserinters.UserInfo, bool) {
+	return &bizuserinters.UserInfo{ID: userID, UserName: "alice"}, userID == testUserID
+}
+
+func (fakeLoginHelper) PasswordLogin(_ context.Context, userName, password string) (*bizuserinters.UserInfo, error) {
+	switch {
+	case userName == "2fa":
+		return nil, ErrSecondFactorRequired
+	case password != "pwd":
+		return nil, errors.ErrInvalidGrant
+	}
+
+	return &bizuserinters.UserInfo{ID: testUserID, UserName: userName}, nil
+}
+
+type testOAuthServer struct {
+	*httptest.Server
+	impl    *oAuthServer2Impl
+	keyring usertokenmanager.JWTKeyring
+	client  *http.Client
+}
+
+func newTestOAuthServer(t *testing.T) *testOAuthServer {
+	t.Helper()
+
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ecDer, err := x509.MarshalECPrivateKey(ecKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyring, err := usertokenmanager.NewJWTKeyring("k", []usertokenmanager.JWTKey{{
+		ID:            "k",
+		Algorithm:     usertokenmanager.JWTAlgorithmES256,
+		PrivateKeyPEM: pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: ecDer}),
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &testOAuthServer{keyring: keyring}
+
+	router := mux.NewRouter()
+	s.Server = httptest.NewServer(router)
+
+	s.impl, _ = NewOAuth2Server(OAuth2ServerConfigs{
+		URLLogin: "/login",
+		ClientCredentials: map[string]config.OAuthClientCredential{
+			"web": {Secret: "web-secret", RedirectURIs: []string{testRedirectURI}, RequirePKCE: true,
+				Scopes: []string{ScopeOpenID, ScopeProfile}},
+			"app": {Public: true, RedirectURIs: []string{testRedirectURI}},
+			"cli": {Secret: "cli-secret", FirstParty: true, GrantTypes: []string{"password", "refresh_token"}},
+			"3rd": {Secret: "3rd-secret", GrantTypes: []string{"password"}},
+		},
+		Issuer: s.URL,
+	}, fakeLoginHelper{}, keyring, nil).(*oAuthServer2Impl)
+	s.impl.installHandlers(router)
+
+	jar, _ := cookiejar.New(nil)
+	s.client = &http.Client{
+		Jar: jar,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	t.Cleanup(s.Close)
+
+	return s
+}
+
+func (s *testOAuthServer) do(t *testing.T, method, path string, form url.Values, header http.Header) (
+	*http.Response, map[string]interface{}) {
+	t.Helper()
+
+	req, err := http.NewRequest(method, s.URL+path, strings.NewReader(form.Encode()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	for k := range header {
+		req.Header.Set(k, header.Get(k))
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer resp.Body.Close()
+
+	var data map[string]interface{}
+
+	_ = json.NewDecoder(resp.Body).Decode(&data)
+
+	return resp, data
+}
+
+// authorize follows the login round trip, the returned location is the redirect to the client.
+func (s *testOAuthServer) authorize(t *testing.T, query url.Values) *url.URL {
+	t.Helper()
+
+	resp, _ := s.do(t, http.MethodGet, "/oauth/authorize?"+query.Encode(), nil, nil)
+	if resp.StatusCode == http.StatusFound && resp.Header.Get("Location") == "/login" {
+		_, _ = s.do(t, http.MethodGet, "/oauth/auth", nil, nil)
+		resp, _ = s.do(t, http.MethodGet, "/oauth/authorize", nil, nil)
+	}
+
+	if resp.StatusCode != http.StatusFound {
+		t.Fatal("authorize", resp.StatusCode)
+	}
+
+	location, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return location
+}
+
+func (s *testOAuthServer) passwordToken(t *testing.T, clientID, secret, userName string) (int, map[string]interface{}) {
+	t.Helper()
+
+	resp, data := s.do(t, http.MethodPost, "/oauth/token", url.Values{
+		"grant_type":    {"password"},
+		"client_id":     {clientID},
+		"client_secret": {secret},
+		"username":      {userName},
+		"password":      {"pwd"},
+	}, nil)
+
+	return resp.StatusCode, data
+}
+
+func TestClientAuthorizedHandler(t *testing.T) {
+	impl := &oAuthServer2Impl{configs: OAuth2ServerConfigs{ClientCredentials: map[string]config.OAuthClientCredential{
+		"first":  {FirstParty: true, GrantTypes: []string{"password"}},
+		"third":  {GrantTypes: []string{"password", "client_credentials"}},
+		"public": {Public: true, GrantTypes: []string{"client_credentials", "authorization_code"}},
+	}}}
+
+	cases := []struct {
+		clientID string
+		grant    oauth2.GrantType
+		allowed  bool
+	}{
+		{"first", oauth2.PasswordCredentials, true},
+		{"first", oauth2.AuthorizationCode, false},
+		{"third", oauth2.PasswordCredentials, false},
+		{"third", oauth2.ClientCredentials, true},
+		{"public", oauth2.ClientCredentials, false},
+		{"public", oauth2.AuthorizationCode, true},
+		{"unknown", oauth2.AuthorizationCode, true},
+		{"unknown", oauth2.Refreshing, true},
+		{"unknown", oauth2.Implicit, false},
+		{"unknown", oauth2.PasswordCredentials, false},
+	}
+
+	for _, c := range cases {
+		if allowed, _ := impl.clientAuthorizedHandler(c.clientID, c.grant); allowed != c.allowed {
+			t.Error(c.clientID, c.grant, allowed)
+		}
+	}
+}
+
+func TestClientScopeHandler(t *testing.T) {
+	impl := &oAuthServer2Impl{configs: OAuth2ServerConfigs{ClientCredentials: map[string]config.OAuthClientCredential{
+		"limited": {Scopes: []string{ScopeOpenID, ScopeProfile}},
+	}}}
+
+	cases := []struct {
+		clientID string
+		scope    string
+		allowed  bool
+	}{
+		{"limited", "openid profile", true},
+		{"limited", "", true},
+		{"limited", "openid admin", false},
+		{"any", "admin", true},
+	}
+
+	for _, c := range cases {
+		if allowed, _ := impl.clientScopeHandler(&oauth2.TokenGenerateRequest{ClientID: c.clientID, Scope: c.scope}); allowed != c.allowed {
+			t.Error(c.clientID, c.scope, allowed)
+		}
+	}
+}
+
+func TestValidRedirectURI(t *testing.T) {
+	impl := &oAuthServer2Impl{configs: OAuth2ServerConfigs{ClientCredentials: map[string]config.OAuthClientCredential{
+		"fixed": {RedirectURIs: []string{"https://a.test/cb", "https://b.test/cb"}},
+	}}}
+
+	cases := []struct {
+		clientID    string
+		domain      string
+		redirectURI string
+		valid       bool
+	}{
+		{"fixed", "https://a.test/cb", "https://b.test/cb", true},
+		{"fixed", "https://a.test/cb", "", true},
+		{"fixed", "https://a.test/cb", "https://a.test/cb/x", false},
+		{"fixed", "https://a.test/cb", "https://evil.test/cb", false},
+		{"domain", "https://a.test", "https://a.test/any", true},
+		{"domain", "https://a.test", "", true},
+		{"domain", "https://a.test", "https://evil.test/any", false},
+	}
+
+	for _, c := range cases {
+		if impl.validRedirectURI(c.clientID, c.domain, c.redirectURI) != c.valid {
+			t.Error(c.clientID, c.redirectURI)
+		}
+	}
+}
+
+func TestPasswordGrant(t *testing.T) {
+	s := newTestOAuthServer(t)
+
+	code, data := s.passwordToken(t, "cli", "cli-secret", "alice")
+	if code != http.StatusOK || data["access_token"] == nil {
+		t.Fatal(code, data)
+	}
+
+	// a second factor can't be asked for, the client is told to use the code flow
+	code, data = s.passwordToken(t, "cli", "cli-secret", "2fa")
+	if code != http.StatusBadRequest || data["error"] != "invalid_grant" ||
+		!strings.Contains(data["error_description"].(string), "second factor") {
+		t.Fatal(code, data)
+	}
+
+	if code, data = s.passwordToken(t, "3rd", "3rd-secret", "alice"); data["access_token"] != nil {
+		t.Fatal("third party clients must not collect passwords", code, data)
+	}
+
+	if code, data = s.passwordToken(t, "cli", "bad", "alice"); code != http.StatusUnauthorized || data["error"] != "invalid_client" {
+		t.Fatal(code, data)
+	}
+}
+
+func TestPKCE(t *testing.T) {
+	s := newTestOAuthServer(t)
+
+	query := func(clientID, challenge, method string) url.Values {
+		q := url.Values{
+			"client_id":     {clientID},
+			"response_type": {"code"},
+			"redirect_uri":  {testRedirectURI},
+			"state":         {"st"},
+		}
+
+		if challenge != "" {
+			q.Set("code_challenge", challenge)
+			q.Set("code_challenge_method", method)
+		}
+
+		return q
+	}
+
+	challenge := genCodeChallengeS256(testVerifier)
+
+	cases := []struct {
+		name  string
+		query url.Values
+	}{
+		{"public client without challenge", query("app", "", "")},
+		{"pkce client without challenge", query("web", "", "")},
+		{"plain", query("web", testVerifier, "plain")},
+		{"no method", query("web", testVerifier, "")},
+	}
+
+	for _, c := range cases {
+		if location := s.authorize(t, c.query); location.Query().Get("error") != "invalid_request" {
+			t.Error(c.name, location)
+		}
+	}
+
+	location := s.authorize(t, query("app", challenge, "S256"))
+
+	code := location.Query().Get("code")
+	if code == "" {
+		t.Fatal(location)
+	}
+
+	exchange := url.Values{
+		"grant_type":   {"authorization_code"},
+		"client_id":    {"app"},
+		"code":         {code},
+		"redirect_uri": {testRedirectURI},
+	}
+
+	exchange.Set("code_verifier", testVerifier+"x")
+
+	if resp, data := s.do(t, http.MethodPost, "/oauth/token", exchange, nil); resp.StatusCode == http.StatusOK {
+		t.Fatal("wrong verifier", data)
+	}
+
+	location = s.authorize(t, query("app", challenge, "S256"))
+	exchange.Set("code", location.Query().Get("code"))
+	exchange.Set("code_verifier", testVerifier)
+
+	if resp, data := s.do(t, http.MethodPost, "/oauth/token", exchange, nil); resp.StatusCode != http.StatusOK {
+		t.Fatal(resp.StatusCode, data)
+	}
+}
+
+func TestOpenIDConnect(t *testing.T) {
+	s := newTestOAuthServer(t)
+
+	resp, discovery := s.do(t, http.MethodGet, "/.well-known/openid-configuration", nil, nil)
+	if resp.StatusCode != http.StatusOK || discovery["issuer"] != s.URL ||
+		discovery["token_endpoint"] != s.URL+"/oauth/token" || discovery["jwks_uri"] != s.URL+"/.well-known/jwks.json" {
+		t.Fatal(discovery)
+	}
+
+	if algs, _ := discovery["id_token_signing_alg_values_supported"].([]interface{}); len(algs) != 1 || algs[0] != "ES256" {
+		t.Fatal(discovery)
+	}
+
+	location := s.authorize(t, url.Values{
+		"client_id":             {"web"},
+		"response_type":         {"code"},
+		"redirect_uri":          {testRedirectURI},
+		"scope":                 {"openid profile"},
+		"state":                 {"st"},
+		"nonce":                 {"n-1"},
+		"code_challenge":        {genCodeChallengeS256(testVerifier)},
+		"code_challenge_method": {"S256"},
+	})
+
+	resp, data := s.do(t, http.MethodPost, "/oauth/token", url.Values{
+		"grant_type":    {"authorization_code"},
+		"client_id":     {"web"},
+		"client_secret": {"web-secret"},
+		"code":          {location.Query().Get("code")},
+		"redirect_uri":  {testRedirectURI},
+		"code_verifier": {testVerifier},
+	}, nil)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatal(resp.StatusCode, data)
+	}
+
+	rawIDToken, _ := data["id_token"].(string)
+
+	claims := &IDTokenClaims{}
+
+	_, err := jwt.ParseWithClaims(rawIDToken, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header[jwtHeaderKeyID].(string)
+		_, key, err := s.keyring.VerifyingKey(kid)
+
+		return key, err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if claims.Issuer != s.URL || claims.Audience != "web" || claims.Subject != simencrypt.EncryptUInt64(testUserID) ||
+		claims.Nonce != "n-1" || claims.Name != "alice" || claims.AuthTime != 1000 ||
+		len(claims.AuthMethods) != 1 || claims.AuthMethods[0] != "pwd" {
+		t.Fatal(claims)
+	}
+
+	bearer := http.Header{"Authorization": {"Bearer " + data["access_token"].(string)}}
+
+	resp, userInfo := s.do(t, http.MethodGet, "/userinfo", nil, bearer)
+	if resp.StatusCode != http.StatusOK || userInfo["sub"] != claims.Subject || userInfo["preferred_username"] != "alice" {
+		t.Fatal(resp.StatusCode, userInfo)
+	}
+
+	if resp, _ = s.do(t, http.MethodGet, "/userinfo", nil, http.Header{"Authorization": {"Bearer x"}}); resp.StatusCode != http.StatusUnauthorized {
+		t.Fatal(resp.StatusCode)
+	}
+}
+
+func TestRevokeIntrospectOwnership(t *testing.T) {
+	s := newTestOAuthServer(t)
+
+	_, token := s.passwordToken(t, "cli", "cli-secret", "alice")
+	accessToken, _ := token["access_token"].(string)
+
+	introspect := func(clientID, secret string) map[string]interface{} {
+		_, data := s.do(t, http.MethodPost, "/oauth/introspect", url.Values{
+			"client_id": {clientID}, "client_secret": {secret}, "token": {accessToken},
+		}, nil)
+
+		return data
+	}
+
+	revoke := func(clientID, secret string) int {
+		resp, _ := s.do(t, http.MethodPost, "/oauth/revoke", url.Values{
+			"client_id": {clientID}, "client_secret": {secret}, "token": {accessToken},
+		}, nil)
+
+		return resp.StatusCode
+	}
+
+	if data := introspect("cli", "cli-secret"); data["active"] != true || data["sub"] != simencrypt.EncryptUInt64(testUserID) {
+		t.Fatal(data)
+	}
+
+	if data := introspect("3rd", "3rd-secret"); data["active"] != false || data["sub"] != nil {
+		t.Fatal("tokens of other clients are inactive to them", data)
+	}
+
+	if data := introspect("cli", "bad"); data["error"] != "invalid_client" {
+		t.Fatal(data)
+	}
+
+	// other clients are answered 200 but the token stays
+	if code := revoke("3rd", "3rd-secret"); code != http.StatusOK || introspect("cli", "cli-secret")["active"] != true {
+		t.Fatal(code)
+	}
+
+	if code := revoke("cli", "bad"); code != http.StatusUnauthorized {
+		t.Fatal(code)
+	}
+
+	if code := revoke("cli", "cli-secret"); code != http.StatusOK || introspect("cli", "cli-secret")["active"] != false {
+		t.Fatal(code)
+	}
+}
@@ -1,40 +1,119 @@
 package oauthserver
 
 import (
+	"context"
 	"net/http"
 
+	"github.com/go-oauth2/oauth2/v4/errors"
+	"github.com/s-min-sys/userbe/internal/userlookup"
 	"github.com/s-min-sys/userbe/internal/usertokenmanager/usertokenmanagerinters"
+	"github.com/s-min-sys/userbe/pkg/grpctoken"
+	"github.com/sbasestarter/bizuserlib/authenticator/userpass"
 	"github.com/sbasestarter/bizuserlib/bizuserinters"
+	"github.com/sgostarter/i/commerr"
 )
 
-func NewLoginHelper(userTokenManager usertokenmanagerinters.UserTokenManager) LoginHelper {
-	if userTokenManager == nil {
+// NewLoginHelper a nil userPassAuthenticator disables the password grant.
+func NewLoginHelper(userTokenManager usertokenmanagerinters.UserTokenManager, userManager bizuserinters.UserManager,
+	userPassAuthenticator userpass.Authenticator) LoginHelper {
+	if userTokenManager == nil || userManager == nil {
 		return nil
 	}
 
 	return &loginHelperImpl{
-		userTokenManager: userTokenManager,
+		userTokenManager:      userTokenManager,
+		userManager:           userManager,
+		userPassAuthenticator: userPassAuthenticator,
 	}
 }
 
 type loginHelperImpl struct {
-	userTokenManager usertokenmanagerinters.UserTokenManager
+	userTokenManager      usertokenmanagerinters.UserTokenManager
+	userManager           bizuserinters.UserManager
+	userPassAuthenticator userpass.Authenticator
 }
 
-func (impl *loginHelperImpl) CheckHTTPLogin(r *http.Request) (userID uint64, userName string, ok bool) {
-	cookie, err := r.Cookie("user_token")
+func (impl *loginHelperImpl) CheckHTTPLogin(r *http.Request) (userTokenInfo *usertokenmanagerinters.UserTokenInfo, ok bool) {
+	cookie, err := r.Cookie(grpctoken.TokenKeyOnMetadata)
 	if err != nil {
 		return
 	}
 
-	user, status := impl.userTokenManager.ExplainToken(r.Context(), cookie.Value)
+	userTokenInfo, status := impl.userTokenManager.ExplainToken(r.Context(), cookie.Value)
 	if status.Code != bizuserinters.StatusCodeOk {
 		return
 	}
 
-	userID = user.ID
-	userName = user.UserName
 	ok = true
 
 	return
 }
+
+func (impl *loginHelperImpl) GetUser(ctx context.Context, userID uint64) (userInfo *bizuserinters.UserInfo, ok bool) {
+	userInfo, status := userlookup.GetUser(ctx, impl.userManager, userID)
+	ok = status.Code == bizuserinters.StatusCodeOk
+
+	return
+}
+
+// PasswordLogin runs the same login flow as the user service with the password as the only authenticator.
+func (impl *loginHelperImpl) PasswordLogin(ctx context.Context, userName, password string) (
+	userInfo *bizuserinters.UserInfo, err error) {
+	if impl.userPassAuthenticator == nil {
+		err = commerr.ErrUnimplemented
+
+		return
+	}
+
+	bizID, _, status := impl.userManager.LoginBegin(ctx)
+	if status.Code != bizuserinters.StatusCodeOk {
+		err = commerr.ErrInternal
+
+		return
+	}
+
+	status = impl.userPassAuthenticator.Login(ctx, bizID, userName, password)
+	if status.Code != bizuserinters.StatusCodeOk {
+		err = errors.ErrInvalidGrant
+
+		return
+	}
+
+	neededOrEvent, status := impl.userManager.LoginCheck(ctx, bizID)
+	if status.Code != bizuserinters.StatusCodeOk && status.Code != bizuserinters.StatusCodeNeedAuthenticator {
+		err = errors.ErrInvalidGrant
+
+		return
+	}
+
+	for _, event := range neededOrEvent {
+		if event.Authenticator == bizuserinters.AuthenticatorGoogle2FA {
+			err = ErrSecondFactorRequired
+
+			return
+		}
+	}
+
+	if len(neededOrEvent) > 0 {
+		err = errors.ErrInvalidGrant
+
+		return
+	}
+
+	userInfo, status = impl.userManager.LoginEnd(ctx, bizID)
+	if status.Code != bizuserinters.StatusCodeOk {
+		userInfo = nil
+		err = errors.ErrInvalidGrant
+
+		return
+	}
+
+	if userInfo.HasGoogle2FA {
+		userInfo = nil
+		err = ErrSecondFactorRequired
+
+		return
+	}
+
+	return
+}
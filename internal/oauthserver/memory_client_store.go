@@ -0,0 +1,23 @@
+package oauthserver
+
+import (
+	"context"
+
+	"github.com/go-oauth2/oauth2/v4"
+	"github.com/go-oauth2/oauth2/v4/store"
+	"github.com/s-min-sys/userbe/internal/usertokenmanager/usertokenmanagerinters"
+)
+
+func newMemoryClientStore() usertokenmanagerinters.OAuthClientStore {
+	return &memoryClientStoreImpl{
+		ClientStore: store.NewClientStore(),
+	}
+}
+
+type memoryClientStoreImpl struct {
+	*store.ClientStore
+}
+
+func (impl *memoryClientStoreImpl) Set(_ context.Context, client oauth2.ClientInfo) error {
+	return impl.ClientStore.Set(client.GetID(), client)
+}
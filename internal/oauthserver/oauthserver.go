@@ -3,22 +3,27 @@ package oauthserver
 import (
 	"context"
 	"encoding/json"
+	stderrors "errors"
 	"net/http"
 	"net/url"
 	"strconv"
 	"time"
 
+	"github.com/dgrijalva/jwt-go"
+	"github.com/go-oauth2/oauth2/v4"
 	"github.com/go-oauth2/oauth2/v4/errors"
 	"github.com/go-oauth2/oauth2/v4/generates"
 	"github.com/go-oauth2/oauth2/v4/manage"
 	"github.com/go-oauth2/oauth2/v4/models"
 	"github.com/go-oauth2/oauth2/v4/server"
-	"github.com/go-oauth2/oauth2/v4/store"
 	"github.com/go-session/session"
 	"github.com/gorilla/mux"
 	"github.com/phyber/negroni-gzip/gzip"
 	"github.com/s-min-sys/userbe/internal/config"
-	"github.com/sgostarter/i/commerr"
+	"github.com/s-min-sys/userbe/internal/usertokenmanager"
+	"github.com/s-min-sys/userbe/internal/usertokenmanager/usertokenmanagerinters"
+	uuid "github.com/satori/go.uuid"
+	"github.com/sbasestarter/bizuserlib/bizuserinters"
 	"github.com/sgostarter/i/l"
 	"github.com/urfave/negroni"
 	graceful "gopkg.in/tylerb/graceful.v1"
@@ -31,19 +36,44 @@ type OAuth2Server interface {
 const (
 	SessionKeyLoggedInUserID = "LoggedInUserID"
 	SessionKeyReturnURI      = "ReturnUri"
+	SessionKeyConsentToken   = "ConsentToken"
+	SessionKeyConsentDenied  = "ConsentDenied"
 )
 
+// OAuth2ServerConfigs nil stores keep everything in memory. ClientCredentials are saved into ClientStore at start
+// and hold the policy of each client.
+// Issuer is the external URL of the server, id tokens are signed by the JWTSigner and carry it as iss.
+// Users approve the scopes of a client on URLConsent once, an empty URLConsent skips the consent step.
 type OAuth2ServerConfigs struct {
 	URLLogin          string
 	URLAuth           string
+	URLConsent        string
 	ClientCredentials map[string]config.OAuthClientCredential
+	TokenStore        usertokenmanagerinters.OAuthTokenStore
+	ClientStore       usertokenmanagerinters.OAuthClientStore
+	GrantStore        usertokenmanagerinters.OAuthGrantStore
+	Issuer            string
+	IDTokenExpiration time.Duration
 }
 
 type LoginHelper interface {
-	CheckHTTPLogin(r *http.Request) (userID uint64, userName string, ok bool)
+	CheckHTTPLogin(r *http.Request) (userTokenInfo *usertokenmanagerinters.UserTokenInfo, ok bool)
+	GetUser(ctx context.Context, userID uint64) (userInfo *bizuserinters.UserInfo, ok bool)
+	PasswordLogin(ctx context.Context, userName, password string) (userInfo *bizuserinters.UserInfo, err error)
 }
 
-func NewOAuth2Server(configs OAuth2ServerConfigs, loginHelper LoginHelper, logger l.Wrapper) OAuth2Server {
+// ErrSecondFactorRequired the password grant can't ask for the second factor, such accounts must use the
+// authorization code flow.
+var ErrSecondFactorRequired = stderrors.New("second factor required")
+
+// JWTSigner OpenID Connect is served only when there is one with an asymmetric signing key, clients can't
+// verify id tokens signed by a HS256 key.
+type JWTSigner interface {
+	SigningKey() (kid string, method jwt.SigningMethod, key interface{})
+	JWKS() *usertokenmanagerinters.JWKSet
+}
+
+func NewOAuth2Server(configs OAuth2ServerConfigs, loginHelper LoginHelper, jwtSigner JWTSigner, logger l.Wrapper) OAuth2Server {
 	if logger == nil {
 		logger = l.NewNopLoggerWrapper()
 	}
@@ -52,9 +82,34 @@ func NewOAuth2Server(configs OAuth2ServerConfigs, loginHelper LoginHelper, logge
 		logger.Fatal("noLoginHelper")
 	}
 
+	if configs.IDTokenExpiration <= 0 {
+		configs.IDTokenExpiration = defaultIDTokenExpiration
+	}
+
+	if configs.TokenStore == nil {
+		configs.TokenStore = usertokenmanager.NewMemoryOAuthTokenStore()
+	}
+
+	if configs.ClientStore == nil {
+		configs.ClientStore = newMemoryClientStore()
+	}
+
+	if configs.GrantStore == nil {
+		configs.GrantStore = usertokenmanager.NewMemoryOAuthGrantStore()
+	}
+
+	if jwtSigner != nil {
+		if _, method, _ := jwtSigner.SigningKey(); isSymmetricSigningMethod(method) {
+			logger.Warn("the signing key is symmetric, OpenID Connect is disabled")
+
+			jwtSigner = nil
+		}
+	}
+
 	return &oAuthServer2Impl{
 		configs:     configs,
 		loginHelper: loginHelper,
+		jwtSigner:   jwtSigner,
 		logger:      logger.WithFields(l.StringField(l.ClsKey, "oAuthServer2Impl")),
 	}
 }
@@ -62,6 +117,7 @@ func NewOAuth2Server(configs OAuth2ServerConfigs, loginHelper LoginHelper, logge
 type oAuthServer2Impl struct {
 	configs     OAuth2ServerConfigs
 	loginHelper LoginHelper
+	jwtSigner   JWTSigner
 	logger      l.Wrapper
 }
 
@@ -88,29 +144,46 @@ func (impl *oAuthServer2Impl) installHandlers(router *mux.Router) {
 	manager.SetAuthorizeCodeTokenCfg(manage.DefaultAuthorizeCodeTokenCfg)
 
 	// token store
-	manager.MustTokenStorage(store.NewMemoryTokenStore())
+	if impl.jwtSigner != nil {
+		manager.MapTokenStorage(&oidcTokenStore{TokenStore: impl.configs.TokenStore})
+	} else {
+		manager.MapTokenStorage(impl.configs.TokenStore)
+	}
 
 	// generate jwt access token
 	// manager.MapAccessGenerate(generates.NewJWTAccessGenerate("", []byte("00000000"), jwt.SigningMethodHS512))
-	manager.MapAccessGenerate(generates.NewAccessGenerate())
+	manager.MapAccessGenerate(&clientLifetimeAccessGenerate{
+		AccessGenerate: generates.NewAccessGenerate(),
+		clients:        impl.configs.ClientCredentials,
+	})
 
-	clientStore := store.NewClientStore()
 	for id, client := range impl.configs.ClientCredentials {
-		_ = clientStore.Set(id, &models.Client{
-			ID:     id,
-			Secret: client.Secret,
-			Domain: client.Domain,
-		})
+		impl.saveClient(id, client)
 	}
 
-	manager.MapClientStorage(clientStore)
+	manager.MapClientStorage(impl.configs.ClientStore)
 
 	srv := server.NewServer(server.NewConfig(), manager)
 
+	srv.SetClientInfoHandler(clientInfoHandler)
+	srv.SetClientAuthorizedHandler(impl.clientAuthorizedHandler)
+	srv.SetClientScopeHandler(impl.clientScopeHandler)
+	srv.SetRefreshingScopeHandler(impl.refreshingScopeHandler)
+
 	srv.SetPasswordAuthorizationHandler(impl.passwordAuthorizationHandler)
 	srv.SetUserAuthorizationHandler(impl.userAuthorizeHandler)
 
 	srv.SetInternalErrorHandler(func(err error) (re *errors.Response) {
+		if err == ErrSecondFactorRequired {
+			re = &errors.Response{
+				Error:       errors.ErrInvalidGrant,
+				Description: "The account requires a second factor, use the authorization code flow",
+				StatusCode:  http.StatusBadRequest,
+			}
+
+			return
+		}
+
 		impl.logger.WithFields(l.ErrorField(err)).Error("internal error")
 
 		return
@@ -121,7 +194,7 @@ func (impl *oAuthServer2Impl) installHandlers(router *mux.Router) {
 	})
 
 	router.HandleFunc("/oauth/auth", func(w http.ResponseWriter, r *http.Request) {
-		userID, _, ok := impl.loginHelper.CheckHTTPLogin(r)
+		userTokenInfo, ok := impl.loginHelper.CheckHTTPLogin(r)
 		if !ok {
 			impl.httpLocationTo(w, impl.configs.URLLogin)
 
@@ -135,7 +208,7 @@ func (impl *oAuthServer2Impl) installHandlers(router *mux.Router) {
 			return
 		}
 
-		storage.Set(SessionKeyLoggedInUserID, strconv.FormatUint(userID, 10))
+		storage.Set(SessionKeyLoggedInUserID, strconv.FormatUint(userTokenInfo.ID, 10))
 
 		err = storage.Save()
 		if err != nil {
@@ -151,7 +224,26 @@ func (impl *oAuthServer2Impl) installHandlers(router *mux.Router) {
 		impl.oAuthAuthorizeHandler(w, r, srv)
 	})
 
+	router.HandleFunc("/oauth/consent", func(w http.ResponseWriter, r *http.Request) {
+		impl.handleConsentInfo(w, r, manager)
+	}).Methods(http.MethodGet)
+
+	router.HandleFunc("/oauth/consent", func(w http.ResponseWriter, r *http.Request) {
+		impl.handleConsent(w, r, manager)
+	}).Methods(http.MethodPost)
+
 	router.HandleFunc("/oauth/token", func(w http.ResponseWriter, r *http.Request) {
+		if err := impl.checkTokenRequest(r, srv, manager); err != nil {
+			data, statusCode, _ := srv.GetErrorData(err)
+			impl.writeJSON(w, statusCode, data)
+
+			return
+		}
+
+		if impl.jwtSigner != nil {
+			r = impl.withOIDCRequest(r, false)
+		}
+
 		err := srv.HandleTokenRequest(w, r)
 		if err != nil {
 			impl.logger.WithFields(l.ErrorField(err)).Error("handle token request failed")
@@ -179,15 +271,60 @@ func (impl *oAuthServer2Impl) installHandlers(router *mux.Router) {
 		e.SetIndent("", "  ")
 		_ = e.Encode(data)
 	})
+
+	router.HandleFunc("/oauth/revoke", func(w http.ResponseWriter, r *http.Request) {
+		impl.handleRevoke(w, r, srv, manager)
+	}).Methods(http.MethodPost)
+
+	router.HandleFunc("/oauth/introspect", func(w http.ResponseWriter, r *http.Request) {
+		impl.handleIntrospect(w, r, srv, manager)
+	}).Methods(http.MethodPost)
+
+	if impl.jwtSigner != nil {
+		srv.SetExtensionFieldsHandler(impl.idTokenExtensionFields)
+
+		router.HandleFunc("/.well-known/jwks.json", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("Cache-Control", "public, max-age=300")
+
+			_ = json.NewEncoder(w).Encode(impl.jwtSigner.JWKS())
+		})
+
+		router.HandleFunc("/.well-known/openid-configuration", impl.handleOpenIDConfiguration)
+
+		router.HandleFunc("/userinfo", func(w http.ResponseWriter, r *http.Request) {
+			impl.handleUserInfo(w, r, srv)
+		}).Methods(http.MethodGet, http.MethodPost)
+	}
 }
 
+// passwordAuthorizationHandler only first party clients allowed the password grant may collect the user's password.
 func (impl *oAuthServer2Impl) passwordAuthorizationHandler(ctx context.Context, clientID, username, password string) (userID string, err error) {
-	err = commerr.ErrUnimplemented
+	if allowed, _ := impl.clientAuthorizedHandler(clientID, oauth2.PasswordCredentials); !allowed {
+		err = errors.ErrUnauthorizedClient
+
+		return
+	}
+
+	userInfo, err := impl.loginHelper.PasswordLogin(ctx, username, password)
+	if err != nil {
+		impl.logger.WithFields(l.ErrorField(err), l.StringField("clientID", clientID)).Info("password grant failed")
+
+		return
+	}
+
+	userID = strconv.FormatUint(userInfo.ID, 10)
 
 	return
 }
 
 func (impl *oAuthServer2Impl) userAuthorizeHandler(w http.ResponseWriter, r *http.Request) (userID string, err error) {
+	if ok, e := impl.checkAuthorizeRequest(r.Context(), w, r); !ok {
+		err = e
+
+		return
+	}
+
 	storage, err := session.Start(r.Context(), w, r)
 	if err != nil {
 		return
@@ -209,6 +346,36 @@ func (impl *oAuthServer2Impl) userAuthorizeHandler(w http.ResponseWriter, r *htt
 
 	userID, _ = uid.(string)
 
+	if _, denied := storage.Get(SessionKeyConsentDenied); denied {
+		storage.Delete(SessionKeyConsentDenied)
+		storage.Delete(SessionKeyReturnURI)
+		_ = storage.Save()
+
+		userID = ""
+		err = errors.ErrAccessDenied
+
+		return
+	}
+
+	granted, err := impl.scopesGranted(r.Context(), userID, r.Form.Get("client_id"), r.Form.Get("scope"))
+	if err != nil {
+		userID = ""
+
+		return
+	}
+
+	if !granted {
+		storage.Set(SessionKeyReturnURI, r.Form)
+		storage.Set(SessionKeyConsentToken, uuid.NewV4().String())
+		_ = storage.Save()
+
+		impl.httpLocationTo(w, impl.configs.URLConsent)
+
+		userID = ""
+
+		return
+	}
+
 	storage.Delete(SessionKeyReturnURI)
 
 	_ = storage.Save()
@@ -241,6 +408,10 @@ func (impl *oAuthServer2Impl) oAuthAuthorizeHandler(w http.ResponseWriter, r *ht
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 	}
 
+	if impl.jwtSigner != nil {
+		r = impl.withOIDCRequest(r, true)
+	}
+
 	err = srv.HandleAuthorizeRequest(w, r)
 	if err != nil {
 		impl.logger.WithFields(l.ErrorField(err)).Error("handle authorize request failed")
@@ -248,3 +419,29 @@ func (impl *oAuthServer2Impl) oAuthAuthorizeHandler(w http.ResponseWriter, r *ht
 		http.Error(w, err.Error(), http.StatusBadRequest)
 	}
 }
+
+// saveClient public clients have no secret, the domain defaults to the first redirect uri.
+func (impl *oAuthServer2Impl) saveClient(id string, client config.OAuthClientCredential) {
+	secret := client.Secret
+	if client.Public {
+		if secret != "" {
+			impl.logger.WithFields(l.StringField("clientID", id)).Warn("secret of public client is ignored")
+		}
+
+		secret = ""
+	}
+
+	domain := client.Domain
+	if domain == "" && len(client.RedirectURIs) > 0 {
+		domain = client.RedirectURIs[0]
+	}
+
+	err := impl.configs.ClientStore.Set(context.Background(), &models.Client{
+		ID:     id,
+		Secret: secret,
+		Domain: domain,
+	})
+	if err != nil {
+		impl.logger.WithFields(l.ErrorField(err), l.StringField("clientID", id)).Error("save client failed")
+	}
+}
@@ -0,0 +1,350 @@
+package oauthserver
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/go-oauth2/oauth2/v4"
+	"github.com/go-oauth2/oauth2/v4/errors"
+	"github.com/go-oauth2/oauth2/v4/manage"
+	"github.com/go-oauth2/oauth2/v4/models"
+	"github.com/go-oauth2/oauth2/v4/server"
+	"github.com/s-min-sys/userbe/internal/usertokenmanager/usertokenmanagerinters"
+	"github.com/sgostarter/i/l"
+	"github.com/sgostarter/libeasygo/crypt/simencrypt"
+)
+
+const (
+	ScopeOpenID  = "openid"
+	ScopeProfile = "profile"
+
+	defaultIDTokenExpiration = time.Hour
+
+	jwtHeaderKeyID = "kid"
+)
+
+// IDTokenClaims sub is the encrypted user id, the same as in user tokens.
+type IDTokenClaims struct {
+	Name        string   `json:"name,omitempty"`
+	Nonce       string   `json:"nonce,omitempty"`
+	AuthTime    int64    `json:"auth_time,omitempty"`
+	AuthMethods []string `json:"amr,omitempty"`
+	jwt.StandardClaims
+}
+
+// oidcTokenStore keeps the login with the code of an openid request and hands it on to the tokens the code is
+// exchanged for. Both the authorize and the token request carry an oidcRequest in their context.
+type oidcTokenStore struct {
+	oauth2.TokenStore
+}
+
+type oidcRequestKey struct{}
+
+// oidcRequest login is set by the authorize request, and by GetByCode while a code is redeemed.
+type oidcRequest struct {
+	login *usertokenmanagerinters.OIDCLogin
+}
+
+func (s *oidcTokenStore) Create(ctx context.Context, info oauth2.TokenInfo) error {
+	req, _ := ctx.Value(oidcRequestKey{}).(*oidcRequest)
+	if req == nil || req.login == nil || !hasScope(info.GetScope(), ScopeOpenID) {
+		return s.TokenStore.Create(ctx, info)
+	}
+
+	token, ok := info.(*models.Token)
+	if !ok {
+		return s.TokenStore.Create(ctx, info)
+	}
+
+	return s.TokenStore.Create(ctx, &usertokenmanagerinters.OAuthTokenInfo{
+		Token:     *token,
+		OIDCLogin: req.login,
+	})
+}
+
+func (s *oidcTokenStore) GetByCode(ctx context.Context, code string) (oauth2.TokenInfo, error) {
+	info, err := s.TokenStore.GetByCode(ctx, code)
+	if err != nil {
+		return nil, err
+	}
+
+	if req, _ := ctx.Value(oidcRequestKey{}).(*oidcRequest); req != nil {
+		if tokenInfo, ok := info.(*usertokenmanagerinters.OAuthTokenInfo); ok {
+			req.login = tokenInfo.OIDCLogin
+		}
+	}
+
+	return info, nil
+}
+
+// withOIDCRequest the login is taken only for openid requests of logged in users.
+func (impl *oAuthServer2Impl) withOIDCRequest(r *http.Request, authorize bool) *http.Request {
+	req := &oidcRequest{}
+
+	if authorize && hasScope(r.FormValue("scope"), ScopeOpenID) {
+		if userTokenInfo, ok := impl.loginHelper.CheckHTTPLogin(r); ok {
+			req.login = &usertokenmanagerinters.OIDCLogin{
+				UserName:    userTokenInfo.UserName,
+				Nonce:       r.FormValue("nonce"),
+				AuthMethods: userTokenInfo.AuthMethods,
+			}
+
+			if !userTokenInfo.AuthAt.IsZero() {
+				req.login.AuthTime = userTokenInfo.AuthAt.Unix()
+			}
+		}
+	}
+
+	return r.WithContext(context.WithValue(r.Context(), oidcRequestKey{}, req))
+}
+
+// idTokenExtensionFields the login was stored with the access token when the code was redeemed.
+func (impl *oAuthServer2Impl) idTokenExtensionFields(ti oauth2.TokenInfo) map[string]interface{} {
+	if ti.GetCode() != "" || !hasScope(ti.GetScope(), ScopeOpenID) {
+		return nil
+	}
+
+	info, err := impl.configs.TokenStore.GetByAccess(context.Background(), ti.GetAccess())
+	if err != nil {
+		impl.logger.WithFields(l.ErrorField(err)).Error("load token failed")
+
+		return nil
+	}
+
+	tokenInfo, ok := info.(*usertokenmanagerinters.OAuthTokenInfo)
+	if !ok || tokenInfo.OIDCLogin == nil {
+		return nil
+	}
+
+	idToken, err := impl.signIDToken(ti, tokenInfo.OIDCLogin)
+	if err != nil {
+		impl.logger.WithFields(l.ErrorField(err)).Error("sign id token failed")
+
+		return nil
+	}
+
+	return map[string]interface{}{
+		"id_token": idToken,
+	}
+}
+
+func (impl *oAuthServer2Impl) signIDToken(ti oauth2.TokenInfo, login *usertokenmanagerinters.OIDCLogin) (string, error) {
+	userID, err := strconv.ParseUint(ti.GetUserID(), 10, 64)
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+
+	claims := IDTokenClaims{
+		Name:        login.UserName,
+		Nonce:       login.Nonce,
+		AuthTime:    login.AuthTime,
+		AuthMethods: login.AuthMethods,
+		StandardClaims: jwt.StandardClaims{
+			Audience:  ti.GetClientID(),
+			ExpiresAt: now.Add(impl.configs.IDTokenExpiration).Unix(),
+			IssuedAt:  now.Unix(),
+			Issuer:    impl.configs.Issuer,
+			Subject:   simencrypt.EncryptUInt64(userID),
+		},
+	}
+
+	kid, method, key := impl.jwtSigner.SigningKey()
+
+	token := jwt.NewWithClaims(method, claims)
+	if kid != "" {
+		token.Header[jwtHeaderKeyID] = kid
+	}
+
+	return token.SignedString(key)
+}
+
+func (impl *oAuthServer2Impl) handleOpenIDConfiguration(w http.ResponseWriter, _ *http.Request) {
+	_, method, _ := impl.jwtSigner.SigningKey()
+
+	issuer := strings.TrimSuffix(impl.configs.Issuer, "/")
+
+	impl.writeJSON(w, http.StatusOK, map[string]interface{}{
+		"issuer":                                issuer,
+		"authorization_endpoint":                issuer + "/oauth/authorize",
+		"token_endpoint":                        issuer + "/oauth/token",
+		"userinfo_endpoint":                     issuer + "/userinfo",
+		"jwks_uri":                              issuer + "/.well-known/jwks.json",
+		"revocation_endpoint":                   issuer + "/oauth/revoke",
+		"introspection_endpoint":                issuer + "/oauth/introspect",
+		"response_types_supported":              []string{"code", "token"},
+		"grant_types_supported":                 []string{"authorization_code", "refresh_token", "client_credentials", "password", "implicit"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{method.Alg()},
+		"scopes_supported":                      []string{ScopeOpenID, ScopeProfile},
+		"token_endpoint_auth_methods_supported": []string{"client_secret_basic", "client_secret_post", "none"},
+		"claims_supported":                      []string{"iss", "sub", "aud", "exp", "iat", "name", "auth_time", "amr", "nonce"},
+		"code_challenge_methods_supported":      []string{"S256"},
+	})
+}
+
+func (impl *oAuthServer2Impl) handleUserInfo(w http.ResponseWriter, r *http.Request, srv *server.Server) {
+	ti, err := srv.ValidationBearerToken(r)
+	if err != nil || !hasScope(ti.GetScope(), ScopeOpenID) {
+		w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+
+		return
+	}
+
+	userID, err := strconv.ParseUint(ti.GetUserID(), 10, 64)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	data := map[string]interface{}{
+		"sub": simencrypt.EncryptUInt64(userID),
+	}
+
+	if hasScope(ti.GetScope(), ScopeProfile) {
+		if userInfo, ok := impl.loginHelper.GetUser(r.Context(), userID); ok {
+			data["name"] = userInfo.UserName
+			data["preferred_username"] = userInfo.UserName
+		}
+	}
+
+	impl.writeJSON(w, http.StatusOK, data)
+}
+
+// handleRevoke RFC 7009, unknown tokens and tokens of other clients are answered with 200 as well.
+func (impl *oAuthServer2Impl) handleRevoke(w http.ResponseWriter, r *http.Request, srv *server.Server, manager *manage.Manager) {
+	clientID, err := impl.authenticateClient(r, srv, manager)
+	if err != nil {
+		impl.writeJSON(w, http.StatusUnauthorized, map[string]string{"error": errors.ErrInvalidClient.Error()})
+
+		return
+	}
+
+	token := r.FormValue("token")
+
+	if ti, _ := manager.LoadAccessToken(r.Context(), token); ti != nil && ti.GetClientID() == clientID {
+		_ = manager.RemoveAccessToken(r.Context(), token)
+
+		if refresh := ti.GetRefresh(); refresh != "" {
+			_ = manager.RemoveRefreshToken(r.Context(), refresh)
+		}
+	} else if ti, _ = manager.LoadRefreshToken(r.Context(), token); ti != nil && ti.GetClientID() == clientID {
+		_ = manager.RemoveRefreshToken(r.Context(), token)
+		_ = manager.RemoveAccessToken(r.Context(), ti.GetAccess())
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// handleIntrospect RFC 7662, clients may only introspect their own tokens.
+func (impl *oAuthServer2Impl) handleIntrospect(w http.ResponseWriter, r *http.Request, srv *server.Server, manager *manage.Manager) {
+	clientID, err := impl.authenticateClient(r, srv, manager)
+	if err != nil {
+		impl.writeJSON(w, http.StatusUnauthorized, map[string]string{"error": errors.ErrInvalidClient.Error()})
+
+		return
+	}
+
+	token := r.FormValue("token")
+
+	var expiresAt time.Time
+
+	ti, _ := manager.LoadAccessToken(r.Context(), token)
+	if ti != nil {
+		expiresAt = ti.GetAccessCreateAt().Add(ti.GetAccessExpiresIn())
+	} else if ti, _ = manager.LoadRefreshToken(r.Context(), token); ti != nil {
+		expiresAt = ti.GetRefreshCreateAt().Add(ti.GetRefreshExpiresIn())
+	}
+
+	if ti == nil || ti.GetClientID() != clientID {
+		impl.writeJSON(w, http.StatusOK, map[string]interface{}{"active": false})
+
+		return
+	}
+
+	data := map[string]interface{}{
+		"active":     true,
+		"client_id":  ti.GetClientID(),
+		"scope":      ti.GetScope(),
+		"token_type": "Bearer",
+		"iat":        ti.GetAccessCreateAt().Unix(),
+	}
+
+	if !expiresAt.IsZero() {
+		data["exp"] = expiresAt.Unix()
+	}
+
+	if userID, err := strconv.ParseUint(ti.GetUserID(), 10, 64); err == nil {
+		data["sub"] = simencrypt.EncryptUInt64(userID)
+	}
+
+	impl.writeJSON(w, http.StatusOK, data)
+}
+
+func (impl *oAuthServer2Impl) authenticateClient(r *http.Request, srv *server.Server, manager *manage.Manager) (
+	clientID string, err error) {
+	clientID, clientSecret, err := srv.ClientInfoHandler(r)
+	if err != nil {
+		return
+	}
+
+	client, err := manager.GetClient(r.Context(), clientID)
+	if err != nil {
+		return
+	}
+
+	if subtle.ConstantTimeCompare([]byte(client.GetSecret()), []byte(clientSecret)) != 1 {
+		err = errors.ErrInvalidClient
+
+		return
+	}
+
+	return
+}
+
+func (impl *oAuthServer2Impl) writeJSON(w http.ResponseWriter, statusCode int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(statusCode)
+
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// clientInfoHandler accepts client_secret_basic and client_secret_post. server.ClientFormHandler reads r.Form
+// without parsing it, revoke and introspect get here before anything else parsed the form.
+func clientInfoHandler(r *http.Request) (clientID, clientSecret string, err error) {
+	if _, _, ok := r.BasicAuth(); ok {
+		return server.ClientBasicHandler(r)
+	}
+
+	if r.Form == nil {
+		_ = r.ParseForm()
+	}
+
+	return server.ClientFormHandler(r)
+}
+
+func hasScope(scope, s string) bool {
+	for _, v := range strings.Fields(scope) {
+		if v == s {
+			return true
+		}
+	}
+
+	return false
+}
+
+func isSymmetricSigningMethod(method jwt.SigningMethod) bool {
+	_, ok := method.(*jwt.SigningMethodHMAC)
+
+	return ok
+}
@@ -63,6 +63,16 @@ func AuthenticatorIdentity2Pb(authenticator bizuserinters.AuthenticatorIdentity)
 	return userpb.AuthenticatorIdentity_AUTHENTICATOR_IDENTITY_UNSPECIFIED
 }
 
+func AuthenticatorIdentities2Pb(authenticators []bizuserinters.AuthenticatorIdentity) []userpb.AuthenticatorIdentity {
+	as := make([]userpb.AuthenticatorIdentity, 0, len(authenticators))
+
+	for _, authenticator := range authenticators {
+		as = append(as, AuthenticatorIdentity2Pb(authenticator))
+	}
+
+	return as
+}
+
 func Event2Pb(event bizuserinters.Event) userpb.Event {
 	switch event {
 	case bizuserinters.SetupEvent:
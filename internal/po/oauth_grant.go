@@ -0,0 +1,29 @@
+package po
+
+import (
+	"github.com/s-min-sys/protorepo/gens/userpb"
+	"github.com/s-min-sys/userbe/internal/usertokenmanager/usertokenmanagerinters"
+)
+
+func OAuthGrant2Pb(grant *usertokenmanagerinters.OAuthGrant) *userpb.OAuthGrant {
+	if grant == nil {
+		return nil
+	}
+
+	return &userpb.OAuthGrant{
+		ClientId:   grant.ClientID,
+		ClientName: grant.ClientName,
+		Scopes:     grant.Scopes,
+		GrantedAt:  grant.GrantedAt.Unix(),
+	}
+}
+
+func OAuthGrants2Pb(grants []*usertokenmanagerinters.OAuthGrant) []*userpb.OAuthGrant {
+	pbGrants := make([]*userpb.OAuthGrant, 0, len(grants))
+
+	for _, grant := range grants {
+		pbGrants = append(pbGrants, OAuthGrant2Pb(grant))
+	}
+
+	return pbGrants
+}
@@ -0,0 +1,32 @@
+package po
+
+import (
+	"github.com/s-min-sys/protorepo/gens/userpb"
+	"github.com/s-min-sys/userbe/internal/usertokenmanager/usertokenmanagerinters"
+)
+
+func Session2Pb(session *usertokenmanagerinters.Session, currentSessionID string) *userpb.Session {
+	if session == nil {
+		return nil
+	}
+
+	return &userpb.Session{
+		Id:         session.ID,
+		CreatedAt:  session.CreatedAt.Unix(),
+		LastUsedAt: session.LastUsedAt.Unix(),
+		ExpireAt:   session.ExpireAt.Unix(),
+		UserAgent:  session.UserAgent,
+		ClientIp:   session.ClientIP,
+		Current:    session.ID == currentSessionID,
+	}
+}
+
+func Sessions2Pb(sessions []*usertokenmanagerinters.Session, currentSessionID string) []*userpb.Session {
+	pbSessions := make([]*userpb.Session, 0, len(sessions))
+
+	for _, session := range sessions {
+		pbSessions = append(pbSessions, Session2Pb(session, currentSessionID))
+	}
+
+	return pbSessions
+}
@@ -2,6 +2,7 @@ package po
 
 import (
 	"github.com/s-min-sys/protorepo/gens/userpb"
+	"github.com/s-min-sys/userbe/internal/usertokenmanager/usertokenmanagerinters"
 	"github.com/sbasestarter/bizuserlib/bizuserinters"
 )
 
@@ -37,6 +38,8 @@ func StatusCode2Pb(code bizuserinters.StatusCode) userpb.Code {
 		return userpb.Code_CODE_BAD_DATA_ERROR
 	case bizuserinters.StatusCodePermissionError:
 		return userpb.Code_CODE_PERMISSION_ERROR
+	case usertokenmanagerinters.StatusCodeReauthRequired:
+		return userpb.Code_CODE_REAUTH_REQUIRED
 	}
 
 	return userpb.Code_CODE_UNSPECIFIED
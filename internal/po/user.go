@@ -3,8 +3,10 @@ package po
 import (
 	"github.com/s-min-sys/protorepo/gens/userpb"
 	"github.com/sbasestarter/bizuserlib/bizuserinters"
+	"github.com/sgostarter/libeasygo/crypt/simencrypt"
 )
 
+// User2Pb Id is deprecated and kept for old clients, EncryptedId is the id every other message carries.
 func User2Pb(userInfo *bizuserinters.UserInfo) *userpb.UserInfo {
 	if userInfo == nil {
 		return nil
@@ -15,6 +17,7 @@ func User2Pb(userInfo *bizuserinters.UserInfo) *userpb.UserInfo {
 		UserName:      userInfo.UserName,
 		HasGoogle_2Fa: userInfo.HasGoogle2FA,
 		Admin:         userInfo.Admin,
+		EncryptedId:   simencrypt.EncryptUInt64(userInfo.ID),
 	}
 }
 
@@ -27,3 +30,22 @@ func Users2Pb(userInfos []*bizuserinters.UserInfo) []*userpb.UserInfo {
 
 	return us
 }
+
+// UserAuthenticators bizuserlib keeps no per user authenticator list, it's derived from the user's flags.
+func UserAuthenticators(userInfo *bizuserinters.UserInfo) []bizuserinters.AuthenticatorIdentity {
+	authenticators := []bizuserinters.AuthenticatorIdentity{bizuserinters.AuthenticatorUserPass}
+
+	if userInfo.HasGoogle2FA {
+		authenticators = append(authenticators, bizuserinters.AuthenticatorGoogle2FA)
+	}
+
+	if userInfo.Admin {
+		authenticators = append(authenticators, bizuserinters.AuthenticatorAdminFlag)
+	}
+
+	return authenticators
+}
+
+func UserAuthenticators2Pb(userInfo *bizuserinters.UserInfo) []userpb.AuthenticatorIdentity {
+	return AuthenticatorIdentities2Pb(UserAuthenticators(userInfo))
+}
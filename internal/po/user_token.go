@@ -3,17 +3,24 @@ package po
 import (
 	"github.com/s-min-sys/protorepo/gens/userpb"
 	"github.com/s-min-sys/userbe/internal/usertokenmanager/usertokenmanagerinters"
+	"github.com/sgostarter/libeasygo/crypt/simencrypt"
 )
 
+// UserTokenInfo2Pb like User2Pb, Id is deprecated and EncryptedId is the user id clients should use.
 func UserTokenInfo2Pb(info *usertokenmanagerinters.UserTokenInfo) *userpb.UserTokenInfo {
 	if info == nil {
 		return nil
 	}
 
 	return &userpb.UserTokenInfo{
-		Id:       info.ID,
-		UserName: info.UserName,
-		StartAt:  info.StartAt.Unix(),
-		Age:      int64(info.Expiration.Seconds()),
+		Id:          info.ID,
+		UserName:    info.UserName,
+		StartAt:     info.StartAt.Unix(),
+		Age:         int64(info.Expiration.Seconds()),
+		Admin:       info.Admin,
+		Roles:       info.Roles,
+		AuthMethods: info.AuthMethods,
+		AuthAt:      info.AuthAt.Unix(),
+		EncryptedId: simencrypt.EncryptUInt64(info.ID),
 	}
 }
@@ -1,6 +1,8 @@
 package server
 
 import (
+	"os"
+
 	"github.com/s-min-sys/userbe/internal/config"
 	"github.com/s-min-sys/userbe/internal/usertokenmanager"
 	"github.com/s-min-sys/userbe/internal/usertokenmanager/usertokenmanagerinters"
@@ -13,19 +15,50 @@ import (
 	"github.com/sbasestarter/bizuserlib/model/authenticator"
 	"github.com/sbasestarter/bizuserlib/model/authenticator/model"
 	"github.com/sbasestarter/bizuserlib/policy"
+	"github.com/sgostarter/i/commerr"
 )
 
 type Instances struct {
 	UserManager            bizuserinters.UserManager
 	UserTokenManager       usertokenmanagerinters.UserTokenManager
+	JWTKeyring             usertokenmanager.JWTKeyring
 	UserPassAuthenticator  userpass.Authenticator
 	Google2FAAuthenticator google2fa.Authenticator
 	AdminAuthenticator     admin.Authenticator
 }
 
-func NewInstances(tokenManagerAll bizuserinters.TokenManagerAll, jwtDataStorage usertokenmanagerinters.JWTDataStorage,
-	dbModel authenticatorinters.DBModel, cfg *config.Config) *Instances {
-	userTokenManager := usertokenmanager.NewJWTUserTokenManager("x", jwtDataStorage)
+// NewInstances a nil userTokenManager selects the JWT one built on jwtDataStorage and sessionStorage.
+// JWTKeyring is nil when opaque tokens are used and no key is configured, OpenID Connect is off then.
+func NewInstances(tokenManagerAll bizuserinters.TokenManagerAll, userTokenManager usertokenmanagerinters.UserTokenManager,
+	jwtDataStorage usertokenmanagerinters.JWTDataStorage, sessionStorage usertokenmanagerinters.SessionStorage,
+	dbModel authenticatorinters.DBModel, cfg *config.Config) (*Instances, error) {
+	var keyring usertokenmanager.JWTKeyring
+
+	if userTokenManager == nil || len(cfg.UserTokenKeys.Keys) > 0 {
+		var err error
+
+		keyring, err = newJWTKeyring(cfg)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if userTokenManager == nil {
+		userTokenManager = usertokenmanager.NewJWTUserTokenManager(keyring, jwtDataStorage, sessionStorage,
+			usertokenmanager.JWTUserTokenManagerConfigs{
+				AccessTokenExpiration: cfg.UserToken.AccessTokenExpiration,
+				MaxSessionAge:         cfg.UserToken.MaxSessionAge,
+				StorageFailurePolicy:  usertokenmanager.StorageFailurePolicy(cfg.UserToken.StorageFailurePolicy),
+				StorageFailureGrace:   cfg.UserToken.StorageFailureGrace,
+				Issuer:                cfg.UserToken.Issuer,
+				Audience:              cfg.UserToken.Audience,
+				ClockSkewLeeway:       cfg.UserToken.ClockSkewLeeway,
+			}, cfg.Logger)
+		if userTokenManager == nil {
+			return nil, commerr.ErrInvalidArgument
+		}
+	}
+
 	ply := policy.DefaultConditionAuthenticatorPolicy(tokenManagerAll)
 
 	userManagerModel := model.NewUserManagerModel(dbModel)
@@ -55,8 +88,43 @@ func NewInstances(tokenManagerAll bizuserinters.TokenManagerAll, jwtDataStorage
 	return &Instances{
 		UserManager:            userManager,
 		UserTokenManager:       userTokenManager,
+		JWTKeyring:             keyring,
 		UserPassAuthenticator:  userPassAuthenticator,
 		Google2FAAuthenticator: google2FAAuthenticator,
 		AdminAuthenticator:     adminAuthenticator,
+	}, nil
+}
+
+func newJWTKeyring(cfg *config.Config) (usertokenmanager.JWTKeyring, error) {
+	keys := make([]usertokenmanager.JWTKey, 0, len(cfg.UserTokenKeys.Keys))
+
+	for _, key := range cfg.UserTokenKeys.Keys {
+		jwtKey := usertokenmanager.JWTKey{
+			ID:        key.ID,
+			Algorithm: key.Algorithm,
+			Secret:    key.Secret,
+		}
+
+		if key.PrivateKeyFile != "" {
+			d, err := os.ReadFile(key.PrivateKeyFile)
+			if err != nil {
+				return nil, err
+			}
+
+			jwtKey.PrivateKeyPEM = d
+		}
+
+		if key.PublicKeyFile != "" {
+			d, err := os.ReadFile(key.PublicKeyFile)
+			if err != nil {
+				return nil, err
+			}
+
+			jwtKey.PublicKeyPEM = d
+		}
+
+		keys = append(keys, jwtKey)
 	}
+
+	return usertokenmanager.NewJWTKeyring(cfg.UserTokenKeys.SigningKeyID, keys)
 }
@@ -0,0 +1,34 @@
+package userlookup
+
+import (
+	"context"
+
+	"github.com/sbasestarter/bizuserlib/bizuserinters"
+)
+
+// GetUser bizuserlib loads users in bulk only, every single user lookup goes through here so a direct one
+// replaces the scan in one place.
+func GetUser(ctx context.Context, userManager bizuserinters.UserManager, userID uint64) (
+	userInfo *bizuserinters.UserInfo, status bizuserinters.Status) {
+	users, status := userManager.ListUsers(ctx)
+	if status.Code != bizuserinters.StatusCodeOk {
+		return
+	}
+
+	userInfo = FindUser(users, userID)
+	if userInfo == nil {
+		status = bizuserinters.MakeStatusByCode(bizuserinters.StatusCodeNoDataError)
+	}
+
+	return
+}
+
+func FindUser(users []*bizuserinters.UserInfo, userID uint64) *bizuserinters.UserInfo {
+	for _, user := range users {
+		if user.ID == userID {
+			return user
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,162 @@
+package userserver
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"sort"
+	"strings"
+
+	"github.com/s-min-sys/protorepo/gens/userpb"
+	"github.com/s-min-sys/userbe/internal/userlookup"
+	"github.com/sbasestarter/bizuserlib/bizuserinters"
+	"github.com/sgostarter/i/commerr"
+	"github.com/sgostarter/libeasygo/crypt/simencrypt"
+)
+
+const (
+	defaultListUsersPageSize = 20
+	maxListUsersPageSize     = 200
+)
+
+// listUsersCursor is the position of the last returned user, pages stay stable when users are added or removed.
+// It is not signed: a forged cursor only moves the position within users the admin caller can list anyway.
+type listUsersCursor struct {
+	UserName string `json:"n,omitempty"`
+	UserID   string `json:"i"`
+}
+
+// isAdmin the flag in the token may be stale, the stored one decides.
+func isAdmin(users []*bizuserinters.UserInfo, userID uint64) bool {
+	user := userlookup.FindUser(users, userID)
+
+	return user != nil && user.Admin
+}
+
+func filterUsers(users []*bizuserinters.UserInfo, request *userpb.ListUsersRequest) []*bizuserinters.UserInfo {
+	filtered := make([]*bizuserinters.UserInfo, 0, len(users))
+
+	for _, user := range users {
+		if !strings.HasPrefix(user.UserName, request.GetUserNamePrefix()) ||
+			!matchBoolFilter(request.GetAdmin(), user.Admin) ||
+			!matchBoolFilter(request.GetHasGoogle_2Fa(), user.HasGoogle2FA) {
+			continue
+		}
+
+		filtered = append(filtered, user)
+	}
+
+	return filtered
+}
+
+func matchBoolFilter(filter userpb.BoolFilter, v bool) bool {
+	switch filter {
+	case userpb.BoolFilter_BOOL_FILTER_TRUE:
+		return v
+	case userpb.BoolFilter_BOOL_FILTER_FALSE:
+		return !v
+	}
+
+	return true
+}
+
+// userLess orders by the requested key, ties are broken by id so the order is total.
+func userLess(orderBy userpb.ListUsersOrderBy, desc bool) func(a, b *bizuserinters.UserInfo) bool {
+	return func(a, b *bizuserinters.UserInfo) bool {
+		if desc {
+			a, b = b, a
+		}
+
+		if orderBy == userpb.ListUsersOrderBy_LIST_USERS_ORDER_BY_USER_NAME && a.UserName != b.UserName {
+			return a.UserName < b.UserName
+		}
+
+		return a.ID < b.ID
+	}
+}
+
+func pageUsers(users []*bizuserinters.UserInfo, request *userpb.ListUsersRequest) (
+	page []*bizuserinters.UserInfo, nextCursor string, err error) {
+	less := userLess(request.GetOrderBy(), request.GetDesc())
+
+	sort.SliceStable(users, func(i, j int) bool {
+		return less(users[i], users[j])
+	})
+
+	start := 0
+
+	if request.GetCursor() != "" {
+		var after *bizuserinters.UserInfo
+
+		after, err = decodeListUsersCursor(request.GetCursor())
+		if err != nil {
+			return
+		}
+
+		start = sort.Search(len(users), func(i int) bool {
+			return less(after, users[i])
+		})
+	}
+
+	pageSize := int(request.GetPageSize())
+	if pageSize <= 0 {
+		pageSize = defaultListUsersPageSize
+	}
+
+	if pageSize > maxListUsersPageSize {
+		pageSize = maxListUsersPageSize
+	}
+
+	end := start + pageSize
+	if end >= len(users) {
+		page = users[start:]
+
+		return
+	}
+
+	page = users[start:end]
+	nextCursor = encodeListUsersCursor(page[len(page)-1])
+
+	return
+}
+
+func encodeListUsersCursor(user *bizuserinters.UserInfo) string {
+	d, _ := json.Marshal(&listUsersCursor{
+		UserName: user.UserName,
+		UserID:   simencrypt.EncryptUInt64(user.ID),
+	})
+
+	return base64.RawURLEncoding.EncodeToString(d)
+}
+
+func decodeListUsersCursor(cursor string) (user *bizuserinters.UserInfo, err error) {
+	d, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return
+	}
+
+	var c listUsersCursor
+
+	err = json.Unmarshal(d, &c)
+	if err != nil {
+		return
+	}
+
+	userID, err := simencrypt.DecryptUint64(c.UserID)
+	if err != nil {
+		return
+	}
+
+	// simencrypt has no integrity check, an altered id may still decrypt
+	if simencrypt.EncryptUInt64(userID) != c.UserID {
+		err = commerr.ErrInvalidArgument
+
+		return
+	}
+
+	user = &bizuserinters.UserInfo{
+		ID:       userID,
+		UserName: c.UserName,
+	}
+
+	return
+}
@@ -0,0 +1,174 @@
+package userserver
+
+import (
+	"encoding/base64"
+	"testing"
+
+	"github.com/s-min-sys/protorepo/gens/userpb"
+	"github.com/sbasestarter/bizuserlib/bizuserinters"
+)
+
+func testUsers() []*bizuserinters.UserInfo {
+	return []*bizuserinters.UserInfo{
+		{ID: 3, UserName: "bob", Admin: true},
+		{ID: 1, UserName: "alice", HasGoogle2FA: true},
+		{ID: 4, UserName: "bob"},
+		{ID: 2, UserName: "carol", Admin: true, HasGoogle2FA: true},
+		{ID: 5, UserName: "alex"},
+	}
+}
+
+func userIDs(users []*bizuserinters.UserInfo) []uint64 {
+	ids := make([]uint64, 0, len(users))
+
+	for _, user := range users {
+		ids = append(ids, user.ID)
+	}
+
+	return ids
+}
+
+func equalIDs(a, b []uint64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+func TestIsAdmin(t *testing.T) {
+	cases := []struct {
+		name   string
+		userID uint64
+		admin  bool
+	}{
+		{"admin", 3, true},
+		{"not admin", 1, false},
+		{"unknown user", 9, false},
+	}
+
+	for _, c := range cases {
+		if isAdmin(testUsers(), c.userID) != c.admin {
+			t.Error(c.name)
+		}
+	}
+}
+
+func TestFilterUsers(t *testing.T) {
+	cases := []struct {
+		name    string
+		request *userpb.ListUsersRequest
+		ids     []uint64
+	}{
+		{"no filter", &userpb.ListUsersRequest{}, []uint64{3, 1, 4, 2, 5}},
+		{"prefix", &userpb.ListUsersRequest{UserNamePrefix: "al"}, []uint64{1, 5}},
+		{"admin", &userpb.ListUsersRequest{Admin: userpb.BoolFilter_BOOL_FILTER_TRUE}, []uint64{3, 2}},
+		{"not admin", &userpb.ListUsersRequest{Admin: userpb.BoolFilter_BOOL_FILTER_FALSE}, []uint64{1, 4, 5}},
+		{"2fa", &userpb.ListUsersRequest{HasGoogle_2Fa: userpb.BoolFilter_BOOL_FILTER_TRUE}, []uint64{1, 2}},
+		{"combined", &userpb.ListUsersRequest{
+			UserNamePrefix: "c",
+			Admin:          userpb.BoolFilter_BOOL_FILTER_TRUE,
+			HasGoogle_2Fa:  userpb.BoolFilter_BOOL_FILTER_FALSE,
+		}, []uint64{}},
+	}
+
+	for _, c := range cases {
+		if ids := userIDs(filterUsers(testUsers(), c.request)); !equalIDs(ids, c.ids) {
+			t.Error(c.name, ids)
+		}
+	}
+}
+
+func TestPageUsers(t *testing.T) {
+	byName := userpb.ListUsersOrderBy_LIST_USERS_ORDER_BY_USER_NAME
+
+	cases := []struct {
+		name    string
+		request *userpb.ListUsersRequest
+		pages   [][]uint64
+	}{
+		{"by id", &userpb.ListUsersRequest{PageSize: 2}, [][]uint64{{1, 2}, {3, 4}, {5}}},
+		{"by id desc", &userpb.ListUsersRequest{PageSize: 2, Desc: true}, [][]uint64{{5, 4}, {3, 2}, {1}}},
+		// bob twice, ties are broken by id
+		{"by name", &userpb.ListUsersRequest{PageSize: 3, OrderBy: byName}, [][]uint64{{5, 1, 3}, {4, 2}}},
+		{"by name desc", &userpb.ListUsersRequest{PageSize: 2, OrderBy: byName, Desc: true},
+			[][]uint64{{2, 4}, {3, 1}, {5}}},
+		{"exact last page", &userpb.ListUsersRequest{PageSize: 5}, [][]uint64{{1, 2, 3, 4, 5}}},
+		{"default page size", &userpb.ListUsersRequest{}, [][]uint64{{1, 2, 3, 4, 5}}},
+	}
+
+	for _, c := range cases {
+		request := c.request
+
+		for i, want := range c.pages {
+			page, nextCursor, err := pageUsers(testUsers(), request)
+			if err != nil {
+				t.Fatal(c.name, err)
+			}
+
+			if ids := userIDs(page); !equalIDs(ids, want) {
+				t.Fatal(c.name, i, ids)
+			}
+
+			if last := i == len(c.pages)-1; last != (nextCursor == "") {
+				t.Fatal(c.name, i, "only the last page has no cursor", nextCursor)
+			}
+
+			request.Cursor = nextCursor
+		}
+	}
+}
+
+func TestPageUsersRemovedUser(t *testing.T) {
+	page, nextCursor, _ := pageUsers(testUsers(), &userpb.ListUsersRequest{PageSize: 2})
+
+	// the last user of the page is gone, the next page starts right after where it was
+	users := testUsers()
+	for i, user := range users {
+		if user.ID == page[1].ID {
+			users = append(users[:i], users[i+1:]...)
+
+			break
+		}
+	}
+
+	page, _, err := pageUsers(users, &userpb.ListUsersRequest{PageSize: 2, Cursor: nextCursor})
+	if err != nil || !equalIDs(userIDs(page), []uint64{3, 4}) {
+		t.Fatal(userIDs(page), err)
+	}
+}
+
+func TestPageUsersInvalidCursor(t *testing.T) {
+	cursors := map[string]string{
+		"not base64":   "!!",
+		"not json":     base64.RawURLEncoding.EncodeToString([]byte("x")),
+		"plain id":     base64.RawURLEncoding.EncodeToString([]byte(`{"i":"2"}`)),
+		"bad id":       base64.RawURLEncoding.EncodeToString([]byte(`{"n":"bob","i":"-"}`)),
+		"std encoding": base64.StdEncoding.EncodeToString([]byte(`{"i":""}`)),
+	}
+
+	for name, cursor := range cursors {
+		if _, _, err := pageUsers(testUsers(), &userpb.ListUsersRequest{Cursor: cursor}); err == nil {
+			t.Error(name, "must be rejected")
+		}
+	}
+}
+
+func TestPageUsersForgedCursor(t *testing.T) {
+	// a position no page ended on, the listing resumes right after it
+	cursor := encodeListUsersCursor(&bizuserinters.UserInfo{ID: 2, UserName: "bob"})
+
+	page, nextCursor, err := pageUsers(testUsers(), &userpb.ListUsersRequest{
+		Cursor:  cursor,
+		OrderBy: userpb.ListUsersOrderBy_LIST_USERS_ORDER_BY_USER_NAME,
+	})
+	if err != nil || nextCursor != "" || !equalIDs(userIDs(page), []uint64{3, 4, 2}) {
+		t.Fatal(userIDs(page), nextCursor, err)
+	}
+}
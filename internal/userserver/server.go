@@ -2,17 +2,20 @@ package userserver
 
 import (
 	"context"
-	"time"
 
 	"github.com/s-min-sys/protorepo/gens/userpb"
 	"github.com/s-min-sys/userbe/internal/po"
+	"github.com/s-min-sys/userbe/internal/userlookup"
 	"github.com/s-min-sys/userbe/internal/usertokenmanager/usertokenmanagerinters"
 	"github.com/sbasestarter/bizuserlib/bizuserinters"
 	"github.com/sgostarter/libeasygo/crypt/simencrypt"
 )
 
-func NewServer(userManager bizuserinters.UserManager, userTokenManager usertokenmanagerinters.UserTokenManager, defaultDomain string) userpb.UserServicerServer {
-	if userManager == nil {
+func NewServer(userManager bizuserinters.UserManager, userTokenManager usertokenmanagerinters.UserTokenManager, defaultDomain string,
+	tokenLifetimes TokenLifetimes, stepUpPolicy StepUpPolicy, oAuthGrantStore usertokenmanagerinters.OAuthGrantStore,
+	oAuthTokenStore usertokenmanagerinters.OAuthTokenStore,
+	bizFlowStorage usertokenmanagerinters.BizFlowStorage) userpb.UserServicerServer {
+	if userManager == nil || bizFlowStorage == nil {
 		return nil
 	}
 
@@ -20,6 +23,11 @@ func NewServer(userManager bizuserinters.UserManager, userTokenManager usertoken
 		userManager:      userManager,
 		defaultDomain:    defaultDomain,
 		userTokenManager: userTokenManager,
+		bizFlowStorage:   bizFlowStorage,
+		tokenLifetimes:   tokenLifetimes,
+		stepUpPolicy:     stepUpPolicy,
+		oAuthGrantStore:  oAuthGrantStore,
+		oAuthTokenStore:  oAuthTokenStore,
 	}
 }
 
@@ -28,8 +36,11 @@ type serverImpl struct {
 	userManager      bizuserinters.UserManager
 	defaultDomain    string
 	userTokenManager usertokenmanagerinters.UserTokenManager
-
-	defaultTokenExpiration time.Duration
+	bizFlowStorage   usertokenmanagerinters.BizFlowStorage
+	tokenLifetimes   TokenLifetimes
+	stepUpPolicy     StepUpPolicy
+	oAuthGrantStore  usertokenmanagerinters.OAuthGrantStore
+	oAuthTokenStore  usertokenmanagerinters.OAuthTokenStore
 }
 
 func (impl *serverImpl) RegisterBegin(ctx context.Context, request *userpb.RegisterBeginRequest) (*userpb.RegisterBeginResponse, error) {
@@ -85,13 +96,10 @@ func (impl *serverImpl) RegisterEnd(ctx context.Context, request *userpb.Registe
 
 	///
 
-	tokenExpiration := impl.defaultTokenExpiration
+	tokenExpiration := impl.tokenExpiration(ctx, false)
 
-	token, status := impl.userTokenManager.GenToken(ctx, &usertokenmanagerinters.UserTokenInfo{
-		ID:         userInfo.ID,
-		UserName:   userInfo.UserName,
-		Expiration: tokenExpiration,
-	})
+	token, status := impl.userTokenManager.GenToken(ctx, newUserTokenInfo(userInfo,
+		impl.popAuthMethods(ctx, request.GetBizId()), tokenExpiration))
 
 	if status.Code != bizuserinters.StatusCodeOk {
 		return &userpb.RegisterEndResponse{
@@ -99,7 +107,7 @@ func (impl *serverImpl) RegisterEnd(ctx context.Context, request *userpb.Registe
 		}, nil
 	}
 
-	err := impl.SetUserTokenCookie(ctx, token, tokenExpiration)
+	err := impl.SetUserTokenCookie(ctx, token)
 	if err != nil {
 		return &userpb.RegisterEndResponse{
 			Status: po.StatusCode2PbWithError(bizuserinters.StatusCodeInternalError, err),
@@ -164,13 +172,20 @@ func (impl *serverImpl) LoginEnd(ctx context.Context, request *userpb.LoginEndRe
 	}
 
 	///
-	expiration := impl.defaultTokenExpiration
 
-	token, status := impl.userTokenManager.GenToken(ctx, &usertokenmanagerinters.UserTokenInfo{
-		ID:         userInfo.ID,
-		UserName:   userInfo.UserName,
-		Expiration: expiration,
-	})
+	if request.GetStepUp() {
+		status = impl.stepUp(ctx, userInfo, impl.popAuthMethods(ctx, request.GetBizId()))
+
+		return &userpb.LoginEndResponse{
+			Status: po.Status2Pb(status),
+			UserId: simencrypt.EncryptUInt64(userInfo.ID),
+		}, nil
+	}
+
+	expiration := impl.tokenExpiration(ctx, request.GetRememberMe())
+
+	token, status := impl.userTokenManager.GenToken(ctx, newUserTokenInfo(userInfo,
+		impl.popAuthMethods(ctx, request.GetBizId()), expiration))
 
 	if status.Code != bizuserinters.StatusCodeOk {
 		return &userpb.LoginEndResponse{
@@ -180,7 +195,7 @@ func (impl *serverImpl) LoginEnd(ctx context.Context, request *userpb.LoginEndRe
 
 	///
 
-	err := impl.SetUserTokenCookie(ctx, token, expiration)
+	err := impl.SetUserTokenCookie(ctx, token)
 	if err != nil {
 		return &userpb.LoginEndResponse{
 			Status: po.StatusCode2PbWithError(bizuserinters.StatusCodeInternalError, err),
@@ -216,8 +231,18 @@ func (impl *serverImpl) ChangeBegin(ctx context.Context, request *userpb.ChangeB
 		}, nil
 	}
 
+	status = impl.checkRecentAuth(ctx, userTokenInfo)
+	if status.Code != bizuserinters.StatusCodeOk {
+		return &userpb.ChangeBeginResponse{
+			Status: po.Status2Pb(status),
+		}, nil
+	}
+
 	bizID, neededOrEvent, status := impl.userManager.ChangeBegin(ctx, userTokenInfo.ID, userTokenInfo.UserName,
 		po.AuthenticatorIdentitiesFromPb(request.GetAuthenticators()))
+	if status.Code == bizuserinters.StatusCodeOk {
+		status = impl.rememberFlowUser(ctx, bizID, userTokenInfo.ID)
+	}
 
 	return &userpb.ChangeBeginResponse{
 		Status:         po.Status2Pb(status),
@@ -252,7 +277,49 @@ func (impl *serverImpl) ChangeEnd(ctx context.Context, request *userpb.ChangeEnd
 		}, nil
 	}
 
-	status := impl.userManager.ChangeEnd(ctx, request.GetBizId())
+	userID, status := impl.flowUser(ctx, request.GetBizId())
+	if status.Code != bizuserinters.StatusCodeOk {
+		return &userpb.ChangeEndResponse{
+			Status: po.Status2Pb(status),
+		}, nil
+	}
+
+	token, _ := ExtractTokenFromGRPCContext(ctx)
+	userTokenInfo, tokenStatus := impl.userTokenManager.ExplainToken(ctx, token)
+
+	status = impl.userManager.ChangeEnd(ctx, request.GetBizId())
+	if status.Code != bizuserinters.StatusCodeOk {
+		return &userpb.ChangeEndResponse{
+			Status: po.Status2Pb(status),
+		}, nil
+	}
+
+	_ = impl.bizFlowStorage.DeleteFlow(ctx, request.GetBizId())
+
+	// authenticators changed, tokens issued before are not trusted anymore, only the caller gets a new session
+	status = impl.userTokenManager.RevokeUserTokens(ctx, userID)
+	if status.Code != bizuserinters.StatusCodeOk || tokenStatus.Code != bizuserinters.StatusCodeOk ||
+		userTokenInfo.ID != userID {
+		return &userpb.ChangeEndResponse{
+			Status: po.Status2Pb(status),
+		}, nil
+	}
+
+	newTokenInfo := *userTokenInfo
+
+	newToken, status := impl.userTokenManager.GenToken(ctx, &newTokenInfo)
+	if status.Code != bizuserinters.StatusCodeOk {
+		return &userpb.ChangeEndResponse{
+			Status: po.Status2Pb(status),
+		}, nil
+	}
+
+	err := impl.SetUserTokenCookie(ctx, newToken)
+	if err != nil {
+		return &userpb.ChangeEndResponse{
+			Status: po.StatusCode2PbWithError(bizuserinters.StatusCodeInternalError, err),
+		}, nil
+	}
 
 	return &userpb.ChangeEndResponse{
 		Status: po.Status2Pb(status),
@@ -282,8 +349,18 @@ func (impl *serverImpl) DeleteBegin(ctx context.Context, request *userpb.DeleteB
 		}, nil
 	}
 
+	status = impl.checkRecentAuth(ctx, userTokenInfo)
+	if status.Code != bizuserinters.StatusCodeOk {
+		return &userpb.DeleteBeginResponse{
+			Status: po.Status2Pb(status),
+		}, nil
+	}
+
 	bizID, neededOrEvent, status := impl.userManager.DeleteBegin(ctx, userTokenInfo.ID, userTokenInfo.UserName,
 		po.AuthenticatorIdentitiesFromPb(request.GetAuthenticators()))
+	if status.Code == bizuserinters.StatusCodeOk {
+		status = impl.rememberFlowUser(ctx, bizID, userTokenInfo.ID)
+	}
 
 	return &userpb.DeleteBeginResponse{
 		Status:         po.Status2Pb(status),
@@ -318,7 +395,28 @@ func (impl *serverImpl) DeleteEnd(ctx context.Context, request *userpb.DeleteEnd
 		}, nil
 	}
 
-	status := impl.userManager.DeleteEnd(ctx, request.GetBizId())
+	userID, status := impl.flowUser(ctx, request.GetBizId())
+	if status.Code != bizuserinters.StatusCodeOk {
+		return &userpb.DeleteEndResponse{
+			Status: po.Status2Pb(status),
+		}, nil
+	}
+
+	status = impl.userManager.DeleteEnd(ctx, request.GetBizId())
+	if status.Code != bizuserinters.StatusCodeOk {
+		return &userpb.DeleteEndResponse{
+			Status: po.Status2Pb(status),
+		}, nil
+	}
+
+	_ = impl.bizFlowStorage.DeleteFlow(ctx, request.GetBizId())
+
+	token, _ := ExtractTokenFromGRPCContext(ctx)
+	refreshToken, _ := ExtractRefreshTokenFromGRPCContext(ctx)
+
+	_ = impl.UnsetUserTokenCookie(ctx, token, refreshToken)
+
+	status = impl.userTokenManager.RevokeUserTokens(ctx, userID)
 
 	return &userpb.DeleteEndResponse{
 		Status: po.Status2Pb(status),
@@ -341,7 +439,7 @@ func (impl *serverImpl) ListUsers(ctx context.Context, request *userpb.ListUsers
 		}, nil
 	}
 
-	_, status := impl.userTokenManager.ExplainToken(ctx, token)
+	userTokenInfo, status := impl.userTokenManager.ExplainToken(ctx, token)
 	if status.Code != bizuserinters.StatusCodeOk {
 		return &userpb.ListUsersResponse{
 			Status: po.Status2Pb(status),
@@ -349,10 +447,29 @@ func (impl *serverImpl) ListUsers(ctx context.Context, request *userpb.ListUsers
 	}
 
 	users, status := impl.userManager.ListUsers(ctx)
+	if status.Code != bizuserinters.StatusCodeOk {
+		return &userpb.ListUsersResponse{
+			Status: po.Status2Pb(status),
+		}, nil
+	}
+
+	if !isAdmin(users, userTokenInfo.ID) {
+		return &userpb.ListUsersResponse{
+			Status: po.StatusCode2PbWithError(bizuserinters.StatusCodePermissionError, nil),
+		}, nil
+	}
+
+	users, nextCursor, err := pageUsers(filterUsers(users, request), request)
+	if err != nil {
+		return &userpb.ListUsersResponse{
+			Status: po.StatusCode2PbWithError(bizuserinters.StatusCodeInvalidArgsError, err),
+		}, nil
+	}
 
 	return &userpb.ListUsersResponse{
-		Status:    po.Status2Pb(status),
-		UserInfos: po.Users2Pb(users),
+		Status:     po.Status2Pb(status),
+		UserInfos:  po.Users2Pb(users),
+		NextCursor: nextCursor,
 	}, nil
 }
 
@@ -385,6 +502,44 @@ func (impl *serverImpl) CheckToken(ctx context.Context, request *userpb.CheckTok
 	}, nil
 }
 
+func (impl *serverImpl) GetMe(ctx context.Context, request *userpb.GetMeRequest) (*userpb.GetMeResponse, error) {
+	if request == nil || request.ValidateAll() != nil {
+		return &userpb.GetMeResponse{
+			Status: &userpb.Status{
+				Code: userpb.Code_CODE_INVALID_ARGS_ERROR,
+			},
+		}, nil
+	}
+
+	token, err := ExtractTokenFromGRPCContext(ctx)
+	if err != nil {
+		return &userpb.GetMeResponse{
+			Status: po.StatusCode2PbWithError(bizuserinters.StatusCodePermissionError, err),
+		}, nil
+	}
+
+	userTokenInfo, status := impl.userTokenManager.ExplainToken(ctx, token)
+	if status.Code != bizuserinters.StatusCodeOk {
+		return &userpb.GetMeResponse{
+			Status: po.Status2Pb(status),
+		}, nil
+	}
+
+	userInfo, status := userlookup.GetUser(ctx, impl.userManager, userTokenInfo.ID)
+	if status.Code != bizuserinters.StatusCodeOk {
+		return &userpb.GetMeResponse{
+			Status: po.Status2Pb(status),
+		}, nil
+	}
+
+	return &userpb.GetMeResponse{
+		Status:         po.Status2Pb(status),
+		UserInfo:       po.User2Pb(userInfo),
+		Authenticators: po.UserAuthenticators2Pb(userInfo),
+		TokenInfo:      po.UserTokenInfo2Pb(userTokenInfo),
+	}, nil
+}
+
 func (impl *serverImpl) RenewToken(ctx context.Context, request *userpb.RenewTokenRequest) (*userpb.RenewTokenResponse, error) {
 	if request == nil || request.ValidateAll() != nil {
 		return &userpb.RenewTokenResponse{
@@ -394,21 +549,21 @@ func (impl *serverImpl) RenewToken(ctx context.Context, request *userpb.RenewTok
 		}, nil
 	}
 
-	token, err := ExtractTokenFromGRPCContext(ctx)
+	refreshToken, err := ExtractRefreshTokenFromGRPCContext(ctx)
 	if err != nil {
 		return &userpb.RenewTokenResponse{
 			Status: po.StatusCode2PbWithError(bizuserinters.StatusCodePermissionError, err),
 		}, nil
 	}
 
-	newTokenStr, info, status := impl.userTokenManager.RenewToken(ctx, token)
+	newToken, info, status := impl.userTokenManager.RenewToken(ctx, refreshToken)
 	if status.Code != bizuserinters.StatusCodeOk {
 		return &userpb.RenewTokenResponse{
 			Status: po.Status2Pb(status),
 		}, nil
 	}
 
-	err = impl.SetUserTokenCookie(ctx, newTokenStr, info.Expiration)
+	err = impl.SetUserTokenCookie(ctx, newToken)
 	if err != nil {
 		return &userpb.RenewTokenResponse{
 			Status: po.StatusCode2PbWithError(bizuserinters.StatusCodeInternalError, err),
@@ -417,7 +572,7 @@ func (impl *serverImpl) RenewToken(ctx context.Context, request *userpb.RenewTok
 
 	return &userpb.RenewTokenResponse{
 		Status:    po.Status2Pb(status),
-		NewToken:  token,
+		NewToken:  newToken.AccessToken,
 		TokenInfo: po.UserTokenInfo2Pb(info),
 	}, nil
 }
@@ -438,11 +593,277 @@ func (impl *serverImpl) Logout(ctx context.Context, request *userpb.LogoutReques
 		}, nil
 	}
 
-	_ = impl.UnsetUserTokenCookie(ctx, token)
+	refreshToken, _ := ExtractRefreshTokenFromGRPCContext(ctx)
+
+	_ = impl.UnsetUserTokenCookie(ctx, token, refreshToken)
 
 	status := impl.userTokenManager.DeleteToken(ctx, token)
 
+	// the access token may be missing, expired or already revoked, the refresh token ends the session as well
+	if status.Code != bizuserinters.StatusCodeOk && refreshToken != "" && refreshToken != token {
+		status = impl.userTokenManager.DeleteToken(ctx, refreshToken)
+	}
+
 	return &userpb.LogoutResponse{
 		Status: po.Status2Pb(status),
 	}, nil
 }
+
+func (impl *serverImpl) ListSessions(ctx context.Context, request *userpb.ListSessionsRequest) (*userpb.ListSessionsResponse, error) {
+	if request == nil || request.ValidateAll() != nil {
+		return &userpb.ListSessionsResponse{
+			Status: &userpb.Status{
+				Code: userpb.Code_CODE_INVALID_ARGS_ERROR,
+			},
+		}, nil
+	}
+
+	token, err := ExtractTokenFromGRPCContext(ctx)
+	if err != nil {
+		return &userpb.ListSessionsResponse{
+			Status: po.StatusCode2PbWithError(bizuserinters.StatusCodePermissionError, err),
+		}, nil
+	}
+
+	userTokenInfo, status := impl.userTokenManager.ExplainToken(ctx, token)
+	if status.Code != bizuserinters.StatusCodeOk {
+		return &userpb.ListSessionsResponse{
+			Status: po.Status2Pb(status),
+		}, nil
+	}
+
+	sessions, status := impl.userTokenManager.ListSessions(ctx, userTokenInfo.ID)
+
+	return &userpb.ListSessionsResponse{
+		Status:   po.Status2Pb(status),
+		Sessions: po.Sessions2Pb(sessions, userTokenInfo.SessionID),
+	}, nil
+}
+
+func (impl *serverImpl) RevokeSession(ctx context.Context, request *userpb.RevokeSessionRequest) (*userpb.RevokeSessionResponse, error) {
+	if request == nil || request.ValidateAll() != nil {
+		return &userpb.RevokeSessionResponse{
+			Status: &userpb.Status{
+				Code: userpb.Code_CODE_INVALID_ARGS_ERROR,
+			},
+		}, nil
+	}
+
+	token, err := ExtractTokenFromGRPCContext(ctx)
+	if err != nil {
+		return &userpb.RevokeSessionResponse{
+			Status: po.StatusCode2PbWithError(bizuserinters.StatusCodePermissionError, err),
+		}, nil
+	}
+
+	userTokenInfo, status := impl.userTokenManager.ExplainToken(ctx, token)
+	if status.Code != bizuserinters.StatusCodeOk {
+		return &userpb.RevokeSessionResponse{
+			Status: po.Status2Pb(status),
+		}, nil
+	}
+
+	status = impl.userTokenManager.RevokeSession(ctx, userTokenInfo.ID, request.GetSessionId())
+
+	return &userpb.RevokeSessionResponse{
+		Status: po.Status2Pb(status),
+	}, nil
+}
+
+func (impl *serverImpl) RevokeOtherSessions(ctx context.Context, request *userpb.RevokeOtherSessionsRequest) (*userpb.RevokeOtherSessionsResponse, error) {
+	if request == nil || request.ValidateAll() != nil {
+		return &userpb.RevokeOtherSessionsResponse{
+			Status: &userpb.Status{
+				Code: userpb.Code_CODE_INVALID_ARGS_ERROR,
+			},
+		}, nil
+	}
+
+	token, err := ExtractTokenFromGRPCContext(ctx)
+	if err != nil {
+		return &userpb.RevokeOtherSessionsResponse{
+			Status: po.StatusCode2PbWithError(bizuserinters.StatusCodePermissionError, err),
+		}, nil
+	}
+
+	userTokenInfo, status := impl.userTokenManager.ExplainToken(ctx, token)
+	if status.Code != bizuserinters.StatusCodeOk {
+		return &userpb.RevokeOtherSessionsResponse{
+			Status: po.Status2Pb(status),
+		}, nil
+	}
+
+	status = impl.userTokenManager.RevokeSessions(ctx, userTokenInfo.ID, userTokenInfo.SessionID)
+
+	return &userpb.RevokeOtherSessionsResponse{
+		Status: po.Status2Pb(status),
+	}, nil
+}
+
+func (impl *serverImpl) ListOAuthGrants(ctx context.Context, request *userpb.ListOAuthGrantsRequest) (*userpb.ListOAuthGrantsResponse, error) {
+	if request == nil || request.ValidateAll() != nil {
+		return &userpb.ListOAuthGrantsResponse{
+			Status: &userpb.Status{
+				Code: userpb.Code_CODE_INVALID_ARGS_ERROR,
+			},
+		}, nil
+	}
+
+	if impl.oAuthGrantStore == nil {
+		return &userpb.ListOAuthGrantsResponse{
+			Status: po.StatusCode2PbWithError(bizuserinters.StatusCodeNotImplementError, nil),
+		}, nil
+	}
+
+	token, err := ExtractTokenFromGRPCContext(ctx)
+	if err != nil {
+		return &userpb.ListOAuthGrantsResponse{
+			Status: po.StatusCode2PbWithError(bizuserinters.StatusCodePermissionError, err),
+		}, nil
+	}
+
+	userTokenInfo, status := impl.userTokenManager.ExplainToken(ctx, token)
+	if status.Code != bizuserinters.StatusCodeOk {
+		return &userpb.ListOAuthGrantsResponse{
+			Status: po.Status2Pb(status),
+		}, nil
+	}
+
+	grants, err := impl.oAuthGrantStore.ListGrants(ctx, userTokenInfo.ID)
+	if err != nil {
+		return &userpb.ListOAuthGrantsResponse{
+			Status: po.StatusCode2PbWithError(bizuserinters.StatusCodeInternalError, err),
+		}, nil
+	}
+
+	return &userpb.ListOAuthGrantsResponse{
+		Status: po.Status2Pb(status),
+		Grants: po.OAuthGrants2Pb(grants),
+	}, nil
+}
+
+// RevokeOAuthGrant the client has to ask for consent again, the codes and tokens it got for the user are removed.
+func (impl *serverImpl) RevokeOAuthGrant(ctx context.Context, request *userpb.RevokeOAuthGrantRequest) (*userpb.RevokeOAuthGrantResponse, error) {
+	if request == nil || request.ValidateAll() != nil || request.GetClientId() == "" {
+		return &userpb.RevokeOAuthGrantResponse{
+			Status: &userpb.Status{
+				Code: userpb.Code_CODE_INVALID_ARGS_ERROR,
+			},
+		}, nil
+	}
+
+	if impl.oAuthGrantStore == nil {
+		return &userpb.RevokeOAuthGrantResponse{
+			Status: po.StatusCode2PbWithError(bizuserinters.StatusCodeNotImplementError, nil),
+		}, nil
+	}
+
+	token, err := ExtractTokenFromGRPCContext(ctx)
+	if err != nil {
+		return &userpb.RevokeOAuthGrantResponse{
+			Status: po.StatusCode2PbWithError(bizuserinters.StatusCodePermissionError, err),
+		}, nil
+	}
+
+	userTokenInfo, status := impl.userTokenManager.ExplainToken(ctx, token)
+	if status.Code != bizuserinters.StatusCodeOk {
+		return &userpb.RevokeOAuthGrantResponse{
+			Status: po.Status2Pb(status),
+		}, nil
+	}
+
+	err = impl.oAuthGrantStore.DeleteGrant(ctx, userTokenInfo.ID, request.GetClientId())
+	if err != nil {
+		return &userpb.RevokeOAuthGrantResponse{
+			Status: po.StatusCode2PbWithError(bizuserinters.StatusCodeInternalError, err),
+		}, nil
+	}
+
+	if impl.oAuthTokenStore != nil {
+		err = impl.oAuthTokenStore.RemoveByUserClient(ctx, userTokenInfo.ID, request.GetClientId())
+		if err != nil {
+			return &userpb.RevokeOAuthGrantResponse{
+				Status: po.StatusCode2PbWithError(bizuserinters.StatusCodeInternalError, err),
+			}, nil
+		}
+	}
+
+	return &userpb.RevokeOAuthGrantResponse{
+		Status: po.Status2Pb(status),
+	}, nil
+}
+
+func (impl *serverImpl) GenSSOToken(ctx context.Context, request *userpb.GenSSOTokenRequest) (*userpb.GenSSOTokenResponse, error) {
+	if request == nil || request.ValidateAll() != nil {
+		return &userpb.GenSSOTokenResponse{
+			Status: &userpb.Status{
+				Code: userpb.Code_CODE_INVALID_ARGS_ERROR,
+			},
+		}, nil
+	}
+
+	token, err := ExtractTokenFromGRPCContext(ctx)
+	if err != nil {
+		return &userpb.GenSSOTokenResponse{
+			Status: po.StatusCode2PbWithError(bizuserinters.StatusCodePermissionError, err),
+		}, nil
+	}
+
+	ssoToken, status := impl.userTokenManager.GenSSOToken(ctx, token, &usertokenmanagerinters.SSOTokenOptions{
+		Audience: request.GetTargetDomain(),
+		ClientID: request.GetClientId(),
+		Scopes:   request.GetScopes(),
+	})
+
+	return &userpb.GenSSOTokenResponse{
+		Status:   po.Status2Pb(status),
+		SsoToken: ssoToken,
+	}, nil
+}
+
+// ExchangeSSOToken the sso token must have been issued for the domain the request comes from, the caller gets
+// a new session of its own there.
+func (impl *serverImpl) ExchangeSSOToken(ctx context.Context, request *userpb.ExchangeSSOTokenRequest) (*userpb.ExchangeSSOTokenResponse, error) {
+	if request == nil || request.ValidateAll() != nil {
+		return &userpb.ExchangeSSOTokenResponse{
+			Status: &userpb.Status{
+				Code: userpb.Code_CODE_INVALID_ARGS_ERROR,
+			},
+		}, nil
+	}
+
+	domain := impl.domainFromGRPCContext(ctx)
+	if domain == "" {
+		domain = impl.defaultDomain
+	}
+
+	ssoTokenInfo, status := impl.userTokenManager.ExplainSSOToken(ctx, request.GetSsoToken(), domain, request.GetClientId())
+	if status.Code != bizuserinters.StatusCodeOk {
+		return &userpb.ExchangeSSOTokenResponse{
+			Status: po.Status2Pb(status),
+		}, nil
+	}
+
+	newTokenInfo := *ssoTokenInfo.UserTokenInfo
+	newTokenInfo.Expiration = impl.tokenExpiration(ctx, false)
+
+	newToken, status := impl.userTokenManager.GenToken(ctx, &newTokenInfo)
+	if status.Code != bizuserinters.StatusCodeOk {
+		return &userpb.ExchangeSSOTokenResponse{
+			Status: po.Status2Pb(status),
+		}, nil
+	}
+
+	err := impl.SetUserTokenCookie(ctx, newToken)
+	if err != nil {
+		return &userpb.ExchangeSSOTokenResponse{
+			Status: po.StatusCode2PbWithError(bizuserinters.StatusCodeInternalError, err),
+		}, nil
+	}
+
+	return &userpb.ExchangeSSOTokenResponse{
+		Status: po.Status2Pb(status),
+		UserId: simencrypt.EncryptUInt64(newTokenInfo.ID),
+		Scopes: ssoTokenInfo.Scopes,
+	}, nil
+}
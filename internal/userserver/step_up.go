@@ -0,0 +1,109 @@
+package userserver
+
+import (
+	"context"
+	"time"
+
+	"github.com/s-min-sys/userbe/internal/usertokenmanager/usertokenmanagerinters"
+	"github.com/s-min-sys/userbe/pkg/grpctoken"
+	"github.com/sbasestarter/bizuserlib/bizuserinters"
+)
+
+const (
+	defaultStepUpTokenExpiration = time.Minute * 5
+)
+
+// StepUpPolicy sensitive operations need a login within RecentAuthAge or one that verified any of AuthMethods,
+// the policy is off when both are empty. A step-up login issues a step-up token for the current session living
+// TokenExpiration, the session's own tokens are kept.
+type StepUpPolicy struct {
+	RecentAuthAge   time.Duration
+	AuthMethods     []string
+	TokenExpiration time.Duration
+}
+
+func (impl *serverImpl) checkRecentAuth(ctx context.Context, userTokenInfo *usertokenmanagerinters.UserTokenInfo) (
+	status bizuserinters.Status) {
+	if impl.stepUpPolicy.RecentAuthAge <= 0 && len(impl.stepUpPolicy.AuthMethods) == 0 {
+		status = bizuserinters.MakeSuccessStatus()
+
+		return
+	}
+
+	if impl.isRecentAuth(userTokenInfo) {
+		status = bizuserinters.MakeSuccessStatus()
+
+		return
+	}
+
+	stepUpToken := grpctoken.GetStringFromGRPCContext(ctx, grpctoken.StepUpTokenKeyOnMetadata)
+	if stepUpToken != "" {
+		stepUpTokenInfo, explainStatus := impl.userTokenManager.ExplainStepUpToken(ctx, stepUpToken)
+		if explainStatus.Code == bizuserinters.StatusCodeOk && stepUpTokenInfo.ID == userTokenInfo.ID &&
+			stepUpTokenInfo.SessionID == userTokenInfo.SessionID && impl.isRecentAuth(stepUpTokenInfo) {
+			status = bizuserinters.MakeSuccessStatus()
+
+			return
+		}
+	}
+
+	status = bizuserinters.MakeStatusByCode(usertokenmanagerinters.StatusCodeReauthRequired)
+
+	return
+}
+
+func (impl *serverImpl) isRecentAuth(userTokenInfo *usertokenmanagerinters.UserTokenInfo) bool {
+	if impl.stepUpPolicy.RecentAuthAge > 0 && time.Since(userTokenInfo.AuthAt) <= impl.stepUpPolicy.RecentAuthAge {
+		return true
+	}
+
+	for _, authMethod := range impl.stepUpPolicy.AuthMethods {
+		if containsString(userTokenInfo.AuthMethods, authMethod) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// stepUp the step-up login must be done by the user of the current session, the step-up token belongs to that
+// session and is set aside its cookies so they keep their lifetime.
+func (impl *serverImpl) stepUp(ctx context.Context, userInfo *bizuserinters.UserInfo, authMethods []string) (
+	status bizuserinters.Status) {
+	token, _ := ExtractTokenFromGRPCContext(ctx)
+
+	userTokenInfo, status := impl.userTokenManager.ExplainToken(ctx, token)
+	if status.Code != bizuserinters.StatusCodeOk {
+		return
+	}
+
+	if userTokenInfo.ID != userInfo.ID {
+		status = bizuserinters.MakeStatusByCode(bizuserinters.StatusCodePermissionError)
+
+		return
+	}
+
+	expiration := impl.stepUpPolicy.TokenExpiration
+	if expiration <= 0 {
+		expiration = defaultStepUpTokenExpiration
+	}
+
+	stepUpTokenInfo := *userTokenInfo
+	stepUpTokenInfo.AuthMethods = authMethods
+	stepUpTokenInfo.AuthAt = time.Now()
+
+	stepUpToken, status := impl.userTokenManager.GenStepUpToken(ctx, &stepUpTokenInfo, expiration)
+	if status.Code != bizuserinters.StatusCodeOk {
+		return
+	}
+
+	err := impl.sendCookies(ctx, impl.newCookie(ctx, grpctoken.StepUpTokenKeyOnMetadata, stepUpToken,
+		int(expiration.Seconds())))
+	if err != nil {
+		status = bizuserinters.MakeStatusByError(bizuserinters.StatusCodeInternalError, err)
+
+		return
+	}
+
+	return
+}
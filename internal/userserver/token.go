@@ -4,8 +4,8 @@ import (
 	"context"
 	"net/http"
 	"strings"
-	"time"
 
+	"github.com/s-min-sys/userbe/internal/usertokenmanager/usertokenmanagerinters"
 	"github.com/s-min-sys/userbe/pkg/grpctoken"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/metadata"
@@ -17,42 +17,49 @@ func ExtractTokenFromGRPCContext(ctx context.Context) (token string, err error)
 	return
 }
 
-func (impl *serverImpl) SetUserTokenCookie(ctx context.Context, token string, expiration time.Duration) error {
-	domain := impl.domainFromGRPCContext(ctx)
+func ExtractRefreshTokenFromGRPCContext(ctx context.Context) (token string, err error) {
+	token = grpctoken.GetStringFromGRPCContext(ctx, grpctoken.RefreshTokenKeyOnMetadata)
 
-	if domain == "" {
-		domain = impl.defaultDomain
-	}
+	return
+}
 
-	cookie := http.Cookie{
-		Domain:   domain,
-		Name:     grpctoken.TokenKeyOnMetadata,
-		Value:    token,
-		Path:     "/",
-		HttpOnly: true,
-		MaxAge:   int(expiration.Seconds()),
-	}
+func (impl *serverImpl) SetUserTokenCookie(ctx context.Context, token *usertokenmanagerinters.UserToken) error {
+	return impl.sendCookies(ctx,
+		impl.newCookie(ctx, grpctoken.TokenKeyOnMetadata, token.AccessToken, int(token.AccessExpiration.Seconds())),
+		impl.newCookie(ctx, grpctoken.RefreshTokenKeyOnMetadata, token.RefreshToken, int(token.RefreshExpiration.Seconds())))
+}
 
-	return grpc.SendHeader(ctx, metadata.Pairs("Set-Cookie", cookie.String()))
+func (impl *serverImpl) UnsetUserTokenCookie(ctx context.Context, token, refreshToken string) error {
+	return impl.sendCookies(ctx,
+		impl.newCookie(ctx, grpctoken.TokenKeyOnMetadata, token, -1),
+		impl.newCookie(ctx, grpctoken.RefreshTokenKeyOnMetadata, refreshToken, -1))
 }
 
-func (impl *serverImpl) UnsetUserTokenCookie(ctx context.Context, token string) error {
+func (impl *serverImpl) newCookie(ctx context.Context, name, value string, maxAge int) *http.Cookie {
 	domain := impl.domainFromGRPCContext(ctx)
 
 	if domain == "" {
 		domain = impl.defaultDomain
 	}
 
-	cookie := http.Cookie{
+	return &http.Cookie{
 		Domain:   domain,
-		Name:     grpctoken.TokenKeyOnMetadata,
-		Value:    token,
+		Name:     name,
+		Value:    value,
 		Path:     "/",
 		HttpOnly: true,
-		MaxAge:   -1,
+		MaxAge:   maxAge,
+	}
+}
+
+func (impl *serverImpl) sendCookies(ctx context.Context, cookies ...*http.Cookie) error {
+	md := metadata.MD{}
+
+	for _, cookie := range cookies {
+		md.Append("Set-Cookie", cookie.String())
 	}
 
-	return grpc.SendHeader(ctx, metadata.Pairs("Set-Cookie", cookie.String()))
+	return grpc.SendHeader(ctx, md)
 }
 
 func (impl *serverImpl) domainFromGRPCContext(ctx context.Context) (domain string) {
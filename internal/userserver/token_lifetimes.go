@@ -0,0 +1,50 @@
+package userserver
+
+import (
+	"context"
+	"time"
+
+	"google.golang.org/grpc/metadata"
+)
+
+const (
+	clientTypeKeyOnMetadata = "x-client-type"
+)
+
+// TokenLifetimes zero values leave the lifetime to the token manager. ClientExpirations is keyed by the
+// x-client-type header or by the origin domain, the client type wins when both match.
+type TokenLifetimes struct {
+	Expiration           time.Duration
+	RememberMeExpiration time.Duration
+	ClientExpirations    map[string]time.Duration
+}
+
+func (impl *serverImpl) tokenExpiration(ctx context.Context, rememberMe bool) time.Duration {
+	if rememberMe && impl.tokenLifetimes.RememberMeExpiration > 0 {
+		return impl.tokenLifetimes.RememberMeExpiration
+	}
+
+	if expiration, ok := impl.tokenLifetimes.ClientExpirations[clientTypeFromGRPCContext(ctx)]; ok {
+		return expiration
+	}
+
+	if expiration, ok := impl.tokenLifetimes.ClientExpirations[impl.domainFromGRPCContext(ctx)]; ok {
+		return expiration
+	}
+
+	return impl.tokenLifetimes.Expiration
+}
+
+func clientTypeFromGRPCContext(ctx context.Context) string {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ""
+	}
+
+	values := md.Get(clientTypeKeyOnMetadata)
+	if len(values) == 0 {
+		return ""
+	}
+
+	return values[0]
+}
@@ -0,0 +1,89 @@
+package userserver
+
+import (
+	"context"
+	"time"
+
+	"github.com/s-min-sys/userbe/internal/usertokenmanager/usertokenmanagerinters"
+	"github.com/sbasestarter/bizuserlib/bizuserinters"
+)
+
+const (
+	RoleAdmin = "admin"
+)
+
+func newUserTokenInfo(userInfo *bizuserinters.UserInfo, authMethods []string, expiration time.Duration) *usertokenmanagerinters.UserTokenInfo {
+	var roles []string
+	if userInfo.Admin {
+		roles = append(roles, RoleAdmin)
+	}
+
+	return &usertokenmanagerinters.UserTokenInfo{
+		ID:          userInfo.ID,
+		UserName:    userInfo.UserName,
+		Expiration:  expiration,
+		Admin:       userInfo.Admin,
+		Roles:       roles,
+		AuthMethods: authMethods,
+		AuthAt:      time.Now(),
+	}
+}
+
+// popAuthMethods the authenticator servers record the methods completed in the flow, a flow they didn't see
+// gets no methods.
+func (impl *serverImpl) popAuthMethods(ctx context.Context, bizID string) []string {
+	flow, err := impl.bizFlowStorage.GetFlow(ctx, bizID)
+	if err != nil || flow == nil {
+		return nil
+	}
+
+	_ = impl.bizFlowStorage.DeleteFlow(ctx, bizID)
+
+	return flow.AuthMethods
+}
+
+// rememberFlowUser change and delete flows are begun with the caller's token, which may be gone when they end.
+func (impl *serverImpl) rememberFlowUser(ctx context.Context, bizID string, userID uint64) (status bizuserinters.Status) {
+	err := impl.bizFlowStorage.SetUserID(ctx, bizID, userID)
+	if err != nil {
+		status = bizuserinters.MakeStatusByError(bizuserinters.StatusCodeInternalError, err)
+
+		return
+	}
+
+	status = bizuserinters.MakeSuccessStatus()
+
+	return
+}
+
+// flowUser the flow must have been begun by ChangeBegin or DeleteBegin, otherwise the user's tokens could
+// not be revoked when it ends.
+func (impl *serverImpl) flowUser(ctx context.Context, bizID string) (userID uint64, status bizuserinters.Status) {
+	flow, err := impl.bizFlowStorage.GetFlow(ctx, bizID)
+	if err != nil {
+		status = bizuserinters.MakeStatusByError(bizuserinters.StatusCodeInternalError, err)
+
+		return
+	}
+
+	if flow == nil || flow.UserID == 0 {
+		status = bizuserinters.MakeStatusByCode(bizuserinters.StatusCodeExpiredError)
+
+		return
+	}
+
+	userID = flow.UserID
+	status = bizuserinters.MakeSuccessStatus()
+
+	return
+}
+
+func containsString(ss []string, s string) bool {
+	for _, v := range ss {
+		if v == s {
+			return true
+		}
+	}
+
+	return false
+}
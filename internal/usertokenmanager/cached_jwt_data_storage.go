@@ -0,0 +1,155 @@
+package usertokenmanager
+
+import (
+	"context"
+	"time"
+
+	"github.com/patrickmn/go-cache"
+	"github.com/s-min-sys/userbe/internal/usertokenmanager/usertokenmanagerinters"
+	"github.com/sgostarter/i/l"
+)
+
+const (
+	defaultCacheExpiration = 30 * time.Second
+)
+
+// NewCachedJWTDataStorage answers Exists and GetCounter from an in-process cache, entries live cacheExpiration
+// at most and are dropped as soon as another instance changes them through bus, a lost message only delays
+// a revocation by cacheExpiration.
+func NewCachedJWTDataStorage(ctx context.Context, storage usertokenmanagerinters.JWTDataStorage,
+	bus usertokenmanagerinters.InvalidationBus, cacheExpiration time.Duration, logger l.Wrapper) usertokenmanagerinters.JWTDataStorage {
+	if logger == nil {
+		logger = l.NewNopLoggerWrapper()
+	}
+
+	if storage == nil || bus == nil {
+		logger.Error("no storage or bus")
+
+		return nil
+	}
+
+	if cacheExpiration <= 0 {
+		cacheExpiration = defaultCacheExpiration
+	}
+
+	impl := &cachedJWTDataStorageImpl{
+		storage:         storage,
+		bus:             bus,
+		cacheExpiration: cacheExpiration,
+		d:               cache.New(cacheExpiration, cacheExpiration),
+		logger:          logger.WithFields(l.StringField(l.ClsKey, "cachedJWTDataStorageImpl")),
+	}
+
+	bus.Subscribe(ctx, impl.d.Delete)
+
+	return impl
+}
+
+type cachedJWTDataStorageImpl struct {
+	storage         usertokenmanagerinters.JWTDataStorage
+	bus             usertokenmanagerinters.InvalidationBus
+	cacheExpiration time.Duration
+	d               *cache.Cache
+	logger          l.Wrapper
+}
+
+func (impl *cachedJWTDataStorageImpl) Record(ctx context.Context, key string, expiration time.Duration) error {
+	err := impl.storage.Record(ctx, key, expiration)
+	if err != nil {
+		return err
+	}
+
+	impl.publish(ctx, key)
+
+	impl.d.Set(key, true, impl.cacheExpiration)
+
+	return nil
+}
+
+func (impl *cachedJWTDataStorageImpl) RecordIfNotExists(ctx context.Context, key string, expiration time.Duration) (recorded bool, err error) {
+	recorded, err = impl.storage.RecordIfNotExists(ctx, key, expiration)
+	if err != nil || !recorded {
+		return
+	}
+
+	impl.publish(ctx, key)
+
+	impl.d.Set(key, true, impl.cacheExpiration)
+
+	return
+}
+
+func (impl *cachedJWTDataStorageImpl) Exists(ctx context.Context, keys ...string) (t bool, err error) {
+	missedKeys := make([]string, 0, len(keys))
+
+	for _, key := range keys {
+		i, ok := impl.d.Get(key)
+		if !ok {
+			missedKeys = append(missedKeys, key)
+
+			continue
+		}
+
+		if t, _ = i.(bool); t {
+			return
+		}
+	}
+
+	if len(missedKeys) == 0 {
+		return
+	}
+
+	t, err = impl.storage.Exists(ctx, missedKeys...)
+	if err != nil {
+		return
+	}
+
+	// which of the keys exists is unknown, only the missing ones can be cached
+	if !t {
+		for _, key := range missedKeys {
+			impl.d.Set(key, false, impl.cacheExpiration)
+		}
+	}
+
+	return
+}
+
+func (impl *cachedJWTDataStorageImpl) GetCounter(ctx context.Context, key string) (n int64, err error) {
+	if i, ok := impl.d.Get(key); ok {
+		if n, ok = i.(int64); ok {
+			return
+		}
+	}
+
+	n, err = impl.storage.GetCounter(ctx, key)
+	if err != nil {
+		return
+	}
+
+	impl.d.Set(key, n, impl.cacheExpiration)
+
+	return
+}
+
+func (impl *cachedJWTDataStorageImpl) IncCounter(ctx context.Context, key string) (n int64, err error) {
+	n, err = impl.storage.IncCounter(ctx, key)
+	if err != nil {
+		return
+	}
+
+	impl.publish(ctx, key)
+
+	impl.d.Set(key, n, impl.cacheExpiration)
+
+	return
+}
+
+//
+//
+//
+
+func (impl *cachedJWTDataStorageImpl) publish(ctx context.Context, key string) {
+	if err := impl.bus.Publish(ctx, key); err != nil {
+		impl.logger.WithFields(l.ErrorField(err), l.StringField("key", key)).Error("publish invalidation failed")
+	}
+}
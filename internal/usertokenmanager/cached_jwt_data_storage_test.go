@@ -0,0 +1,59 @@
+package usertokenmanager
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+type testInvalidationBus struct {
+	lock     sync.Mutex
+	handlers []func(key string)
+}
+
+func (bus *testInvalidationBus) Publish(_ context.Context, key string) error {
+	bus.lock.Lock()
+	defer bus.lock.Unlock()
+
+	for _, handler := range bus.handlers {
+		handler(key)
+	}
+
+	return nil
+}
+
+func (bus *testInvalidationBus) Subscribe(_ context.Context, handler func(key string)) {
+	bus.lock.Lock()
+	defer bus.lock.Unlock()
+
+	bus.handlers = append(bus.handlers, handler)
+}
+
+func TestCachedJWTDataStorageInvalidation(t *testing.T) {
+	ctx := context.Background()
+	storage := NewMemoryJWTDataStorage()
+	bus := &testInvalidationBus{}
+
+	s1 := NewCachedJWTDataStorage(ctx, storage, bus, time.Hour, nil)
+	s2 := NewCachedJWTDataStorage(ctx, storage, bus, time.Hour, nil)
+
+	if t2, _ := s2.Exists(ctx, "k"); t2 {
+		t.Fatal("unexpected key")
+	}
+
+	if n, _ := s2.GetCounter(ctx, "c"); n != 0 {
+		t.Fatal("unexpected counter", n)
+	}
+
+	_ = s1.Record(ctx, "k", time.Minute)
+	_, _ = s1.IncCounter(ctx, "c")
+
+	if t2, _ := s2.Exists(ctx, "k"); !t2 {
+		t.Fatal("cached miss must be invalidated by the bus")
+	}
+
+	if n, _ := s2.GetCounter(ctx, "c"); n != 1 {
+		t.Fatal("cached counter must be invalidated by the bus", n)
+	}
+}
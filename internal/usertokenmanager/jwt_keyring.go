@@ -0,0 +1,316 @@
+package usertokenmanager
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/md5" // nolint: gosec
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"math/big"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/s-min-sys/userbe/internal/usertokenmanager/usertokenmanagerinters"
+)
+
+const (
+	jwtHeaderKeyID = "kid"
+)
+
+const (
+	JWTAlgorithmHS256 = "HS256"
+	JWTAlgorithmRS256 = "RS256"
+	JWTAlgorithmES256 = "ES256"
+	JWTAlgorithmEdDSA = "EdDSA"
+)
+
+var (
+	ErrNoSigningKey      = errors.New("no signing key")
+	ErrDupKeyID          = errors.New("duplicate key id")
+	ErrUnknownKeyID      = errors.New("unknown key id")
+	ErrAlgorithmDiff     = errors.New("algorithm mismatch")
+	ErrUnknownAlgorithm  = errors.New("unknown algorithm")
+	ErrNoKeyData         = errors.New("no key data")
+	ErrInvalidKeyData    = errors.New("invalid key data")
+	ErrUnsupportedEC     = errors.New("unsupported elliptic curve")
+	ErrNoPrivateKeyToUse = errors.New("signing key has no private key")
+)
+
+// JWTKey describes one key of the keyring. HS256 keys use Secret, the others use PEM encoded keys:
+// PrivateKeyPEM for the signing key, PublicKeyPEM is enough for keys that only verify.
+type JWTKey struct {
+	ID            string
+	Algorithm     string
+	Secret        string
+	PrivateKeyPEM []byte
+	PublicKeyPEM  []byte
+}
+
+// JWTKeyring holds every key accepted for verification and selects the one used for signing.
+// Tokens signed by a key without ID carry no kid header, and kid-less tokens are verified by that key.
+type JWTKeyring interface {
+	SigningKey() (kid string, method jwt.SigningMethod, key interface{})
+	VerifyingKey(kid string) (method jwt.SigningMethod, key interface{}, err error)
+	JWKS() *usertokenmanagerinters.JWKSet
+}
+
+func NewJWTKeyring(signingKeyID string, keys []JWTKey) (JWTKeyring, error) {
+	impl := &jwtKeyringImpl{
+		signingKeyID: signingKeyID,
+		keys:         make(map[string]*jwtKeyringKey, len(keys)),
+		jwks:         &usertokenmanagerinters.JWKSet{Keys: make([]usertokenmanagerinters.JWK, 0, len(keys))},
+	}
+
+	for _, key := range keys {
+		if _, ok := impl.keys[key.ID]; ok {
+			return nil, ErrDupKeyID
+		}
+
+		ringKey, err := newJWTKeyringKey(key)
+		if err != nil {
+			return nil, err
+		}
+
+		impl.keys[key.ID] = ringKey
+
+		if ringKey.jwk != nil {
+			impl.jwks.Keys = append(impl.jwks.Keys, *ringKey.jwk)
+		}
+	}
+
+	signingKey, ok := impl.keys[signingKeyID]
+	if !ok {
+		return nil, ErrNoSigningKey
+	}
+
+	if signingKey.signKey == nil {
+		return nil, ErrNoPrivateKeyToUse
+	}
+
+	return impl, nil
+}
+
+type jwtKeyringKey struct {
+	method    jwt.SigningMethod
+	signKey   interface{}
+	verifyKey interface{}
+	jwk       *usertokenmanagerinters.JWK
+}
+
+type jwtKeyringImpl struct {
+	signingKeyID string
+	keys         map[string]*jwtKeyringKey
+	jwks         *usertokenmanagerinters.JWKSet
+}
+
+func (impl *jwtKeyringImpl) SigningKey() (kid string, method jwt.SigningMethod, key interface{}) {
+	ringKey := impl.keys[impl.signingKeyID]
+
+	return impl.signingKeyID, ringKey.method, ringKey.signKey
+}
+
+func (impl *jwtKeyringImpl) VerifyingKey(kid string) (method jwt.SigningMethod, key interface{}, err error) {
+	ringKey, ok := impl.keys[kid]
+	if !ok {
+		err = ErrUnknownKeyID
+
+		return
+	}
+
+	method = ringKey.method
+	key = ringKey.verifyKey
+
+	return
+}
+
+func (impl *jwtKeyringImpl) JWKS() *usertokenmanagerinters.JWKSet {
+	return impl.jwks
+}
+
+//
+//
+//
+
+func newJWTKeyringKey(key JWTKey) (ringKey *jwtKeyringKey, err error) {
+	switch key.Algorithm {
+	case "", JWTAlgorithmHS256:
+		h := md5.Sum([]byte(key.Secret)) // nolint: gosec
+
+		ringKey = &jwtKeyringKey{
+			method:    jwt.SigningMethodHS256,
+			signKey:   h[:],
+			verifyKey: h[:],
+		}
+	case JWTAlgorithmRS256:
+		ringKey, err = newRSAJWTKeyringKey(key)
+	case JWTAlgorithmES256:
+		ringKey, err = newECJWTKeyringKey(key)
+	case JWTAlgorithmEdDSA:
+		ringKey, err = newEd25519JWTKeyringKey(key)
+	default:
+		err = ErrUnknownAlgorithm
+	}
+
+	if err != nil {
+		return
+	}
+
+	if ringKey.jwk != nil {
+		ringKey.jwk.KeyID = key.ID
+		ringKey.jwk.Use = "sig"
+		ringKey.jwk.Algorithm = ringKey.method.Alg()
+	}
+
+	return
+}
+
+func newRSAJWTKeyringKey(key JWTKey) (ringKey *jwtKeyringKey, err error) {
+	ringKey = &jwtKeyringKey{
+		method: jwt.SigningMethodRS256,
+	}
+
+	var publicKey *rsa.PublicKey
+
+	if len(key.PrivateKeyPEM) > 0 {
+		privateKey, e := jwt.ParseRSAPrivateKeyFromPEM(key.PrivateKeyPEM)
+		if e != nil {
+			err = e
+
+			return
+		}
+
+		ringKey.signKey = privateKey
+		publicKey = &privateKey.PublicKey
+	} else if len(key.PublicKeyPEM) > 0 {
+		publicKey, err = jwt.ParseRSAPublicKeyFromPEM(key.PublicKeyPEM)
+		if err != nil {
+			return
+		}
+	} else {
+		err = ErrNoKeyData
+
+		return
+	}
+
+	ringKey.verifyKey = publicKey
+	ringKey.jwk = &usertokenmanagerinters.JWK{
+		KeyType: "RSA",
+		N:       base64.RawURLEncoding.EncodeToString(publicKey.N.Bytes()),
+		E:       base64.RawURLEncoding.EncodeToString(big.NewInt(int64(publicKey.E)).Bytes()),
+	}
+
+	return
+}
+
+func newECJWTKeyringKey(key JWTKey) (ringKey *jwtKeyringKey, err error) {
+	ringKey = &jwtKeyringKey{
+		method: jwt.SigningMethodES256,
+	}
+
+	var publicKey *ecdsa.PublicKey
+
+	if len(key.PrivateKeyPEM) > 0 {
+		privateKey, e := jwt.ParseECPrivateKeyFromPEM(key.PrivateKeyPEM)
+		if e != nil {
+			err = e
+
+			return
+		}
+
+		ringKey.signKey = privateKey
+		publicKey = &privateKey.PublicKey
+	} else if len(key.PublicKeyPEM) > 0 {
+		publicKey, err = jwt.ParseECPublicKeyFromPEM(key.PublicKeyPEM)
+		if err != nil {
+			return
+		}
+	} else {
+		err = ErrNoKeyData
+
+		return
+	}
+
+	if publicKey.Curve != elliptic.P256() {
+		err = ErrUnsupportedEC
+
+		return
+	}
+
+	ringKey.verifyKey = publicKey
+	ringKey.jwk = &usertokenmanagerinters.JWK{
+		KeyType: "EC",
+		Curve:   "P-256",
+		X:       base64.RawURLEncoding.EncodeToString(publicKey.X.FillBytes(make([]byte, 32))),
+		Y:       base64.RawURLEncoding.EncodeToString(publicKey.Y.FillBytes(make([]byte, 32))),
+	}
+
+	return
+}
+
+func newEd25519JWTKeyringKey(key JWTKey) (ringKey *jwtKeyringKey, err error) {
+	ringKey = &jwtKeyringKey{
+		method: SigningMethodEdDSA,
+	}
+
+	var publicKey ed25519.PublicKey
+
+	if len(key.PrivateKeyPEM) > 0 {
+		i, e := parsePEM(key.PrivateKeyPEM, x509.ParsePKCS8PrivateKey)
+		if e != nil {
+			err = e
+
+			return
+		}
+
+		privateKey, ok := i.(ed25519.PrivateKey)
+		if !ok {
+			err = ErrInvalidKeyData
+
+			return
+		}
+
+		ringKey.signKey = privateKey
+		publicKey, _ = privateKey.Public().(ed25519.PublicKey)
+	} else if len(key.PublicKeyPEM) > 0 {
+		i, e := parsePEM(key.PublicKeyPEM, x509.ParsePKIXPublicKey)
+		if e != nil {
+			err = e
+
+			return
+		}
+
+		var ok bool
+
+		publicKey, ok = i.(ed25519.PublicKey)
+		if !ok {
+			err = ErrInvalidKeyData
+
+			return
+		}
+	} else {
+		err = ErrNoKeyData
+
+		return
+	}
+
+	ringKey.verifyKey = publicKey
+	ringKey.jwk = &usertokenmanagerinters.JWK{
+		KeyType: "OKP",
+		Curve:   "Ed25519",
+		X:       base64.RawURLEncoding.EncodeToString(publicKey),
+	}
+
+	return
+}
+
+func parsePEM(d []byte, parser func(der []byte) (interface{}, error)) (interface{}, error) {
+	block, _ := pem.Decode(d)
+	if block == nil {
+		return nil, ErrInvalidKeyData
+	}
+
+	return parser(block.Bytes)
+}
@@ -0,0 +1,112 @@
+package usertokenmanager
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+
+	"github.com/s-min-sys/userbe/internal/usertokenmanager/usertokenmanagerinters"
+	"github.com/sbasestarter/bizuserlib/bizuserinters"
+)
+
+func genPrivateKeyPEMs(t *testing.T) map[string][]byte {
+	t.Helper()
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ecDer, err := x509.MarshalECPrivateKey(ecKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	edDer, err := x509.MarshalPKCS8PrivateKey(edKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return map[string][]byte{
+		JWTAlgorithmRS256: pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(rsaKey)}),
+		JWTAlgorithmES256: pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: ecDer}),
+		JWTAlgorithmEdDSA: pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: edDer}),
+	}
+}
+
+func TestJWTKeyringAlgorithms(t *testing.T) {
+	for algorithm, privateKeyPEM := range genPrivateKeyPEMs(t) {
+		keyring, err := NewJWTKeyring("k1", []JWTKey{
+			{ID: "k0", Secret: "legacy"},
+			{ID: "k1", Algorithm: algorithm, PrivateKeyPEM: privateKeyPEM},
+		})
+		if err != nil {
+			t.Fatal(algorithm, err)
+		}
+
+		if len(keyring.JWKS().Keys) != 1 || keyring.JWKS().Keys[0].KeyID != "k1" {
+			t.Fatal(algorithm, "unexpected jwks", keyring.JWKS())
+		}
+
+		m := NewJWTUserTokenManager(keyring, NewMemoryJWTDataStorage(), NewMemorySessionStorage(), JWTUserTokenManagerConfigs{}, nil)
+
+		token, status := m.GenToken(context.Background(), &usertokenmanagerinters.UserTokenInfo{
+			ID:       1,
+			UserName: "u",
+		})
+		if status.Code != bizuserinters.StatusCodeOk {
+			t.Fatal(algorithm, status)
+		}
+
+		userInfo, status := m.ExplainToken(context.Background(), token.AccessToken)
+		if status.Code != bizuserinters.StatusCodeOk || userInfo.ID != 1 {
+			t.Fatal(algorithm, status)
+		}
+	}
+}
+
+func TestJWTKeyringRotation(t *testing.T) {
+	oldKeyring, err := NewJWTKeyring("k0", []JWTKey{{ID: "k0", Secret: "s0"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	storage := NewMemoryJWTDataStorage()
+
+	token, _ := NewJWTUserTokenManager(oldKeyring, storage, NewMemorySessionStorage(), JWTUserTokenManagerConfigs{}, nil).GenToken(context.Background(),
+		&usertokenmanagerinters.UserTokenInfo{ID: 1})
+
+	newKeyring, err := NewJWTKeyring("k1", []JWTKey{{ID: "k0", Secret: "s0"}, {ID: "k1", Secret: "s1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, status := NewJWTUserTokenManager(newKeyring, storage, NewMemorySessionStorage(), JWTUserTokenManagerConfigs{}, nil).ExplainToken(context.Background(), token.AccessToken); status.Code != bizuserinters.StatusCodeOk {
+		t.Fatal("token signed by a retired key must still verify", status)
+	}
+
+	droppedKeyring, err := NewJWTKeyring("k1", []JWTKey{{ID: "k1", Secret: "s1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, status := NewJWTUserTokenManager(droppedKeyring, storage, NewMemorySessionStorage(), JWTUserTokenManagerConfigs{}, nil).ExplainToken(context.Background(), token.AccessToken); status.Code == bizuserinters.StatusCodeOk {
+		t.Fatal("token signed by a dropped key must not verify")
+	}
+}
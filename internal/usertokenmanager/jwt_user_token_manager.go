@@ -2,56 +2,180 @@ package usertokenmanager
 
 import (
 	"context"
-	"crypto/md5" // nolint: gosec
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/dgrijalva/jwt-go"
 	"github.com/s-min-sys/userbe/internal/usertokenmanager/usertokenmanagerinters"
+	"github.com/s-min-sys/userbe/pkg/grpctoken"
+	uuid "github.com/satori/go.uuid"
 	"github.com/sbasestarter/bizuserlib/bizuserinters"
 	"github.com/sgostarter/i/commerr"
+	"github.com/sgostarter/i/l"
+	"github.com/sgostarter/libeasygo/crypt/simencrypt"
 )
 
 const (
-	defaultTokenExpiration = time.Hour * 24 * 31 * 12
+	defaultTokenExpiration       = time.Hour * 24 * 31 * 12
+	defaultAccessTokenExpiration = time.Minute * 15
+	defaultSSOTokenExpiration    = time.Minute
 )
 
-func NewJWTUserTokenManager(tokenSecKey string, storage usertokenmanagerinters.JWTDataStorage) usertokenmanagerinters.UserTokenManager {
-	if storage == nil {
+const (
+	tokenUseAccess  = "access"
+	tokenUseRefresh = "refresh"
+	tokenUseSSO     = "sso"
+	tokenUseStepUp  = "step_up"
+)
+
+const (
+	storageKeyPrefixRefreshTokenUsed = "rt:"
+	storageKeyPrefixSSOTokenUsed     = "st:"
+	storageKeyPrefixTokenRevoked     = "rj:"
+	storageKeyPrefixSessionRevoked   = "sr:"
+	storageKeyPrefixUserGeneration   = "ug:"
+)
+
+// StorageFailurePolicy decides whether a token is accepted when the revocation storage can't be asked.
+type StorageFailurePolicy string
+
+const (
+	// StorageFailurePolicyClosed rejects the token, the default.
+	StorageFailurePolicyClosed StorageFailurePolicy = "closed"
+	// StorageFailurePolicyOpen accepts the token, a revoked token stays usable until the storage is back.
+	StorageFailurePolicyOpen StorageFailurePolicy = "open"
+	// StorageFailurePolicyGrace accepts the token until StorageFailureGrace has passed since the storage last answered,
+	// a storage that never answered gives no grace.
+	StorageFailurePolicyGrace StorageFailurePolicy = "grace"
+)
+
+// StorageFailureStats counts the tokens accepted and rejected because the revocation storage failed.
+type StorageFailureStats struct {
+	Accepted int64
+	Rejected int64
+}
+
+// StorageFailureStatsReporter is implemented by the JWT user token manager.
+type StorageFailureStatsReporter interface {
+	StorageFailureStats() StorageFailureStats
+}
+
+var (
+	ErrUnknownStorageFailurePolicy  = errors.New("unknown storage failure policy")
+	ErrRevocationStorageUnavailable = errors.New("revocation storage unavailable")
+	ErrTokenExpired                 = errors.New("token expired")
+	ErrTokenNotValidYet             = errors.New("token not valid yet")
+	ErrTokenIssuer                  = errors.New("token issuer mismatch")
+	ErrTokenAudience                = errors.New("token audience mismatch")
+	ErrTokenClient                  = errors.New("token client mismatch")
+)
+
+// JWTUserTokenManagerConfigs Issuer and Audience are set into issued tokens and, when not empty, required in
+// verified ones. ClockSkewLeeway is tolerated on exp, iat and nbf. MaxSessionAge, counted from AuthAt, bounds
+// how long renewals may keep a session alive, zero means no bound.
+type JWTUserTokenManagerConfigs struct {
+	AccessTokenExpiration time.Duration
+	MaxSessionAge         time.Duration
+	StorageFailurePolicy  StorageFailurePolicy
+	StorageFailureGrace   time.Duration
+	Issuer                string
+	Audience              string
+	ClockSkewLeeway       time.Duration
+}
+
+func NewJWTUserTokenManager(keyring JWTKeyring, storage usertokenmanagerinters.JWTDataStorage,
+	sessionStorage usertokenmanagerinters.SessionStorage, configs JWTUserTokenManagerConfigs,
+	logger l.Wrapper) usertokenmanagerinters.UserTokenManager {
+	if logger == nil {
+		logger = l.NewNopLoggerWrapper()
+	}
+
+	if keyring == nil || storage == nil || sessionStorage == nil {
+		logger.Error("no keyring or storage")
+
 		return nil
 	}
 
-	h := md5.Sum([]byte(tokenSecKey)) // nolint: gosec
+	if configs.AccessTokenExpiration <= 0 {
+		configs.AccessTokenExpiration = defaultAccessTokenExpiration
+	}
+
+	switch configs.StorageFailurePolicy {
+	case "":
+		configs.StorageFailurePolicy = StorageFailurePolicyClosed
+	case StorageFailurePolicyClosed, StorageFailurePolicyOpen, StorageFailurePolicyGrace:
+	default:
+		logger.WithFields(l.StringField("policy", string(configs.StorageFailurePolicy))).Error(ErrUnknownStorageFailurePolicy)
+
+		return nil
+	}
 
 	return &jwtUserTokenManagerImpl{
-		tokenSecKey: h[:],
-		storage:     storage,
+		keyring:        keyring,
+		storage:        storage,
+		sessionStorage: sessionStorage,
+		configs:        configs,
+		logger:         logger.WithFields(l.StringField(l.ClsKey, "jwtUserTokenManagerImpl")),
 	}
 }
 
 type jwtUserTokenManagerImpl struct {
-	tokenSecKey interface{}
-	storage     usertokenmanagerinters.JWTDataStorage
+	keyring        JWTKeyring
+	storage        usertokenmanagerinters.JWTDataStorage
+	sessionStorage usertokenmanagerinters.SessionStorage
+	configs        JWTUserTokenManagerConfigs
+	logger         l.Wrapper
+
+	storageAnsweredAt      atomic.Int64
+	storageFailureAccepted atomic.Int64
+	storageFailureRejected atomic.Int64
 }
 
+func (impl *jwtUserTokenManagerImpl) StorageFailureStats() StorageFailureStats {
+	return StorageFailureStats{
+		Accepted: impl.storageFailureAccepted.Load(),
+		Rejected: impl.storageFailureRejected.Load(),
+	}
+}
+
+// UserClaims Generation is the user's token generation at issue time, bumping it revokes all older tokens.
+// AuthTime carries UserTokenInfo.AuthAt.
 type UserClaims struct {
 	usertokenmanagerinters.UserTokenInfo
+	Use        string `json:"use,omitempty"`
+	Generation int64  `json:"gen,omitempty"`
+	AuthTime   int64  `json:"auth_time,omitempty"`
 	jwt.StandardClaims
 }
 
+// SSOClaims carries the user claims of the parent access token, never the token itself. ParentID is the jti of
+// the parent, it and the parent session are checked when the SSO token is exchanged. The audience of
+// StandardClaims is the target service.
 type SSOClaims struct {
-	Token string
+	usertokenmanagerinters.UserTokenInfo
+	ParentID   string `json:"pid"`
+	Use        string `json:"use,omitempty"`
+	ClientID   string `json:"client_id,omitempty"`
+	Scope      string `json:"scope,omitempty"`
+	Generation int64  `json:"gen,omitempty"`
+	AuthTime   int64  `json:"auth_time,omitempty"`
 	jwt.StandardClaims
 }
 
 func (impl *jwtUserTokenManagerImpl) GenToken(ctx context.Context, userInfo *usertokenmanagerinters.UserTokenInfo) (
-	token string, status bizuserinters.Status) {
+	token *usertokenmanagerinters.UserToken, status bizuserinters.Status) {
 	if userInfo == nil || (userInfo.ID == 0 && userInfo.UserName == "") {
 		status = bizuserinters.MakeStatusByCode(bizuserinters.StatusCodeInvalidArgsError)
 
 		return
 	}
 
-	token, err := impl.generateToken(userInfo)
+	token, err := impl.generateTokens(ctx, userInfo, uuid.NewV4().String())
 	if err != nil {
 		status = bizuserinters.MakeStatusByError(bizuserinters.StatusCodeInternalError, err)
 
@@ -64,86 +188,217 @@ func (impl *jwtUserTokenManagerImpl) GenToken(ctx context.Context, userInfo *use
 }
 
 func (impl *jwtUserTokenManagerImpl) DeleteToken(ctx context.Context, token string) bizuserinters.Status {
-	if impl.hasTokenDeleted(ctx, token) {
-		return bizuserinters.MakeStatusByCode(bizuserinters.StatusCodeExpiredError)
-	}
-
-	_, expireAt, err := impl.parseToken(token)
+	claims, err := impl.parseClaims(token)
 	if err != nil {
 		return bizuserinters.MakeStatusByError(bizuserinters.StatusCodeInvalidArgsError, err)
 	}
 
-	extendDuration := time.Duration(expireAt-time.Now().Unix()) * time.Second
-	if extendDuration <= 0 {
-		extendDuration = time.Second
+	if status := impl.checkTokenDeleted(ctx, tokenID(token, &claims.StandardClaims), claims); status.Code != bizuserinters.StatusCodeOk {
+		return status
 	}
 
-	impl.deleteToken(ctx, token, extendDuration)
+	if claims.SessionID != "" {
+		impl.revokeSession(ctx, claims.ID, claims.SessionID, claims.StartAt.Add(claims.Expiration))
+	} else {
+		impl.deleteToken(ctx, tokenID(token, &claims.StandardClaims), remainDuration(claims.ExpiresAt))
+	}
 
 	return bizuserinters.MakeSuccessStatus()
 }
 
 func (impl *jwtUserTokenManagerImpl) ExplainToken(ctx context.Context, token string) (
 	userInfo *usertokenmanagerinters.UserTokenInfo, status bizuserinters.Status) {
-	if impl.hasTokenDeleted(ctx, token) {
-		status = bizuserinters.MakeStatusByCode(bizuserinters.StatusCodeExpiredError)
+	claims, err := impl.parseToken(token, tokenUseAccess)
+	if err != nil {
+		status = bizuserinters.MakeStatusByError(bizuserinters.StatusCodeInvalidArgsError, err)
+
+		return
+	}
 
+	status = impl.checkTokenDeleted(ctx, tokenID(token, &claims.StandardClaims), claims)
+	if status.Code != bizuserinters.StatusCodeOk {
 		return
 	}
 
-	userInfo, _, err := impl.parseToken(token)
+	userInfo = &claims.UserTokenInfo
+
+	status = bizuserinters.MakeSuccessStatus()
+
+	return
+}
+
+func (impl *jwtUserTokenManagerImpl) RenewToken(ctx context.Context, refreshToken string) (
+	newToken *usertokenmanagerinters.UserToken, userInfo *usertokenmanagerinters.UserTokenInfo, status bizuserinters.Status) {
+	claims, err := impl.parseToken(refreshToken, tokenUseRefresh)
 	if err != nil {
 		status = bizuserinters.MakeStatusByError(bizuserinters.StatusCodeInvalidArgsError, err)
 
 		return
 	}
 
+	status = impl.checkTokenDeleted(ctx, tokenID(refreshToken, &claims.StandardClaims), claims)
+	if status.Code != bizuserinters.StatusCodeOk {
+		return
+	}
+
+	if SessionAgeExceeded(&claims.UserTokenInfo, impl.configs.MaxSessionAge) {
+		status = bizuserinters.MakeStatusByCode(bizuserinters.StatusCodeExpiredError)
+
+		return
+	}
+
+	recorded, err := impl.storage.RecordIfNotExists(ctx, storageKeyPrefixRefreshTokenUsed+claims.Id,
+		remainDuration(claims.ExpiresAt))
+	if err != nil {
+		status = bizuserinters.MakeStatusByError(bizuserinters.StatusCodeInternalError, err)
+
+		return
+	}
+
+	if !recorded {
+		// a refresh token used twice has leaked, nobody in this session can be trusted anymore
+		impl.revokeSession(ctx, claims.ID, claims.SessionID, claims.StartAt.Add(claims.Expiration))
+
+		status = bizuserinters.MakeStatusByCode(bizuserinters.StatusCodeExpiredError)
+
+		return
+	}
+
+	userInfo = &claims.UserTokenInfo
+
+	newToken, err = impl.generateTokens(ctx, userInfo, claims.SessionID)
+	if err != nil {
+		status = bizuserinters.MakeStatusByError(bizuserinters.StatusCodeInternalError, err)
+
+		return
+	}
+
 	status = bizuserinters.MakeSuccessStatus()
 
 	return
 }
 
-func (impl *jwtUserTokenManagerImpl) RenewToken(ctx context.Context, token string) (
-	newToken string, userInfo *usertokenmanagerinters.UserTokenInfo, status bizuserinters.Status) {
-	if impl.hasTokenDeleted(ctx, token) {
-		status = bizuserinters.MakeStatusByCode(bizuserinters.StatusCodeExpiredError)
+func (impl *jwtUserTokenManagerImpl) GenSSOToken(ctx context.Context, parentToken string,
+	options *usertokenmanagerinters.SSOTokenOptions) (token string, status bizuserinters.Status) {
+	if options == nil || options.Audience == "" || options.ClientID == "" {
+		status = bizuserinters.MakeStatusByCode(bizuserinters.StatusCodeInvalidArgsError)
+
+		return
+	}
+
+	parent, err := impl.parseToken(parentToken, tokenUseAccess)
+	if err != nil {
+		status = bizuserinters.MakeStatusByError(bizuserinters.StatusCodeInvalidArgsError, err)
 
 		return
 	}
 
-	userInfo, _, err := impl.parseToken(token)
+	parentID := tokenID(parentToken, &parent.StandardClaims)
+
+	status = impl.checkTokenDeleted(ctx, parentID, parent)
+	if status.Code != bizuserinters.StatusCodeOk {
+		return
+	}
+
+	expiration := options.Expiration
+	if expiration <= 0 {
+		expiration = defaultSSOTokenExpiration
+	}
+
+	claims := SSOClaims{
+		UserTokenInfo:  parent.UserTokenInfo,
+		ParentID:       parentID,
+		Use:            tokenUseSSO,
+		ClientID:       options.ClientID,
+		Scope:          strings.Join(options.Scopes, " "),
+		Generation:     parent.Generation,
+		AuthTime:       parent.AuthTime,
+		StandardClaims: impl.newStandardClaims(parent.ID, time.Now(), expiration),
+	}
+	claims.Audience = options.Audience
+
+	token, err = impl.generateSSOToken(claims)
+	if err != nil {
+		status = bizuserinters.MakeStatusByError(bizuserinters.StatusCodeInternalError, err)
+
+		return
+	}
+
+	status = bizuserinters.MakeSuccessStatus()
+
+	return
+}
+
+func (impl *jwtUserTokenManagerImpl) ExplainSSOToken(ctx context.Context, token, audience, clientID string) (
+	ssoTokenInfo *usertokenmanagerinters.SSOTokenInfo, status bizuserinters.Status) {
+	claims, err := impl.parseSSOToken(token, audience)
 	if err != nil {
 		status = bizuserinters.MakeStatusByError(bizuserinters.StatusCodeInvalidArgsError, err)
 
 		return
 	}
 
-	newToken, err = impl.generateToken(userInfo)
+	if claims.ClientID != clientID {
+		status = bizuserinters.MakeStatusByError(bizuserinters.StatusCodePermissionError, ErrTokenClient)
+
+		return
+	}
+
+	status = impl.checkTokenDeleted(ctx, tokenID(token, &claims.StandardClaims), nil)
+	if status.Code != bizuserinters.StatusCodeOk {
+		return
+	}
+
+	recorded, err := impl.storage.RecordIfNotExists(ctx, storageKeyPrefixSSOTokenUsed+tokenID(token, &claims.StandardClaims),
+		remainDuration(claims.ExpiresAt))
 	if err != nil {
 		status = bizuserinters.MakeStatusByError(bizuserinters.StatusCodeInternalError, err)
 
 		return
 	}
 
-	impl.deleteToken(ctx, token, time.Minute)
+	if !recorded {
+		status = bizuserinters.MakeStatusByCode(bizuserinters.StatusCodeExpiredError)
 
-	status = bizuserinters.MakeSuccessStatus()
+		return
+	}
+
+	userInfo, status := impl.explainSSOParent(ctx, claims)
+	if status.Code != bizuserinters.StatusCodeOk {
+		return
+	}
+
+	ssoTokenInfo = &usertokenmanagerinters.SSOTokenInfo{
+		UserTokenInfo: userInfo,
+		Audience:      claims.Audience,
+		ClientID:      claims.ClientID,
+		Scopes:        strings.Fields(claims.Scope),
+	}
 
 	return
 }
 
-func (impl *jwtUserTokenManagerImpl) GenSSOToken(ctx context.Context, parentToken string,
+func (impl *jwtUserTokenManagerImpl) GenStepUpToken(ctx context.Context, userInfo *usertokenmanagerinters.UserTokenInfo,
 	expiration time.Duration) (token string, status bizuserinters.Status) {
-	_, status = impl.ExplainToken(ctx, parentToken)
-	if status.Code != bizuserinters.StatusCodeOk {
+	if userInfo == nil || userInfo.ID == 0 || userInfo.SessionID == "" || expiration <= 0 {
+		status = bizuserinters.MakeStatusByCode(bizuserinters.StatusCodeInvalidArgsError)
+
 		return
 	}
 
-	token, err := impl.generateSSOToken(SSOClaims{
-		Token: parentToken,
-		StandardClaims: jwt.StandardClaims{
-			ExpiresAt: time.Now().Add(expiration).Unix(),
-		},
+	generation, err := impl.storage.GetCounter(ctx, userGenerationKey(userInfo.ID))
+	if err != nil {
+		status = bizuserinters.MakeStatusByError(bizuserinters.StatusCodeInternalError, err)
+
+		return
+	}
+
+	token, err = impl.signToken(UserClaims{
+		UserTokenInfo:  *userInfo,
+		Use:            tokenUseStepUp,
+		Generation:     generation,
+		AuthTime:       userInfo.AuthAt.Unix(),
+		StandardClaims: impl.newStandardClaims(userInfo.ID, time.Now(), expiration),
 	})
 	if err != nil {
 		status = bizuserinters.MakeStatusByError(bizuserinters.StatusCodeInternalError, err)
@@ -156,97 +411,450 @@ func (impl *jwtUserTokenManagerImpl) GenSSOToken(ctx context.Context, parentToke
 	return
 }
 
-func (impl *jwtUserTokenManagerImpl) ExplainSSOToken(ctx context.Context, token string) (userInfo *usertokenmanagerinters.UserTokenInfo, status bizuserinters.Status) {
-	if impl.hasTokenDeleted(ctx, token) {
-		status = bizuserinters.MakeStatusByCode(bizuserinters.StatusCodeExpiredError)
+func (impl *jwtUserTokenManagerImpl) ExplainStepUpToken(ctx context.Context, token string) (
+	userInfo *usertokenmanagerinters.UserTokenInfo, status bizuserinters.Status) {
+	claims, err := impl.parseToken(token, tokenUseStepUp)
+	if err != nil {
+		status = bizuserinters.MakeStatusByError(bizuserinters.StatusCodeInvalidArgsError, err)
+
+		return
+	}
 
+	status = impl.checkTokenDeleted(ctx, tokenID(token, &claims.StandardClaims), claims)
+	if status.Code != bizuserinters.StatusCodeOk {
 		return
 	}
 
-	parentToken, err := impl.parseSSOToken(token)
+	userInfo = &claims.UserTokenInfo
+
+	status = bizuserinters.MakeSuccessStatus()
+
+	return
+}
+
+func (impl *jwtUserTokenManagerImpl) ListSessions(ctx context.Context, userID uint64) (
+	sessions []*usertokenmanagerinters.Session, status bizuserinters.Status) {
+	sessions, err := impl.sessionStorage.ListSessions(ctx, userID)
 	if err != nil {
-		status = bizuserinters.MakeStatusByError(bizuserinters.StatusCodeInvalidArgsError, err)
+		status = bizuserinters.MakeStatusByError(bizuserinters.StatusCodeInternalError, err)
 
 		return
 	}
 
-	userInfo, status = impl.ExplainToken(ctx, parentToken)
+	status = bizuserinters.MakeSuccessStatus()
 
 	return
 }
 
+func (impl *jwtUserTokenManagerImpl) RevokeSession(ctx context.Context, userID uint64, sessionID string) bizuserinters.Status {
+	session, err := impl.sessionStorage.GetSession(ctx, userID, sessionID)
+	if err != nil {
+		return bizuserinters.MakeStatusByError(bizuserinters.StatusCodeInternalError, err)
+	}
+
+	if session == nil {
+		return bizuserinters.MakeStatusByCode(bizuserinters.StatusCodeNoDataError)
+	}
+
+	impl.revokeSession(ctx, userID, sessionID, session.ExpireAt)
+
+	return bizuserinters.MakeSuccessStatus()
+}
+
+func (impl *jwtUserTokenManagerImpl) RevokeSessions(ctx context.Context, userID uint64, exceptSessionID string) bizuserinters.Status {
+	sessions, err := impl.sessionStorage.ListSessions(ctx, userID)
+	if err != nil {
+		return bizuserinters.MakeStatusByError(bizuserinters.StatusCodeInternalError, err)
+	}
+
+	for _, session := range sessions {
+		if session.ID == exceptSessionID {
+			continue
+		}
+
+		impl.revokeSession(ctx, userID, session.ID, session.ExpireAt)
+	}
+
+	return bizuserinters.MakeSuccessStatus()
+}
+
+func (impl *jwtUserTokenManagerImpl) RevokeUserTokens(ctx context.Context, userID uint64) bizuserinters.Status {
+	_, err := impl.storage.IncCounter(ctx, userGenerationKey(userID))
+	if err != nil {
+		return bizuserinters.MakeStatusByError(bizuserinters.StatusCodeInternalError, err)
+	}
+
+	ClearSessions(ctx, impl.sessionStorage, userID)
+
+	return bizuserinters.MakeSuccessStatus()
+}
+
 //
 //
 //
 
-func (impl *jwtUserTokenManagerImpl) generateToken(userInfo *usertokenmanagerinters.UserTokenInfo) (token string, err error) {
+func (impl *jwtUserTokenManagerImpl) generateTokens(ctx context.Context, userInfo *usertokenmanagerinters.UserTokenInfo,
+	sessionID string) (token *usertokenmanagerinters.UserToken, err error) {
+	userInfo.SessionID = sessionID
 	userInfo.StartAt = time.Now()
 
+	if userInfo.AuthAt.IsZero() {
+		userInfo.AuthAt = userInfo.StartAt
+	}
+
 	if userInfo.Expiration <= 0 {
 		userInfo.Expiration = defaultTokenExpiration
 	}
 
-	token, err = jwt.NewWithClaims(jwt.SigningMethodHS256, UserClaims{
-		UserTokenInfo: *userInfo,
-		StandardClaims: jwt.StandardClaims{
-			ExpiresAt: time.Now().Add(userInfo.Expiration).Unix(),
-		},
-	}).SignedString(impl.tokenSecKey)
+	CapSessionExpiration(userInfo, impl.configs.MaxSessionAge)
+
+	accessExpiration := impl.configs.AccessTokenExpiration
+	if accessExpiration > userInfo.Expiration {
+		accessExpiration = userInfo.Expiration
+	}
+
+	generation, err := impl.storage.GetCounter(ctx, userGenerationKey(userInfo.ID))
+	if err != nil {
+		return
+	}
+
+	accessToken, err := impl.signToken(UserClaims{
+		UserTokenInfo:  *userInfo,
+		Use:            tokenUseAccess,
+		Generation:     generation,
+		AuthTime:       userInfo.AuthAt.Unix(),
+		StandardClaims: impl.newStandardClaims(userInfo.ID, userInfo.StartAt, accessExpiration),
+	})
+	if err != nil {
+		return
+	}
+
+	refreshToken, err := impl.signToken(UserClaims{
+		UserTokenInfo:  *userInfo,
+		Use:            tokenUseRefresh,
+		Generation:     generation,
+		AuthTime:       userInfo.AuthAt.Unix(),
+		StandardClaims: impl.newStandardClaims(userInfo.ID, userInfo.StartAt, userInfo.Expiration),
+	})
+	if err != nil {
+		return
+	}
+
+	token = &usertokenmanagerinters.UserToken{
+		AccessToken:       accessToken,
+		AccessExpiration:  accessExpiration,
+		RefreshToken:      refreshToken,
+		RefreshExpiration: userInfo.Expiration,
+	}
+
+	RecordSession(ctx, impl.sessionStorage, userInfo)
 
 	return
 }
 
+func (impl *jwtUserTokenManagerImpl) newStandardClaims(userID uint64, issuedAt time.Time, expiration time.Duration) jwt.StandardClaims {
+	return jwt.StandardClaims{
+		Audience:  impl.configs.Audience,
+		ExpiresAt: issuedAt.Add(expiration).Unix(),
+		Id:        uuid.NewV4().String(),
+		IssuedAt:  issuedAt.Unix(),
+		Issuer:    impl.configs.Issuer,
+		NotBefore: issuedAt.Unix(),
+		Subject:   simencrypt.EncryptUInt64(userID),
+	}
+}
+
 func (impl *jwtUserTokenManagerImpl) generateSSOToken(c SSOClaims) (token string, err error) {
-	token, err = jwt.NewWithClaims(jwt.SigningMethodHS256, c).SignedString(impl.tokenSecKey)
+	token, err = impl.signToken(c)
 
 	return
 }
 
-func (impl *jwtUserTokenManagerImpl) parseToken(token string) (userInfo *usertokenmanagerinters.UserTokenInfo, expireAt int64, err error) {
-	var claims UserClaims
+func (impl *jwtUserTokenManagerImpl) signToken(claims jwt.Claims) (token string, err error) {
+	kid, method, key := impl.keyring.SigningKey()
 
-	tokenObj, err := jwt.ParseWithClaims(token, &claims, func(token *jwt.Token) (interface{}, error) {
-		return impl.tokenSecKey, nil
-	})
+	tokenObj := jwt.NewWithClaims(method, claims)
+	if kid != "" {
+		tokenObj.Header[jwtHeaderKeyID] = kid
+	}
+
+	token, err = tokenObj.SignedString(key)
+
+	return
+}
+
+func (impl *jwtUserTokenManagerImpl) verifyingKey(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header[jwtHeaderKeyID].(string)
+
+	method, key, err := impl.keyring.VerifyingKey(kid)
+	if err != nil {
+		return nil, err
+	}
+
+	if token.Method.Alg() != method.Alg() {
+		return nil, ErrAlgorithmDiff
+	}
+
+	return key, nil
+}
+
+// parseWithClaims checks the signature, then the standard claims with the configured issuer and leeway.
+func (impl *jwtUserTokenManagerImpl) parseWithClaims(token string, claims jwt.Claims, standardClaims *jwt.StandardClaims,
+	audience string) error {
+	parser := &jwt.Parser{
+		SkipClaimsValidation: true,
+	}
+
+	tokenObj, err := parser.ParseWithClaims(token, claims, impl.verifyingKey)
+	if err != nil {
+		return err
+	}
+
+	if !tokenObj.Valid {
+		return commerr.ErrUnauthenticated
+	}
+
+	return impl.verifyStandardClaims(standardClaims, audience)
+}
+
+func (impl *jwtUserTokenManagerImpl) verifyStandardClaims(claims *jwt.StandardClaims, audience string) error {
+	now := time.Now().Unix()
+	leeway := int64(impl.configs.ClockSkewLeeway / time.Second)
+
+	if !claims.VerifyExpiresAt(now-leeway, true) {
+		return ErrTokenExpired
+	}
+
+	if !claims.VerifyIssuedAt(now+leeway, false) || !claims.VerifyNotBefore(now+leeway, false) {
+		return ErrTokenNotValidYet
+	}
+
+	if impl.configs.Issuer != "" && !claims.VerifyIssuer(impl.configs.Issuer, true) {
+		return ErrTokenIssuer
+	}
+
+	if audience != "" && !claims.VerifyAudience(audience, true) {
+		return ErrTokenAudience
+	}
+
+	return nil
+}
+
+func (impl *jwtUserTokenManagerImpl) parseClaims(token string) (claims *UserClaims, err error) {
+	claims = &UserClaims{}
+
+	err = impl.parseWithClaims(token, claims, &claims.StandardClaims, impl.configs.Audience)
 	if err != nil {
 		return
 	}
 
-	if userClaims, ok := tokenObj.Claims.(*UserClaims); ok && tokenObj.Valid {
-		userInfo = &userClaims.UserTokenInfo
-		expireAt = userClaims.ExpiresAt
-	} else {
+	if claims.AuthTime != 0 {
+		claims.AuthAt = time.Unix(claims.AuthTime, 0)
+	}
+
+	return
+}
+
+func (impl *jwtUserTokenManagerImpl) parseToken(token string, use string) (claims *UserClaims, err error) {
+	claims, err = impl.parseClaims(token)
+	if err != nil {
+		return
+	}
+
+	if claims.Use != use {
 		err = commerr.ErrUnauthenticated
 	}
 
 	return
 }
 
-func (impl *jwtUserTokenManagerImpl) parseSSOToken(token string) (parentToken string, err error) {
-	var claims SSOClaims
+// parseSSOToken the token must be issued for audience, SSO tokens are never verified without one.
+func (impl *jwtUserTokenManagerImpl) parseSSOToken(token string, audience string) (claims *SSOClaims, err error) {
+	if audience == "" {
+		err = ErrTokenAudience
 
-	tokenObj, err := jwt.ParseWithClaims(token, &claims, func(token *jwt.Token) (interface{}, error) {
-		return impl.tokenSecKey, nil
-	})
+		return
+	}
+
+	claims = &SSOClaims{}
+
+	err = impl.parseWithClaims(token, claims, &claims.StandardClaims, audience)
 	if err != nil {
 		return
 	}
 
-	if c, ok := tokenObj.Claims.(*SSOClaims); ok && tokenObj.Valid {
-		parentToken = c.Token
-	} else {
+	if claims.Use != tokenUseSSO {
 		err = commerr.ErrUnauthenticated
 	}
 
 	return
 }
 
-func (impl *jwtUserTokenManagerImpl) deleteToken(ctx context.Context, token string, extendDuration time.Duration) {
-	_ = impl.storage.Record(ctx, token, extendDuration)
+// explainSSOParent liveness comes from the revocation entries only, the session registry is informational.
+func (impl *jwtUserTokenManagerImpl) explainSSOParent(ctx context.Context, claims *SSOClaims) (
+	userInfo *usertokenmanagerinters.UserTokenInfo, status bizuserinters.Status) {
+	status = impl.checkTokenDeleted(ctx, claims.ParentID, &UserClaims{
+		UserTokenInfo: claims.UserTokenInfo,
+		Generation:    claims.Generation,
+	})
+	if status.Code != bizuserinters.StatusCodeOk {
+		return
+	}
+
+	userInfo = &claims.UserTokenInfo
+
+	if claims.AuthTime != 0 {
+		userInfo.AuthAt = time.Unix(claims.AuthTime, 0)
+	}
+
+	status = bizuserinters.MakeSuccessStatus()
+
+	return
+}
+
+func (impl *jwtUserTokenManagerImpl) deleteToken(ctx context.Context, tokenID string, extendDuration time.Duration) {
+	_ = impl.storage.Record(ctx, storageKeyPrefixTokenRevoked+tokenID, extendDuration)
+}
+
+// revokeSession expireAt is when the last refresh token of the session expires.
+func (impl *jwtUserTokenManagerImpl) revokeSession(ctx context.Context, userID uint64, sessionID string, expireAt time.Time) {
+	_ = impl.storage.Record(ctx, storageKeyPrefixSessionRevoked+sessionID, remainDuration(expireAt.Unix()))
+	_ = impl.sessionStorage.DeleteSession(ctx, userID, sessionID)
 }
 
-func (impl *jwtUserTokenManagerImpl) hasTokenDeleted(ctx context.Context, token string) bool {
-	t, err := impl.storage.Exists(ctx, token)
+// checkTokenDeleted returns StatusCodeExpiredError for revoked tokens, storage errors are handled by the
+// configured StorageFailurePolicy.
+func (impl *jwtUserTokenManagerImpl) checkTokenDeleted(ctx context.Context, tokenID string, claims *UserClaims) bizuserinters.Status {
+	deleted, err := impl.hasTokenDeleted(ctx, tokenID, claims)
+	if err != nil {
+		return impl.onStorageFailure(err)
+	}
+
+	impl.storageAnsweredAt.Store(time.Now().UnixNano())
+
+	if deleted {
+		return bizuserinters.MakeStatusByCode(bizuserinters.StatusCodeExpiredError)
+	}
+
+	return bizuserinters.MakeSuccessStatus()
+}
+
+func (impl *jwtUserTokenManagerImpl) hasTokenDeleted(ctx context.Context, tokenID string, claims *UserClaims) (deleted bool, err error) {
+	keys := []string{storageKeyPrefixTokenRevoked + tokenID}
+	if claims != nil && claims.SessionID != "" {
+		keys = append(keys, storageKeyPrefixSessionRevoked+claims.SessionID)
+	}
+
+	deleted, err = impl.storage.Exists(ctx, keys...)
+	if err != nil || deleted || claims == nil {
+		return
+	}
+
+	generation, err := impl.storage.GetCounter(ctx, userGenerationKey(claims.ID))
+	if err != nil {
+		return
+	}
+
+	deleted = claims.Generation < generation
+
+	return
+}
+
+func (impl *jwtUserTokenManagerImpl) onStorageFailure(err error) bizuserinters.Status {
+	accept := false
+
+	switch impl.configs.StorageFailurePolicy {
+	case StorageFailurePolicyOpen:
+		accept = true
+	case StorageFailurePolicyGrace:
+		answeredAt := impl.storageAnsweredAt.Load()
+		accept = answeredAt != 0 && time.Since(time.Unix(0, answeredAt)) < impl.configs.StorageFailureGrace
+	}
+
+	logger := impl.logger.WithFields(l.ErrorField(err), l.StringField("policy", string(impl.configs.StorageFailurePolicy)))
+
+	if accept {
+		logger.WithFields(l.Int64Field("accepted", impl.storageFailureAccepted.Add(1))).Warn("revocation storage failed, token accepted")
+
+		return bizuserinters.MakeSuccessStatus()
+	}
+
+	logger.WithFields(l.Int64Field("rejected", impl.storageFailureRejected.Add(1))).Error("revocation storage failed, token rejected")
+
+	return bizuserinters.MakeStatusByError(bizuserinters.StatusCodeInternalError, ErrRevocationStorageUnavailable)
+}
+
+func remainDuration(expireAt int64) time.Duration {
+	d := time.Duration(expireAt-time.Now().Unix()) * time.Second
+	if d <= 0 {
+		d = time.Second
+	}
+
+	return d
+}
+
+// SessionAgeExceeded the session was authenticated more than maxSessionAge ago and must not be renewed.
+func SessionAgeExceeded(userInfo *usertokenmanagerinters.UserTokenInfo, maxSessionAge time.Duration) bool {
+	return maxSessionAge > 0 && !userInfo.AuthAt.IsZero() && !time.Now().Before(userInfo.AuthAt.Add(maxSessionAge))
+}
+
+// CapSessionExpiration shortens userInfo.Expiration so tokens issued from StartAt never outlive AuthAt plus maxSessionAge.
+func CapSessionExpiration(userInfo *usertokenmanagerinters.UserTokenInfo, maxSessionAge time.Duration) {
+	if maxSessionAge <= 0 {
+		return
+	}
+
+	remain := userInfo.AuthAt.Add(maxSessionAge).Sub(userInfo.StartAt)
+	if remain < time.Second {
+		remain = time.Second
+	}
+
+	if userInfo.Expiration > remain {
+		userInfo.Expiration = remain
+	}
+}
+
+// RecordSession the registry is informational, revocation does not depend on it, so failures are ignored.
+func RecordSession(ctx context.Context, sessionStorage usertokenmanagerinters.SessionStorage,
+	userInfo *usertokenmanagerinters.UserTokenInfo) {
+	now := time.Now()
+
+	session, _ := sessionStorage.GetSession(ctx, userInfo.ID, userInfo.SessionID)
+	if session == nil {
+		session = &usertokenmanagerinters.Session{
+			ID:        userInfo.SessionID,
+			UserID:    userInfo.ID,
+			CreatedAt: now,
+		}
+	}
+
+	session.LastUsedAt = now
+	session.ExpireAt = userInfo.StartAt.Add(userInfo.Expiration)
+	session.UserAgent, session.ClientIP = grpctoken.GetClientInfoFromGRPCContext(ctx)
+
+	_ = sessionStorage.SaveSession(ctx, session)
+}
+
+// ClearSessions drops the registry entries only, the tokens must have been revoked already.
+func ClearSessions(ctx context.Context, sessionStorage usertokenmanagerinters.SessionStorage, userID uint64) {
+	sessions, _ := sessionStorage.ListSessions(ctx, userID)
+
+	for _, session := range sessions {
+		_ = sessionStorage.DeleteSession(ctx, userID, session.ID)
+	}
+}
+
+func userGenerationKey(userID uint64) string {
+	return storageKeyPrefixUserGeneration + strconv.FormatUint(userID, 10)
+}
+
+// tokenID tokens issued without jti are identified by their hash, so the bearer token itself is never stored.
+func tokenID(token string, claims *jwt.StandardClaims) string {
+	if claims.Id != "" {
+		return claims.Id
+	}
+
+	h := sha256.Sum256([]byte(token))
 
-	return err == nil && t
+	return hex.EncodeToString(h[:])
 }
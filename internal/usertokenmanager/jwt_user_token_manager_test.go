@@ -0,0 +1,384 @@
+package usertokenmanager
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/s-min-sys/userbe/internal/usertokenmanager/usertokenmanagerinters"
+	"github.com/sbasestarter/bizuserlib/bizuserinters"
+)
+
+func newTestJWTUserTokenManager(t *testing.T, storage usertokenmanagerinters.JWTDataStorage) usertokenmanagerinters.UserTokenManager {
+	t.Helper()
+
+	keyring, err := NewJWTKeyring("k", []JWTKey{{ID: "k", Secret: "s"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return NewJWTUserTokenManager(keyring, storage, NewMemorySessionStorage(), JWTUserTokenManagerConfigs{}, nil)
+}
+
+func TestJWTRefreshToken(t *testing.T) {
+	ctx := context.Background()
+	m := newTestJWTUserTokenManager(t, NewMemoryJWTDataStorage())
+
+	authAt := time.Now().Add(-time.Hour).Truncate(time.Second)
+
+	token, status := m.GenToken(ctx, &usertokenmanagerinters.UserTokenInfo{
+		ID:          1,
+		UserName:    "u",
+		Admin:       true,
+		AuthMethods: []string{"userpass"},
+		AuthAt:      authAt,
+	})
+	if status.Code != bizuserinters.StatusCodeOk {
+		t.Fatal(status)
+	}
+
+	if _, _, status = m.RenewToken(ctx, token.AccessToken); status.Code == bizuserinters.StatusCodeOk {
+		t.Fatal("access token must not renew")
+	}
+
+	if _, status = m.ExplainToken(ctx, token.RefreshToken); status.Code == bizuserinters.StatusCodeOk {
+		t.Fatal("refresh token must not be accepted as access token")
+	}
+
+	newToken, userInfo, status := m.RenewToken(ctx, token.RefreshToken)
+	if status.Code != bizuserinters.StatusCodeOk || userInfo.ID != 1 {
+		t.Fatal(status)
+	}
+
+	if !userInfo.Admin || len(userInfo.AuthMethods) != 1 || !userInfo.AuthAt.Equal(authAt) {
+		t.Fatal("renewed token must keep the login's claims", userInfo)
+	}
+
+	if _, status = m.ExplainToken(ctx, newToken.AccessToken); status.Code != bizuserinters.StatusCodeOk {
+		t.Fatal(status)
+	}
+
+	// replay
+	if _, _, status = m.RenewToken(ctx, token.RefreshToken); status.Code != bizuserinters.StatusCodeExpiredError {
+		t.Fatal("replayed refresh token must fail", status)
+	}
+
+	if _, status = m.ExplainToken(ctx, newToken.AccessToken); status.Code != bizuserinters.StatusCodeExpiredError {
+		t.Fatal("replay must revoke the session", status)
+	}
+
+	if _, _, status = m.RenewToken(ctx, newToken.RefreshToken); status.Code != bizuserinters.StatusCodeExpiredError {
+		t.Fatal("replay must revoke the session", status)
+	}
+}
+
+func TestJWTDeleteTokenRevokesRefreshToken(t *testing.T) {
+	ctx := context.Background()
+	m := newTestJWTUserTokenManager(t, NewMemoryJWTDataStorage())
+
+	token, _ := m.GenToken(ctx, &usertokenmanagerinters.UserTokenInfo{ID: 1})
+
+	if status := m.DeleteToken(ctx, token.AccessToken); status.Code != bizuserinters.StatusCodeOk {
+		t.Fatal(status)
+	}
+
+	if _, _, status := m.RenewToken(ctx, token.RefreshToken); status.Code == bizuserinters.StatusCodeOk {
+		t.Fatal("logout must revoke the refresh token")
+	}
+}
+
+func TestJWTRevokeUserTokens(t *testing.T) {
+	ctx := context.Background()
+	m := newTestJWTUserTokenManager(t, NewMemoryJWTDataStorage())
+
+	token, _ := m.GenToken(ctx, &usertokenmanagerinters.UserTokenInfo{ID: 1})
+	otherToken, _ := m.GenToken(ctx, &usertokenmanagerinters.UserTokenInfo{ID: 2})
+
+	if status := m.RevokeUserTokens(ctx, 1); status.Code != bizuserinters.StatusCodeOk {
+		t.Fatal(status)
+	}
+
+	if _, status := m.ExplainToken(ctx, token.AccessToken); status.Code != bizuserinters.StatusCodeExpiredError {
+		t.Fatal("tokens of an older generation must be rejected", status)
+	}
+
+	if _, _, status := m.RenewToken(ctx, token.RefreshToken); status.Code != bizuserinters.StatusCodeExpiredError {
+		t.Fatal("tokens of an older generation must be rejected", status)
+	}
+
+	if _, status := m.ExplainToken(ctx, otherToken.AccessToken); status.Code != bizuserinters.StatusCodeOk {
+		t.Fatal("other users must not be affected", status)
+	}
+
+	newToken, _ := m.GenToken(ctx, &usertokenmanagerinters.UserTokenInfo{ID: 1})
+	if _, status := m.ExplainToken(ctx, newToken.AccessToken); status.Code != bizuserinters.StatusCodeOk {
+		t.Fatal(status)
+	}
+}
+
+type failingJWTDataStorage struct {
+	usertokenmanagerinters.JWTDataStorage
+	fail bool
+}
+
+func (storage *failingJWTDataStorage) Exists(ctx context.Context, keys ...string) (bool, error) {
+	if storage.fail {
+		return false, errors.New("storage down")
+	}
+
+	return storage.JWTDataStorage.Exists(ctx, keys...)
+}
+
+func TestJWTStorageFailurePolicy(t *testing.T) {
+	ctx := context.Background()
+
+	keyring, err := NewJWTKeyring("k", []JWTKey{{ID: "k", Secret: "s"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		policy StorageFailurePolicy
+		grace  time.Duration
+		code   bizuserinters.StatusCode
+	}{
+		{"", 0, bizuserinters.StatusCodeInternalError},
+		{StorageFailurePolicyClosed, 0, bizuserinters.StatusCodeInternalError},
+		{StorageFailurePolicyOpen, 0, bizuserinters.StatusCodeOk},
+		{StorageFailurePolicyGrace, time.Hour, bizuserinters.StatusCodeOk},
+		{StorageFailurePolicyGrace, time.Nanosecond, bizuserinters.StatusCodeInternalError},
+	}
+
+	for _, c := range cases {
+		storage := &failingJWTDataStorage{JWTDataStorage: NewMemoryJWTDataStorage()}
+
+		m := NewJWTUserTokenManager(keyring, storage, NewMemorySessionStorage(), JWTUserTokenManagerConfigs{
+			StorageFailurePolicy: c.policy,
+			StorageFailureGrace:  c.grace,
+		}, nil)
+
+		token, _ := m.GenToken(ctx, &usertokenmanagerinters.UserTokenInfo{ID: 1})
+
+		if _, status := m.ExplainToken(ctx, token.AccessToken); status.Code != bizuserinters.StatusCodeOk {
+			t.Fatal(c.policy, status)
+		}
+
+		storage.fail = true
+
+		if _, status := m.ExplainToken(ctx, token.AccessToken); status.Code != c.code {
+			t.Fatal(c.policy, c.grace, status)
+		}
+
+		stats := m.(StorageFailureStatsReporter).StorageFailureStats()
+		if (stats.Accepted == 1) != (c.code == bizuserinters.StatusCodeOk) {
+			t.Fatal(c.policy, "unexpected accepted count", stats.Accepted)
+		}
+
+		if (stats.Rejected == 1) != (c.code != bizuserinters.StatusCodeOk) {
+			t.Fatal(c.policy, "unexpected rejected count", stats.Rejected)
+		}
+	}
+
+	// the grace starts at the first successful read, a storage down since start rejects
+	storage := &failingJWTDataStorage{JWTDataStorage: NewMemoryJWTDataStorage(), fail: true}
+
+	m := NewJWTUserTokenManager(keyring, storage, NewMemorySessionStorage(), JWTUserTokenManagerConfigs{
+		StorageFailurePolicy: StorageFailurePolicyGrace,
+		StorageFailureGrace:  time.Hour,
+	}, nil)
+
+	token, _ := m.GenToken(ctx, &usertokenmanagerinters.UserTokenInfo{ID: 1})
+
+	if _, status := m.ExplainToken(ctx, token.AccessToken); status.Code != bizuserinters.StatusCodeInternalError {
+		t.Fatal("grace without a successful read", status)
+	}
+
+	if NewJWTUserTokenManager(keyring, NewMemoryJWTDataStorage(), NewMemorySessionStorage(), JWTUserTokenManagerConfigs{
+		StorageFailurePolicy: "x",
+	}, nil) != nil {
+		t.Fatal("unknown policy must be refused")
+	}
+}
+
+func TestJWTIssuerAudience(t *testing.T) {
+	ctx := context.Background()
+
+	keyring, err := NewJWTKeyring("k", []JWTKey{{ID: "k", Secret: "s"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	staging := NewJWTUserTokenManager(keyring, NewMemoryJWTDataStorage(), NewMemorySessionStorage(), JWTUserTokenManagerConfigs{
+		Issuer:   "staging",
+		Audience: "app",
+	}, nil)
+	production := NewJWTUserTokenManager(keyring, NewMemoryJWTDataStorage(), NewMemorySessionStorage(), JWTUserTokenManagerConfigs{
+		Issuer:   "production",
+		Audience: "app",
+	}, nil)
+
+	token, _ := staging.GenToken(ctx, &usertokenmanagerinters.UserTokenInfo{ID: 1})
+
+	if _, status := staging.ExplainToken(ctx, token.AccessToken); status.Code != bizuserinters.StatusCodeOk {
+		t.Fatal(status)
+	}
+
+	if _, status := production.ExplainToken(ctx, token.AccessToken); status.Code == bizuserinters.StatusCodeOk {
+		t.Fatal("token of another issuer must be rejected")
+	}
+}
+
+func TestJWTForeignToken(t *testing.T) {
+	ctx := context.Background()
+
+	keyring, err := NewJWTKeyring("k", []JWTKey{{ID: "k", Secret: "s"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := NewJWTUserTokenManager(keyring, NewMemoryJWTDataStorage(), NewMemorySessionStorage(), JWTUserTokenManagerConfigs{}, nil)
+
+	kid, method, key := keyring.SigningKey()
+
+	// an id token signed by the same keyring has no use
+	idToken := jwt.NewWithClaims(method, &UserClaims{
+		UserTokenInfo: usertokenmanagerinters.UserTokenInfo{ID: 1},
+		StandardClaims: jwt.StandardClaims{
+			ExpiresAt: time.Now().Add(time.Hour).Unix(),
+		},
+	})
+	idToken.Header[jwtHeaderKeyID] = kid
+
+	token, err := idToken.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, status := m.ExplainToken(ctx, token); status.Code == bizuserinters.StatusCodeOk {
+		t.Fatal("token without use must be rejected")
+	}
+}
+
+func TestJWTSSOToken(t *testing.T) {
+	ctx := context.Background()
+	m := newTestJWTUserTokenManager(t, NewMemoryJWTDataStorage())
+
+	token, _ := m.GenToken(ctx, &usertokenmanagerinters.UserTokenInfo{ID: 1})
+
+	ssoToken, status := m.GenSSOToken(ctx, token.AccessToken, &usertokenmanagerinters.SSOTokenOptions{
+		Audience: "site-b",
+		ClientID: "client-b",
+		Scopes:   []string{"profile"},
+	})
+	if status.Code != bizuserinters.StatusCodeOk {
+		t.Fatal(status)
+	}
+
+	if _, status = m.ExplainToken(ctx, ssoToken); status.Code == bizuserinters.StatusCodeOk {
+		t.Fatal("sso token must not be accepted as access token")
+	}
+
+	if _, status = m.ExplainSSOToken(ctx, ssoToken, "site-c", "client-b"); status.Code == bizuserinters.StatusCodeOk {
+		t.Fatal("sso token must be bound to its audience")
+	}
+
+	if _, status = m.ExplainSSOToken(ctx, ssoToken, "site-b", "client-c"); status.Code == bizuserinters.StatusCodeOk {
+		t.Fatal("sso token must be bound to its client")
+	}
+
+	info, status := m.ExplainSSOToken(ctx, ssoToken, "site-b", "client-b")
+	if status.Code != bizuserinters.StatusCodeOk || info.UserTokenInfo.ID != 1 || len(info.Scopes) != 1 {
+		t.Fatal(status, info)
+	}
+
+	if _, status = m.ExplainSSOToken(ctx, ssoToken, "site-b", "client-b"); status.Code != bizuserinters.StatusCodeExpiredError {
+		t.Fatal("sso token must be used once", status)
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(strings.Split(ssoToken, ".")[1])
+	if err != nil || strings.Contains(string(payload), token.AccessToken) {
+		t.Fatal("sso token must not carry the parent token", err)
+	}
+
+	ssoToken, _ = m.GenSSOToken(ctx, token.AccessToken, &usertokenmanagerinters.SSOTokenOptions{
+		Audience: "site-b",
+		ClientID: "client-b",
+	})
+
+	userInfo, _ := m.ExplainToken(ctx, token.AccessToken)
+	m.RevokeSession(ctx, 1, userInfo.SessionID)
+
+	if _, status = m.ExplainSSOToken(ctx, ssoToken, "site-b", "client-b"); status.Code == bizuserinters.StatusCodeOk {
+		t.Fatal("sso token of a revoked session must be rejected")
+	}
+}
+
+func TestJWTStepUpToken(t *testing.T) {
+	ctx := context.Background()
+	m := newTestJWTUserTokenManager(t, NewMemoryJWTDataStorage())
+
+	token, _ := m.GenToken(ctx, &usertokenmanagerinters.UserTokenInfo{ID: 1})
+	userInfo, _ := m.ExplainToken(ctx, token.AccessToken)
+
+	stepUpToken, status := m.GenStepUpToken(ctx, userInfo, time.Minute)
+	if status.Code != bizuserinters.StatusCodeOk {
+		t.Fatal(status)
+	}
+
+	if _, status = m.ExplainToken(ctx, stepUpToken); status.Code == bizuserinters.StatusCodeOk {
+		t.Fatal("step-up token must not be accepted as access token")
+	}
+
+	if _, _, status = m.RenewToken(ctx, stepUpToken); status.Code == bizuserinters.StatusCodeOk {
+		t.Fatal("step-up token must not renew")
+	}
+
+	if _, status = m.ExplainStepUpToken(ctx, token.AccessToken); status.Code == bizuserinters.StatusCodeOk {
+		t.Fatal("access token must not be accepted as step-up token")
+	}
+
+	stepUpTokenInfo, status := m.ExplainStepUpToken(ctx, stepUpToken)
+	if status.Code != bizuserinters.StatusCodeOk || stepUpTokenInfo.SessionID != userInfo.SessionID {
+		t.Fatal(status, stepUpTokenInfo)
+	}
+
+	m.RevokeSession(ctx, 1, userInfo.SessionID)
+
+	if _, status = m.ExplainStepUpToken(ctx, stepUpToken); status.Code == bizuserinters.StatusCodeOk {
+		t.Fatal("step-up token of a revoked session must be rejected")
+	}
+}
+
+func TestJWTMaxSessionAge(t *testing.T) {
+	ctx := context.Background()
+
+	keyring, err := NewJWTKeyring("k", []JWTKey{{ID: "k", Secret: "s"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := NewJWTUserTokenManager(keyring, NewMemoryJWTDataStorage(), NewMemorySessionStorage(),
+		JWTUserTokenManagerConfigs{MaxSessionAge: time.Hour * 2}, nil)
+
+	token, _ := m.GenToken(ctx, &usertokenmanagerinters.UserTokenInfo{
+		ID:         1,
+		Expiration: time.Hour * 24,
+		AuthAt:     time.Now().Add(-time.Minute * 90),
+	})
+	if token.RefreshExpiration > time.Minute*30 {
+		t.Fatal("refresh token must not outlive the max session age", token.RefreshExpiration)
+	}
+
+	token, _ = m.GenToken(ctx, &usertokenmanagerinters.UserTokenInfo{
+		ID:         1,
+		Expiration: time.Hour * 24,
+		AuthAt:     time.Now().Add(-time.Hour * 3),
+	})
+	if _, _, status := m.RenewToken(ctx, token.RefreshToken); status.Code != bizuserinters.StatusCodeExpiredError {
+		t.Fatal("session older than the max session age must not renew", status)
+	}
+}
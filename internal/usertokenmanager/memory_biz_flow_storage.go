@@ -0,0 +1,87 @@
+package usertokenmanager
+
+import (
+	"context"
+	"sync"
+
+	"github.com/patrickmn/go-cache"
+	"github.com/s-min-sys/userbe/internal/usertokenmanager/usertokenmanagerinters"
+)
+
+// NewMemoryBizFlowStorage for a single instance, flows are lost on restart.
+func NewMemoryBizFlowStorage() usertokenmanagerinters.BizFlowStorage {
+	return &bizFlowStorageImpl{
+		flows: cache.New(usertokenmanagerinters.BizFlowExpiration, usertokenmanagerinters.BizFlowExpiration),
+	}
+}
+
+type bizFlowStorageImpl struct {
+	lock  sync.Mutex
+	flows *cache.Cache
+}
+
+func (impl *bizFlowStorageImpl) AddAuthMethod(ctx context.Context, bizID, authMethod string) error {
+	impl.lock.Lock()
+	defer impl.lock.Unlock()
+
+	flow := impl.getFlow(bizID)
+
+	for _, m := range flow.AuthMethods {
+		if m == authMethod {
+			return nil
+		}
+	}
+
+	flow.AuthMethods = append(flow.AuthMethods, authMethod)
+
+	impl.flows.SetDefault(bizID, flow)
+
+	return nil
+}
+
+func (impl *bizFlowStorageImpl) SetUserID(ctx context.Context, bizID string, userID uint64) error {
+	impl.lock.Lock()
+	defer impl.lock.Unlock()
+
+	flow := impl.getFlow(bizID)
+	flow.UserID = userID
+
+	impl.flows.SetDefault(bizID, flow)
+
+	return nil
+}
+
+func (impl *bizFlowStorageImpl) GetFlow(ctx context.Context, bizID string) (*usertokenmanagerinters.BizFlow, error) {
+	impl.lock.Lock()
+	defer impl.lock.Unlock()
+
+	if _, ok := impl.flows.Get(bizID); !ok {
+		return nil, nil
+	}
+
+	return impl.getFlow(bizID), nil
+}
+
+func (impl *bizFlowStorageImpl) DeleteFlow(ctx context.Context, bizID string) error {
+	impl.flows.Delete(bizID)
+
+	return nil
+}
+
+//
+//
+//
+
+// getFlow returns a copy, an unknown flow is empty.
+func (impl *bizFlowStorageImpl) getFlow(bizID string) *usertokenmanagerinters.BizFlow {
+	flow := &usertokenmanagerinters.BizFlow{}
+
+	if i, ok := impl.flows.Get(bizID); ok {
+		if stored, ok := i.(*usertokenmanagerinters.BizFlow); ok {
+			flow.UserID = stored.UserID
+			flow.AuthMethods = append(flow.AuthMethods, stored.AuthMethods...)
+		}
+	}
+
+	return flow
+}
@@ -24,8 +24,36 @@ func (impl *jwtDataStorageImpl) Record(ctx context.Context, key string, expirati
 	return nil
 }
 
-func (impl *jwtDataStorageImpl) Exists(ctx context.Context, key string) (t bool, err error) {
-	_, t = impl.d.Get(key)
+func (impl *jwtDataStorageImpl) RecordIfNotExists(ctx context.Context, key string, expiration time.Duration) (recorded bool, err error) {
+	recorded = impl.d.Add(key, time.Now(), expiration) == nil
 
 	return
 }
+
+func (impl *jwtDataStorageImpl) Exists(ctx context.Context, keys ...string) (t bool, err error) {
+	for _, key := range keys {
+		if _, t = impl.d.Get(key); t {
+			return
+		}
+	}
+
+	return
+}
+
+func (impl *jwtDataStorageImpl) GetCounter(ctx context.Context, key string) (n int64, err error) {
+	if i, ok := impl.d.Get(key); ok {
+		n, _ = i.(int64)
+	}
+
+	return
+}
+
+func (impl *jwtDataStorageImpl) IncCounter(ctx context.Context, key string) (n int64, err error) {
+	if impl.d.Add(key, int64(1), cache.NoExpiration) == nil {
+		n = 1
+
+		return
+	}
+
+	return impl.d.IncrementInt64(key, 1)
+}
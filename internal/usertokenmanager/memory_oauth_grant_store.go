@@ -0,0 +1,73 @@
+package usertokenmanager
+
+import (
+	"context"
+	"sync"
+
+	"github.com/s-min-sys/userbe/internal/usertokenmanager/usertokenmanagerinters"
+)
+
+func NewMemoryOAuthGrantStore() usertokenmanagerinters.OAuthGrantStore {
+	return &oAuthGrantStoreImpl{
+		grants: make(map[uint64]map[string]usertokenmanagerinters.OAuthGrant),
+	}
+}
+
+type oAuthGrantStoreImpl struct {
+	lock   sync.Mutex
+	grants map[uint64]map[string]usertokenmanagerinters.OAuthGrant
+}
+
+func (impl *oAuthGrantStoreImpl) SaveGrant(ctx context.Context, grant *usertokenmanagerinters.OAuthGrant) error {
+	impl.lock.Lock()
+	defer impl.lock.Unlock()
+
+	userGrants, ok := impl.grants[grant.UserID]
+	if !ok {
+		userGrants = make(map[string]usertokenmanagerinters.OAuthGrant)
+		impl.grants[grant.UserID] = userGrants
+	}
+
+	userGrants[grant.ClientID] = *grant
+
+	return nil
+}
+
+func (impl *oAuthGrantStoreImpl) GetGrant(ctx context.Context, userID uint64, clientID string) (*usertokenmanagerinters.OAuthGrant, error) {
+	impl.lock.Lock()
+	defer impl.lock.Unlock()
+
+	grant, ok := impl.grants[userID][clientID]
+	if !ok {
+		return nil, nil
+	}
+
+	return &grant, nil
+}
+
+func (impl *oAuthGrantStoreImpl) ListGrants(ctx context.Context, userID uint64) ([]*usertokenmanagerinters.OAuthGrant, error) {
+	impl.lock.Lock()
+	defer impl.lock.Unlock()
+
+	grants := make([]*usertokenmanagerinters.OAuthGrant, 0, len(impl.grants[userID]))
+
+	for _, grant := range impl.grants[userID] {
+		grant := grant
+		grants = append(grants, &grant)
+	}
+
+	return grants, nil
+}
+
+func (impl *oAuthGrantStoreImpl) DeleteGrant(ctx context.Context, userID uint64, clientID string) error {
+	impl.lock.Lock()
+	defer impl.lock.Unlock()
+
+	delete(impl.grants[userID], clientID)
+
+	if len(impl.grants[userID]) == 0 {
+		delete(impl.grants, userID)
+	}
+
+	return nil
+}
@@ -0,0 +1,116 @@
+package usertokenmanager
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/go-oauth2/oauth2/v4"
+	"github.com/patrickmn/go-cache"
+	"github.com/s-min-sys/userbe/internal/usertokenmanager/usertokenmanagerinters"
+)
+
+const (
+	memoryKeyPrefixOAuthCode    = "oc:"
+	memoryKeyPrefixOAuthAccess  = "oa:"
+	memoryKeyPrefixOAuthRefresh = "or:"
+)
+
+// NewMemoryOAuthTokenStore unlike the go-oauth2 memory store it keeps the stored TokenInfo as it is, so the
+// OpenID Connect login of a usertokenmanagerinters.OAuthTokenInfo survives.
+func NewMemoryOAuthTokenStore() usertokenmanagerinters.OAuthTokenStore {
+	return &memoryOAuthTokenStoreImpl{
+		dataCache: cache.New(time.Hour, time.Hour),
+	}
+}
+
+type memoryOAuthTokenStoreImpl struct {
+	dataCache *cache.Cache
+}
+
+func (impl *memoryOAuthTokenStoreImpl) Create(ctx context.Context, info oauth2.TokenInfo) error {
+	if code := info.GetCode(); code != "" {
+		impl.dataCache.Set(memoryKeyPrefixOAuthCode+code, info, info.GetCodeExpiresIn())
+
+		return nil
+	}
+
+	accessExpiration := info.GetAccessExpiresIn()
+
+	if refresh := info.GetRefresh(); refresh != "" {
+		// zero keeps the refresh token forever
+		refreshExpiration := cache.NoExpiration
+
+		if info.GetRefreshExpiresIn() != 0 {
+			refreshExpiration = remainDuration(info.GetRefreshCreateAt().Add(info.GetRefreshExpiresIn()).Unix())
+
+			if accessExpiration > refreshExpiration {
+				accessExpiration = refreshExpiration
+			}
+		}
+
+		impl.dataCache.Set(memoryKeyPrefixOAuthRefresh+refresh, info, refreshExpiration)
+	}
+
+	impl.dataCache.Set(memoryKeyPrefixOAuthAccess+info.GetAccess(), info, accessExpiration)
+
+	return nil
+}
+
+func (impl *memoryOAuthTokenStoreImpl) RemoveByCode(ctx context.Context, code string) error {
+	impl.dataCache.Delete(memoryKeyPrefixOAuthCode + code)
+
+	return nil
+}
+
+func (impl *memoryOAuthTokenStoreImpl) RemoveByAccess(ctx context.Context, access string) error {
+	impl.dataCache.Delete(memoryKeyPrefixOAuthAccess + access)
+
+	return nil
+}
+
+func (impl *memoryOAuthTokenStoreImpl) RemoveByRefresh(ctx context.Context, refresh string) error {
+	impl.dataCache.Delete(memoryKeyPrefixOAuthRefresh + refresh)
+
+	return nil
+}
+
+func (impl *memoryOAuthTokenStoreImpl) RemoveByUserClient(ctx context.Context, userID uint64, clientID string) error {
+	uid := strconv.FormatUint(userID, 10)
+
+	for key, item := range impl.dataCache.Items() {
+		if info, ok := item.Object.(oauth2.TokenInfo); ok && info.GetUserID() == uid && info.GetClientID() == clientID {
+			impl.dataCache.Delete(key)
+		}
+	}
+
+	return nil
+}
+
+func (impl *memoryOAuthTokenStoreImpl) GetByCode(ctx context.Context, code string) (oauth2.TokenInfo, error) {
+	return impl.get(memoryKeyPrefixOAuthCode + code), nil
+}
+
+func (impl *memoryOAuthTokenStoreImpl) GetByAccess(ctx context.Context, access string) (oauth2.TokenInfo, error) {
+	return impl.get(memoryKeyPrefixOAuthAccess + access), nil
+}
+
+func (impl *memoryOAuthTokenStoreImpl) GetByRefresh(ctx context.Context, refresh string) (oauth2.TokenInfo, error) {
+	return impl.get(memoryKeyPrefixOAuthRefresh + refresh), nil
+}
+
+//
+//
+//
+
+// get a missing token is not an error, go-oauth2 expects nil info then.
+func (impl *memoryOAuthTokenStoreImpl) get(key string) oauth2.TokenInfo {
+	i, ok := impl.dataCache.Get(key)
+	if !ok {
+		return nil
+	}
+
+	info, _ := i.(oauth2.TokenInfo)
+
+	return info
+}
@@ -0,0 +1,83 @@
+package usertokenmanager
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/s-min-sys/userbe/internal/usertokenmanager/usertokenmanagerinters"
+)
+
+func NewMemorySessionStorage() usertokenmanagerinters.SessionStorage {
+	return &sessionStorageImpl{
+		sessions: make(map[uint64]map[string]usertokenmanagerinters.Session),
+	}
+}
+
+type sessionStorageImpl struct {
+	lock     sync.Mutex
+	sessions map[uint64]map[string]usertokenmanagerinters.Session
+}
+
+func (impl *sessionStorageImpl) SaveSession(ctx context.Context, session *usertokenmanagerinters.Session) error {
+	impl.lock.Lock()
+	defer impl.lock.Unlock()
+
+	userSessions, ok := impl.sessions[session.UserID]
+	if !ok {
+		userSessions = make(map[string]usertokenmanagerinters.Session)
+		impl.sessions[session.UserID] = userSessions
+	}
+
+	userSessions[session.ID] = *session
+
+	return nil
+}
+
+func (impl *sessionStorageImpl) GetSession(ctx context.Context, userID uint64, sessionID string) (*usertokenmanagerinters.Session, error) {
+	impl.lock.Lock()
+	defer impl.lock.Unlock()
+
+	session, ok := impl.sessions[userID][sessionID]
+	if !ok || session.ExpireAt.Before(time.Now()) {
+		return nil, nil
+	}
+
+	return &session, nil
+}
+
+func (impl *sessionStorageImpl) ListSessions(ctx context.Context, userID uint64) ([]*usertokenmanagerinters.Session, error) {
+	impl.lock.Lock()
+	defer impl.lock.Unlock()
+
+	userSessions := impl.sessions[userID]
+	sessions := make([]*usertokenmanagerinters.Session, 0, len(userSessions))
+
+	now := time.Now()
+
+	for id, session := range userSessions {
+		if session.ExpireAt.Before(now) {
+			delete(userSessions, id)
+
+			continue
+		}
+
+		session := session
+		sessions = append(sessions, &session)
+	}
+
+	return sessions, nil
+}
+
+func (impl *sessionStorageImpl) DeleteSession(ctx context.Context, userID uint64, sessionID string) error {
+	impl.lock.Lock()
+	defer impl.lock.Unlock()
+
+	delete(impl.sessions[userID], sessionID)
+
+	if len(impl.sessions[userID]) == 0 {
+		delete(impl.sessions, userID)
+	}
+
+	return nil
+}
@@ -8,38 +8,50 @@ import (
 	"github.com/s-min-sys/userbe/internal/usertokenmanager/usertokenmanagerinters"
 	uuid "github.com/satori/go.uuid"
 	"github.com/sbasestarter/bizuserlib/bizuserinters"
-	"github.com/spf13/cast"
 )
 
 func NewMemoryUserTokenManager() usertokenmanagerinters.UserTokenManager {
 	return &memoryUserTokenManagerImpl{
-		dataCache: cache.New(time.Hour, time.Hour),
+		dataCache:      cache.New(time.Hour, time.Hour),
+		sessionStorage: NewMemorySessionStorage(),
 	}
 }
 
 type memoryUserTokenManagerImpl struct {
-	dataCache *cache.Cache
+	dataCache      *cache.Cache
+	sessionStorage usertokenmanagerinters.SessionStorage
 }
 
-func (impl *memoryUserTokenManagerImpl) GenToken(_ context.Context, ui *usertokenmanagerinters.UserTokenInfo) (token string, status bizuserinters.Status) {
-	token = uuid.NewV4().String()
-
-	userInfo := *ui
+type memoryTokenEntry struct {
+	userInfo   usertokenmanagerinters.UserTokenInfo
+	refresh    bool
+	generation int64
+}
 
-	userInfo.StartAt = time.Now()
+// memorySSOTokenEntry parent is the entry of the parent access token, its session is checked on exchange.
+type memorySSOTokenEntry struct {
+	parent  memoryTokenEntry
+	options usertokenmanagerinters.SSOTokenOptions
+}
 
-	if userInfo.Expiration <= 0 {
-		userInfo.Expiration = cache.NoExpiration
-	}
+// memoryStepUpTokenEntry a type of its own so step-up tokens are never taken for access tokens.
+type memoryStepUpTokenEntry struct {
+	entry memoryTokenEntry
+}
 
-	impl.dataCache.Set(token, &userInfo, userInfo.Expiration)
+func (impl *memoryUserTokenManagerImpl) GenToken(ctx context.Context, ui *usertokenmanagerinters.UserTokenInfo) (token *usertokenmanagerinters.UserToken, status bizuserinters.Status) {
+	token = impl.generateTokens(ctx, *ui, uuid.NewV4().String())
 
 	status.Code = bizuserinters.StatusCodeOk
 
 	return
 }
 
-func (impl *memoryUserTokenManagerImpl) DeleteToken(_ context.Context, token string) (status bizuserinters.Status) {
+func (impl *memoryUserTokenManagerImpl) DeleteToken(ctx context.Context, token string) (status bizuserinters.Status) {
+	if entry, ok := impl.getEntry(token); ok {
+		impl.revokeSession(ctx, entry.userInfo.ID, entry.userInfo.SessionID, entry.userInfo.StartAt.Add(entry.userInfo.Expiration))
+	}
+
 	impl.dataCache.Delete(token)
 
 	status.Code = bizuserinters.StatusCodeOk
@@ -48,21 +60,59 @@ func (impl *memoryUserTokenManagerImpl) DeleteToken(_ context.Context, token str
 }
 
 func (impl *memoryUserTokenManagerImpl) ExplainToken(ctx context.Context, token string) (userInfo *usertokenmanagerinters.UserTokenInfo, status bizuserinters.Status) {
-	i, ok := impl.dataCache.Get(token)
-	if !ok {
+	entry, ok := impl.getEntry(token)
+	if !ok || entry.refresh {
 		status.Code = bizuserinters.StatusCodeNoDataError
 
 		return
 	}
 
-	ui, ok := i.(*usertokenmanagerinters.UserTokenInfo)
+	if impl.isEntryRevoked(entry) {
+		status.Code = bizuserinters.StatusCodeExpiredError
+
+		return
+	}
+
+	userInfoObj := entry.userInfo
+	userInfo = &userInfoObj
+
+	status.Code = bizuserinters.StatusCodeOk
+
+	return
+}
+
+func (impl *memoryUserTokenManagerImpl) RenewToken(ctx context.Context, refreshToken string) (newToken *usertokenmanagerinters.UserToken, userInfo *usertokenmanagerinters.UserTokenInfo, status bizuserinters.Status) {
+	i, expireAt, ok := impl.dataCache.GetWithExpiration(refreshToken)
 	if !ok {
-		status.Code = bizuserinters.StatusCodeInternalError
+		status.Code = bizuserinters.StatusCodeNoDataError
 
 		return
 	}
 
-	userInfoObj := *ui
+	entry, ok := i.(*memoryTokenEntry)
+	if !ok || !entry.refresh {
+		status.Code = bizuserinters.StatusCodeNoDataError
+
+		return
+	}
+
+	if impl.isEntryRevoked(entry) {
+		status.Code = bizuserinters.StatusCodeExpiredError
+
+		return
+	}
+
+	if impl.dataCache.Add(storageKeyPrefixRefreshTokenUsed+refreshToken, time.Now(), remainDuration(expireAt.Unix())) != nil {
+		impl.revokeSession(ctx, entry.userInfo.ID, entry.userInfo.SessionID, entry.userInfo.StartAt.Add(entry.userInfo.Expiration))
+
+		status.Code = bizuserinters.StatusCodeExpiredError
+
+		return
+	}
+
+	newToken = impl.generateTokens(ctx, entry.userInfo, entry.userInfo.SessionID)
+
+	userInfoObj := entry.userInfo
 	userInfo = &userInfoObj
 
 	status.Code = bizuserinters.StatusCodeOk
@@ -70,40 +120,117 @@ func (impl *memoryUserTokenManagerImpl) ExplainToken(ctx context.Context, token
 	return
 }
 
-func (impl *memoryUserTokenManagerImpl) RenewToken(ctx context.Context, token string) (newToken string, userInfo *usertokenmanagerinters.UserTokenInfo, status bizuserinters.Status) {
-	userInfo, status = impl.ExplainToken(ctx, token)
-	if status.Code != bizuserinters.StatusCodeOk {
+func (impl *memoryUserTokenManagerImpl) GenSSOToken(ctx context.Context, parentToken string,
+	options *usertokenmanagerinters.SSOTokenOptions) (token string, status bizuserinters.Status) {
+	if options == nil || options.Audience == "" || options.ClientID == "" {
+		status.Code = bizuserinters.StatusCodeInvalidArgsError
+
 		return
 	}
 
-	newToken = uuid.NewV4().String()
+	parent, ok := impl.getEntry(parentToken)
+	if !ok || parent.refresh {
+		status.Code = bizuserinters.StatusCodeNoDataError
 
-	userInfo.StartAt = time.Now()
+		return
+	}
+
+	if impl.isEntryRevoked(parent) {
+		status.Code = bizuserinters.StatusCodeExpiredError
+
+		return
+	}
+
+	expiration := options.Expiration
+	if expiration <= 0 {
+		expiration = defaultSSOTokenExpiration
+	}
+
+	token = uuid.NewV4().String()
+
+	impl.dataCache.Set(token, &memorySSOTokenEntry{
+		parent:  *parent,
+		options: *options,
+	}, expiration)
+
+	status.Code = bizuserinters.StatusCodeOk
+
+	return
+}
+
+func (impl *memoryUserTokenManagerImpl) ExplainSSOToken(ctx context.Context, token, audience, clientID string) (
+	ssoTokenInfo *usertokenmanagerinters.SSOTokenInfo, status bizuserinters.Status) {
+	i, expireAt, ok := impl.dataCache.GetWithExpiration(token)
+	if !ok {
+		status.Code = bizuserinters.StatusCodeNoDataError
+
+		return
+	}
+
+	entry, ok := i.(*memorySSOTokenEntry)
+	if !ok {
+		status.Code = bizuserinters.StatusCodeNoDataError
+
+		return
+	}
+
+	if entry.options.Audience != audience || entry.options.ClientID != clientID {
+		status.Code = bizuserinters.StatusCodePermissionError
+
+		return
+	}
+
+	if impl.dataCache.Add(storageKeyPrefixSSOTokenUsed+token, time.Now(), remainDuration(expireAt.Unix())) != nil {
+		status.Code = bizuserinters.StatusCodeExpiredError
+
+		return
+	}
+
+	// the session registry is informational, the revocation entries decide
+	if impl.isEntryRevoked(&entry.parent) {
+		status.Code = bizuserinters.StatusCodeExpiredError
+
+		return
+	}
+
+	userInfo := entry.parent.userInfo
 
-	impl.dataCache.Set(newToken, userInfo, userInfo.Expiration)
-	impl.dataCache.Set(token, userInfo, time.Minute)
+	ssoTokenInfo = &usertokenmanagerinters.SSOTokenInfo{
+		UserTokenInfo: &userInfo,
+		Audience:      entry.options.Audience,
+		ClientID:      entry.options.ClientID,
+		Scopes:        entry.options.Scopes,
+	}
 
 	status.Code = bizuserinters.StatusCodeOk
 
 	return
 }
 
-func (impl *memoryUserTokenManagerImpl) GenSSOToken(ctx context.Context, parentToken string, expiration time.Duration) (token string, status bizuserinters.Status) {
-	_, status = impl.ExplainToken(ctx, parentToken)
-	if status.Code != bizuserinters.StatusCodeOk {
+func (impl *memoryUserTokenManagerImpl) GenStepUpToken(ctx context.Context, userInfo *usertokenmanagerinters.UserTokenInfo,
+	expiration time.Duration) (token string, status bizuserinters.Status) {
+	if userInfo == nil || userInfo.ID == 0 || userInfo.SessionID == "" || expiration <= 0 {
+		status.Code = bizuserinters.StatusCodeInvalidArgsError
+
 		return
 	}
 
 	token = uuid.NewV4().String()
 
-	impl.dataCache.Set(token, parentToken, expiration)
+	impl.dataCache.Set(token, &memoryStepUpTokenEntry{
+		entry: memoryTokenEntry{
+			userInfo:   *userInfo,
+			generation: impl.userGeneration(userInfo.ID),
+		},
+	}, expiration)
 
 	status.Code = bizuserinters.StatusCodeOk
 
 	return
 }
 
-func (impl *memoryUserTokenManagerImpl) ExplainSSOToken(ctx context.Context, token string) (userInfo *usertokenmanagerinters.UserTokenInfo, status bizuserinters.Status) {
+func (impl *memoryUserTokenManagerImpl) ExplainStepUpToken(ctx context.Context, token string) (
+	userInfo *usertokenmanagerinters.UserTokenInfo, status bizuserinters.Status) {
 	i, ok := impl.dataCache.Get(token)
 	if !ok {
 		status.Code = bizuserinters.StatusCodeNoDataError
@@ -111,14 +238,153 @@ func (impl *memoryUserTokenManagerImpl) ExplainSSOToken(ctx context.Context, tok
 		return
 	}
 
-	parentToken, err := cast.ToStringE(i)
-	if err != nil {
-		status = bizuserinters.MakeStatusByError(bizuserinters.StatusCodeInvalidArgsError, err)
+	stepUpEntry, ok := i.(*memoryStepUpTokenEntry)
+	if !ok {
+		status.Code = bizuserinters.StatusCodeNoDataError
+
+		return
+	}
+
+	if impl.isEntryRevoked(&stepUpEntry.entry) {
+		status.Code = bizuserinters.StatusCodeExpiredError
+
+		return
+	}
+
+	userInfoObj := stepUpEntry.entry.userInfo
+	userInfo = &userInfoObj
+
+	status.Code = bizuserinters.StatusCodeOk
+
+	return
+}
+
+func (impl *memoryUserTokenManagerImpl) ListSessions(ctx context.Context, userID uint64) (sessions []*usertokenmanagerinters.Session, status bizuserinters.Status) {
+	sessions, _ = impl.sessionStorage.ListSessions(ctx, userID)
+
+	status.Code = bizuserinters.StatusCodeOk
+
+	return
+}
+
+func (impl *memoryUserTokenManagerImpl) RevokeSession(ctx context.Context, userID uint64, sessionID string) (status bizuserinters.Status) {
+	session, _ := impl.sessionStorage.GetSession(ctx, userID, sessionID)
+	if session == nil {
+		status.Code = bizuserinters.StatusCodeNoDataError
 
 		return
 	}
 
-	userInfo, status = impl.ExplainToken(ctx, parentToken)
+	impl.revokeSession(ctx, userID, sessionID, session.ExpireAt)
+
+	status.Code = bizuserinters.StatusCodeOk
+
+	return
+}
+
+func (impl *memoryUserTokenManagerImpl) RevokeSessions(ctx context.Context, userID uint64, exceptSessionID string) (status bizuserinters.Status) {
+	sessions, _ := impl.sessionStorage.ListSessions(ctx, userID)
+
+	for _, session := range sessions {
+		if session.ID != exceptSessionID {
+			impl.revokeSession(ctx, userID, session.ID, session.ExpireAt)
+		}
+	}
+
+	status.Code = bizuserinters.StatusCodeOk
+
+	return
+}
+
+func (impl *memoryUserTokenManagerImpl) RevokeUserTokens(ctx context.Context, userID uint64) (status bizuserinters.Status) {
+	key := userGenerationKey(userID)
+
+	if impl.dataCache.Add(key, int64(1), cache.NoExpiration) != nil {
+		_, _ = impl.dataCache.IncrementInt64(key, 1)
+	}
+
+	ClearSessions(ctx, impl.sessionStorage, userID)
+
+	status.Code = bizuserinters.StatusCodeOk
+
+	return
+}
+
+//
+//
+//
+
+func (impl *memoryUserTokenManagerImpl) generateTokens(ctx context.Context, userInfo usertokenmanagerinters.UserTokenInfo,
+	sessionID string) *usertokenmanagerinters.UserToken {
+	userInfo.SessionID = sessionID
+	userInfo.StartAt = time.Now()
+
+	if userInfo.AuthAt.IsZero() {
+		userInfo.AuthAt = userInfo.StartAt
+	}
+
+	if userInfo.Expiration <= 0 {
+		userInfo.Expiration = defaultTokenExpiration
+	}
+
+	accessExpiration := defaultAccessTokenExpiration
+	if accessExpiration > userInfo.Expiration {
+		accessExpiration = userInfo.Expiration
+	}
+
+	token := &usertokenmanagerinters.UserToken{
+		AccessToken:       uuid.NewV4().String(),
+		AccessExpiration:  accessExpiration,
+		RefreshToken:      uuid.NewV4().String(),
+		RefreshExpiration: userInfo.Expiration,
+	}
+
+	generation := impl.userGeneration(userInfo.ID)
+
+	impl.dataCache.Set(token.AccessToken, &memoryTokenEntry{
+		userInfo:   userInfo,
+		generation: generation,
+	}, token.AccessExpiration)
+	impl.dataCache.Set(token.RefreshToken, &memoryTokenEntry{
+		userInfo:   userInfo,
+		refresh:    true,
+		generation: generation,
+	}, token.RefreshExpiration)
+
+	RecordSession(ctx, impl.sessionStorage, &userInfo)
+
+	return token
+}
+
+func (impl *memoryUserTokenManagerImpl) getEntry(token string) (entry *memoryTokenEntry, ok bool) {
+	i, ok := impl.dataCache.Get(token)
+	if !ok {
+		return
+	}
+
+	entry, ok = i.(*memoryTokenEntry)
+
+	return
+}
+
+func (impl *memoryUserTokenManagerImpl) revokeSession(ctx context.Context, userID uint64, sessionID string, expireAt time.Time) {
+	impl.dataCache.Set(storageKeyPrefixSessionRevoked+sessionID, time.Now(), remainDuration(expireAt.Unix()))
+
+	_ = impl.sessionStorage.DeleteSession(ctx, userID, sessionID)
+}
+
+func (impl *memoryUserTokenManagerImpl) isEntryRevoked(entry *memoryTokenEntry) bool {
+	if _, ok := impl.dataCache.Get(storageKeyPrefixSessionRevoked + entry.userInfo.SessionID); ok {
+		return true
+	}
+
+	return entry.generation < impl.userGeneration(entry.userInfo.ID)
+}
+
+func (impl *memoryUserTokenManagerImpl) userGeneration(userID uint64) (generation int64) {
+	if i, ok := impl.dataCache.Get(userGenerationKey(userID)); ok {
+		generation, _ = i.(int64)
+	}
 
 	return
 }
@@ -0,0 +1,104 @@
+package redis
+
+import (
+	"context"
+	"strconv"
+	"strings"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/s-min-sys/userbe/internal/usertokenmanager/usertokenmanagerinters"
+	"github.com/sgostarter/i/l"
+)
+
+const (
+	bizFlowKeyPrefix = "biz_flow:"
+
+	bizFlowFieldUserID           = "uid"
+	bizFlowFieldAuthMethodPrefix = "amr:"
+)
+
+// NewRedisBizFlowStorage keeps a flow in one hash, every write restarts its expiration.
+// keyPrefix is prepended to every key.
+func NewRedisBizFlowStorage(redisCli *redis.Client, keyPrefix string, logger l.Wrapper) usertokenmanagerinters.BizFlowStorage {
+	if logger == nil {
+		logger = l.NewNopLoggerWrapper()
+	}
+
+	if redisCli == nil {
+		logger.Error("no redis client")
+
+		return nil
+	}
+
+	return &bizFlowStorageImpl{
+		redisCli:  redisCli,
+		keyPrefix: keyPrefix,
+		logger:    logger.WithFields(l.StringField(l.ClsKey, "bizFlowStorageImpl")),
+	}
+}
+
+type bizFlowStorageImpl struct {
+	redisCli  *redis.Client
+	keyPrefix string
+	logger    l.Wrapper
+}
+
+func (impl *bizFlowStorageImpl) AddAuthMethod(ctx context.Context, bizID, authMethod string) error {
+	return impl.set(ctx, bizID, bizFlowFieldAuthMethodPrefix+authMethod, "1")
+}
+
+func (impl *bizFlowStorageImpl) SetUserID(ctx context.Context, bizID string, userID uint64) error {
+	return impl.set(ctx, bizID, bizFlowFieldUserID, strconv.FormatUint(userID, 10))
+}
+
+func (impl *bizFlowStorageImpl) GetFlow(ctx context.Context, bizID string) (*usertokenmanagerinters.BizFlow, error) {
+	fields, err := impl.redisCli.HGetAll(ctx, impl.flowKey(bizID)).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(fields) == 0 {
+		return nil, nil
+	}
+
+	flow := &usertokenmanagerinters.BizFlow{}
+
+	for field, v := range fields {
+		if field == bizFlowFieldUserID {
+			flow.UserID, _ = strconv.ParseUint(v, 10, 64)
+
+			continue
+		}
+
+		if strings.HasPrefix(field, bizFlowFieldAuthMethodPrefix) {
+			flow.AuthMethods = append(flow.AuthMethods, strings.TrimPrefix(field, bizFlowFieldAuthMethodPrefix))
+		}
+	}
+
+	return flow, nil
+}
+
+func (impl *bizFlowStorageImpl) DeleteFlow(ctx context.Context, bizID string) error {
+	return impl.redisCli.Del(ctx, impl.flowKey(bizID)).Err()
+}
+
+//
+//
+//
+
+func (impl *bizFlowStorageImpl) set(ctx context.Context, bizID, field, v string) error {
+	key := impl.flowKey(bizID)
+
+	_, err := impl.redisCli.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, key, field, v)
+		pipe.Expire(ctx, key, usertokenmanagerinters.BizFlowExpiration)
+
+		return nil
+	})
+
+	return err
+}
+
+func (impl *bizFlowStorageImpl) flowKey(bizID string) string {
+	return impl.keyPrefix + bizFlowKeyPrefix + bizID
+}
@@ -0,0 +1,36 @@
+package redis
+
+import (
+	"context"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/s-min-sys/userbe/internal/usertokenmanager/usertokenmanagerinters"
+)
+
+func TestRedisBizFlowStorage(t *testing.T) {
+	ctx := context.Background()
+	mr := miniredis.RunT(t)
+
+	s := NewRedisBizFlowStorage(redis.NewClient(&redis.Options{Addr: mr.Addr()}), "t:", nil)
+
+	if flow, err := s.GetFlow(ctx, "b"); err != nil || flow != nil {
+		t.Fatal("unknown flow must be missing", flow, err)
+	}
+
+	_ = s.AddAuthMethod(ctx, "b", "userpass")
+	_ = s.AddAuthMethod(ctx, "b", "userpass")
+	_ = s.SetUserID(ctx, "b", 1)
+
+	flow, err := s.GetFlow(ctx, "b")
+	if err != nil || flow == nil || flow.UserID != 1 || len(flow.AuthMethods) != 1 {
+		t.Fatal(flow, err)
+	}
+
+	mr.FastForward(usertokenmanagerinters.BizFlowExpiration)
+
+	if flow, err = s.GetFlow(ctx, "b"); err != nil || flow != nil {
+		t.Fatal("expired flow must be missing", flow, err)
+	}
+}
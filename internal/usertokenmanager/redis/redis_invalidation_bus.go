@@ -0,0 +1,69 @@
+package redis
+
+import (
+	"context"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/s-min-sys/userbe/internal/usertokenmanager/usertokenmanagerinters"
+	"github.com/sgostarter/i/l"
+)
+
+const (
+	invalidationChannel = "user_token_invalidation"
+)
+
+// NewRedisInvalidationBus publishes on one channel per keyPrefix, deployments sharing a redis do not see each other.
+func NewRedisInvalidationBus(redisCli *redis.Client, keyPrefix string, logger l.Wrapper) usertokenmanagerinters.InvalidationBus {
+	if logger == nil {
+		logger = l.NewNopLoggerWrapper()
+	}
+
+	if redisCli == nil {
+		logger.Error("no redis client")
+
+		return nil
+	}
+
+	return &invalidationBusImpl{
+		redisCli: redisCli,
+		channel:  keyPrefix + invalidationChannel,
+		logger:   logger.WithFields(l.StringField(l.ClsKey, "invalidationBusImpl")),
+	}
+}
+
+type invalidationBusImpl struct {
+	redisCli *redis.Client
+	channel  string
+	logger   l.Wrapper
+}
+
+func (impl *invalidationBusImpl) Publish(ctx context.Context, key string) error {
+	return impl.redisCli.Publish(ctx, impl.channel, key).Err()
+}
+
+func (impl *invalidationBusImpl) Subscribe(ctx context.Context, handler func(key string)) {
+	pubSub := impl.redisCli.Subscribe(ctx, impl.channel)
+
+	go func() {
+		defer func() {
+			_ = pubSub.Close()
+		}()
+
+		ch := pubSub.Channel()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case msg, ok := <-ch:
+				if !ok {
+					impl.logger.Warn("invalidation channel closed")
+
+					return
+				}
+
+				handler(msg.Payload)
+			}
+		}
+	}()
+}
@@ -9,7 +9,8 @@ import (
 	"github.com/sgostarter/i/l"
 )
 
-func NewRedisJWTDataStorage(redisCli *redis.Client, logger l.Wrapper) usertokenmanagerinters.JWTDataStorage {
+// NewRedisJWTDataStorage keyPrefix is prepended to every key, so several deployments can share one redis.
+func NewRedisJWTDataStorage(redisCli *redis.Client, keyPrefix string, logger l.Wrapper) usertokenmanagerinters.JWTDataStorage {
 	if logger == nil {
 		logger = l.NewNopLoggerWrapper()
 	}
@@ -21,22 +22,33 @@ func NewRedisJWTDataStorage(redisCli *redis.Client, logger l.Wrapper) usertokenm
 	}
 
 	return &jwtDataStorageImpl{
-		redisCli: redisCli,
-		logger:   logger.WithFields(l.StringField(l.ClsKey, "jwtDataStorageImpl")),
+		redisCli:  redisCli,
+		keyPrefix: keyPrefix,
+		logger:    logger.WithFields(l.StringField(l.ClsKey, "jwtDataStorageImpl")),
 	}
 }
 
 type jwtDataStorageImpl struct {
-	redisCli *redis.Client
-	logger   l.Wrapper
+	redisCli  *redis.Client
+	keyPrefix string
+	logger    l.Wrapper
 }
 
 func (impl *jwtDataStorageImpl) Record(ctx context.Context, key string, expiration time.Duration) error {
-	return impl.redisCli.Set(ctx, key, time.Now(), expiration).Err()
+	return impl.redisCli.Set(ctx, impl.keyPrefix+key, time.Now(), expiration).Err()
 }
 
-func (impl *jwtDataStorageImpl) Exists(ctx context.Context, key string) (t bool, err error) {
-	n, err := impl.redisCli.Exists(ctx, key).Result()
+func (impl *jwtDataStorageImpl) RecordIfNotExists(ctx context.Context, key string, expiration time.Duration) (recorded bool, err error) {
+	return impl.redisCli.SetNX(ctx, impl.keyPrefix+key, time.Now(), expiration).Result()
+}
+
+func (impl *jwtDataStorageImpl) Exists(ctx context.Context, keys ...string) (t bool, err error) {
+	prefixedKeys := make([]string, 0, len(keys))
+	for _, key := range keys {
+		prefixedKeys = append(prefixedKeys, impl.keyPrefix+key)
+	}
+
+	n, err := impl.redisCli.Exists(ctx, prefixedKeys...).Result()
 	if err != nil {
 		return
 	}
@@ -45,3 +57,16 @@ func (impl *jwtDataStorageImpl) Exists(ctx context.Context, key string) (t bool,
 
 	return
 }
+
+func (impl *jwtDataStorageImpl) GetCounter(ctx context.Context, key string) (n int64, err error) {
+	n, err = impl.redisCli.Get(ctx, impl.keyPrefix+key).Int64()
+	if err == redis.Nil {
+		err = nil
+	}
+
+	return
+}
+
+func (impl *jwtDataStorageImpl) IncCounter(ctx context.Context, key string) (n int64, err error) {
+	return impl.redisCli.Incr(ctx, impl.keyPrefix+key).Result()
+}
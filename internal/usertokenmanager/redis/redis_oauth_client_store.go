@@ -0,0 +1,76 @@
+package redis
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/go-oauth2/oauth2/v4"
+	"github.com/go-oauth2/oauth2/v4/errors"
+	"github.com/go-oauth2/oauth2/v4/models"
+	"github.com/go-redis/redis/v8"
+	"github.com/s-min-sys/userbe/internal/usertokenmanager/usertokenmanagerinters"
+	"github.com/sgostarter/i/l"
+)
+
+const (
+	keyOAuthClients = "oauth_clients"
+)
+
+// NewRedisOAuthClientStore keeps the OAuth clients in one redis hash, keyPrefix is prepended to its key.
+func NewRedisOAuthClientStore(redisCli *redis.Client, keyPrefix string, logger l.Wrapper) usertokenmanagerinters.OAuthClientStore {
+	if logger == nil {
+		logger = l.NewNopLoggerWrapper()
+	}
+
+	if redisCli == nil {
+		logger.Error("no redis client")
+
+		return nil
+	}
+
+	return &oAuthClientStoreImpl{
+		redisCli: redisCli,
+		key:      keyPrefix + keyOAuthClients,
+		logger:   logger.WithFields(l.StringField(l.ClsKey, "oAuthClientStoreImpl")),
+	}
+}
+
+type oAuthClientStoreImpl struct {
+	redisCli *redis.Client
+	key      string
+	logger   l.Wrapper
+}
+
+func (impl *oAuthClientStoreImpl) GetByID(ctx context.Context, id string) (oauth2.ClientInfo, error) {
+	d, err := impl.redisCli.HGet(ctx, impl.key, id).Bytes()
+	if err == redis.Nil {
+		return nil, errors.ErrInvalidClient
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	var client models.Client
+
+	err = json.Unmarshal(d, &client)
+	if err != nil {
+		return nil, err
+	}
+
+	return &client, nil
+}
+
+func (impl *oAuthClientStoreImpl) Set(ctx context.Context, client oauth2.ClientInfo) error {
+	d, err := json.Marshal(&models.Client{
+		ID:     client.GetID(),
+		Secret: client.GetSecret(),
+		Domain: client.GetDomain(),
+		UserID: client.GetUserID(),
+	})
+	if err != nil {
+		return err
+	}
+
+	return impl.redisCli.HSet(ctx, impl.key, client.GetID(), d).Err()
+}
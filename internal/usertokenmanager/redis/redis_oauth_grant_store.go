@@ -0,0 +1,101 @@
+package redis
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/s-min-sys/userbe/internal/usertokenmanager/usertokenmanagerinters"
+	"github.com/sgostarter/i/l"
+)
+
+const (
+	oAuthGrantsKeyPrefix = "oauth_grants:"
+)
+
+// NewRedisOAuthGrantStore keeps the grants of one user in one hash keyed by client id, keyPrefix is prepended
+// to every key.
+func NewRedisOAuthGrantStore(redisCli *redis.Client, keyPrefix string, logger l.Wrapper) usertokenmanagerinters.OAuthGrantStore {
+	if logger == nil {
+		logger = l.NewNopLoggerWrapper()
+	}
+
+	if redisCli == nil {
+		logger.Error("no redis client")
+
+		return nil
+	}
+
+	return &oAuthGrantStoreImpl{
+		redisCli:  redisCli,
+		keyPrefix: keyPrefix,
+		logger:    logger.WithFields(l.StringField(l.ClsKey, "oAuthGrantStoreImpl")),
+	}
+}
+
+type oAuthGrantStoreImpl struct {
+	redisCli  *redis.Client
+	keyPrefix string
+	logger    l.Wrapper
+}
+
+func (impl *oAuthGrantStoreImpl) SaveGrant(ctx context.Context, grant *usertokenmanagerinters.OAuthGrant) error {
+	d, err := json.Marshal(grant)
+	if err != nil {
+		return err
+	}
+
+	return impl.redisCli.HSet(ctx, impl.grantsKey(grant.UserID), grant.ClientID, d).Err()
+}
+
+func (impl *oAuthGrantStoreImpl) GetGrant(ctx context.Context, userID uint64, clientID string) (*usertokenmanagerinters.OAuthGrant, error) {
+	d, err := impl.redisCli.HGet(ctx, impl.grantsKey(userID), clientID).Bytes()
+	if err == redis.Nil {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	var grant usertokenmanagerinters.OAuthGrant
+
+	err = json.Unmarshal(d, &grant)
+	if err != nil {
+		return nil, err
+	}
+
+	return &grant, nil
+}
+
+func (impl *oAuthGrantStoreImpl) ListGrants(ctx context.Context, userID uint64) ([]*usertokenmanagerinters.OAuthGrant, error) {
+	m, err := impl.redisCli.HGetAll(ctx, impl.grantsKey(userID)).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	grants := make([]*usertokenmanagerinters.OAuthGrant, 0, len(m))
+
+	for clientID, d := range m {
+		var grant usertokenmanagerinters.OAuthGrant
+
+		if err = json.Unmarshal([]byte(d), &grant); err != nil {
+			impl.logger.WithFields(l.ErrorField(err), l.StringField("clientID", clientID)).Error("bad grant data")
+
+			continue
+		}
+
+		grants = append(grants, &grant)
+	}
+
+	return grants, nil
+}
+
+func (impl *oAuthGrantStoreImpl) DeleteGrant(ctx context.Context, userID uint64, clientID string) error {
+	return impl.redisCli.HDel(ctx, impl.grantsKey(userID), clientID).Err()
+}
+
+func (impl *oAuthGrantStoreImpl) grantsKey(userID uint64) string {
+	return impl.keyPrefix + oAuthGrantsKeyPrefix + strconv.FormatUint(userID, 10)
+}
@@ -0,0 +1,203 @@
+package redis
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/go-oauth2/oauth2/v4"
+	"github.com/go-redis/redis/v8"
+	"github.com/s-min-sys/userbe/internal/usertokenmanager/usertokenmanagerinters"
+	"github.com/sgostarter/i/l"
+)
+
+const (
+	keyPrefixOAuthCode    = "oc:"
+	keyPrefixOAuthAccess  = "oa:"
+	keyPrefixOAuthRefresh = "or:"
+	// keyPrefixOAuthUserClient sets of the code and token keys of a user and client
+	keyPrefixOAuthUserClient = "ouc:"
+)
+
+// NewRedisOAuthTokenStore keeps OAuth codes and tokens in redis, keyPrefix is prepended to every key.
+func NewRedisOAuthTokenStore(redisCli *redis.Client, keyPrefix string, logger l.Wrapper) usertokenmanagerinters.OAuthTokenStore {
+	if logger == nil {
+		logger = l.NewNopLoggerWrapper()
+	}
+
+	if redisCli == nil {
+		logger.Error("no redis client")
+
+		return nil
+	}
+
+	return &oAuthTokenStoreImpl{
+		redisCli:  redisCli,
+		keyPrefix: keyPrefix,
+		logger:    logger.WithFields(l.StringField(l.ClsKey, "oAuthTokenStoreImpl")),
+	}
+}
+
+type oAuthTokenStoreImpl struct {
+	redisCli  *redis.Client
+	keyPrefix string
+	logger    l.Wrapper
+}
+
+func (impl *oAuthTokenStoreImpl) Create(ctx context.Context, info oauth2.TokenInfo) error {
+	d, err := json.Marshal(info)
+	if err != nil {
+		return err
+	}
+
+	if code := info.GetCode(); code != "" {
+		key := impl.key(keyPrefixOAuthCode, code)
+
+		err = impl.redisCli.Set(ctx, key, d, info.GetCodeExpiresIn()).Err()
+		if err != nil {
+			return err
+		}
+
+		return impl.index(ctx, info, info.GetCodeExpiresIn(), key)
+	}
+
+	accessExpiration := info.GetAccessExpiresIn()
+	expiration := accessExpiration
+
+	keys := []string{impl.key(keyPrefixOAuthAccess, info.GetAccess())}
+
+	pipe := impl.redisCli.TxPipeline()
+
+	if refresh := info.GetRefresh(); refresh != "" {
+		// zero keeps the refresh token forever
+		var refreshExpiration time.Duration
+
+		if info.GetRefreshExpiresIn() != 0 {
+			refreshExpiration = ttlUntil(info.GetRefreshCreateAt().Add(info.GetRefreshExpiresIn()))
+
+			if accessExpiration > refreshExpiration {
+				accessExpiration = refreshExpiration
+			}
+		}
+
+		expiration = refreshExpiration
+
+		keys = append(keys, impl.key(keyPrefixOAuthRefresh, refresh))
+
+		pipe.Set(ctx, keys[1], d, refreshExpiration)
+	}
+
+	pipe.Set(ctx, keys[0], d, accessExpiration)
+
+	_, err = pipe.Exec(ctx)
+	if err != nil {
+		return err
+	}
+
+	return impl.index(ctx, info, expiration, keys...)
+}
+
+func (impl *oAuthTokenStoreImpl) RemoveByCode(ctx context.Context, code string) error {
+	return impl.redisCli.Del(ctx, impl.key(keyPrefixOAuthCode, code)).Err()
+}
+
+func (impl *oAuthTokenStoreImpl) RemoveByAccess(ctx context.Context, access string) error {
+	return impl.redisCli.Del(ctx, impl.key(keyPrefixOAuthAccess, access)).Err()
+}
+
+func (impl *oAuthTokenStoreImpl) RemoveByRefresh(ctx context.Context, refresh string) error {
+	return impl.redisCli.Del(ctx, impl.key(keyPrefixOAuthRefresh, refresh)).Err()
+}
+
+func (impl *oAuthTokenStoreImpl) RemoveByUserClient(ctx context.Context, userID uint64, clientID string) error {
+	indexKey := impl.userClientKey(strconv.FormatUint(userID, 10), clientID)
+
+	keys, err := impl.redisCli.SMembers(ctx, indexKey).Result()
+	if err != nil {
+		return err
+	}
+
+	return impl.redisCli.Del(ctx, append(keys, indexKey)...).Err()
+}
+
+func (impl *oAuthTokenStoreImpl) GetByCode(ctx context.Context, code string) (oauth2.TokenInfo, error) {
+	return impl.get(ctx, impl.key(keyPrefixOAuthCode, code))
+}
+
+func (impl *oAuthTokenStoreImpl) GetByAccess(ctx context.Context, access string) (oauth2.TokenInfo, error) {
+	return impl.get(ctx, impl.key(keyPrefixOAuthAccess, access))
+}
+
+func (impl *oAuthTokenStoreImpl) GetByRefresh(ctx context.Context, refresh string) (oauth2.TokenInfo, error) {
+	return impl.get(ctx, impl.key(keyPrefixOAuthRefresh, refresh))
+}
+
+//
+//
+//
+
+func (impl *oAuthTokenStoreImpl) key(prefix, v string) string {
+	return impl.keyPrefix + prefix + v
+}
+
+func (impl *oAuthTokenStoreImpl) userClientKey(userID, clientID string) string {
+	return impl.key(keyPrefixOAuthUserClient, userID+":"+clientID)
+}
+
+// index the set lives as long as its longest lived key, zero expiration is forever. Removed or expired keys stay
+// members until the set expires, deleting them again is harmless.
+func (impl *oAuthTokenStoreImpl) index(ctx context.Context, info oauth2.TokenInfo, expiration time.Duration,
+	keys ...string) error {
+	if info.GetUserID() == "" {
+		return nil
+	}
+
+	indexKey := impl.userClientKey(info.GetUserID(), info.GetClientID())
+
+	members := make([]interface{}, 0, len(keys))
+	for _, key := range keys {
+		members = append(members, key)
+	}
+
+	// read before adding, -2 is a new set and -1 one kept forever
+	ttl, err := impl.redisCli.TTL(ctx, indexKey).Result()
+	if err != nil {
+		return err
+	}
+
+	err = impl.redisCli.SAdd(ctx, indexKey, members...).Err()
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case expiration == 0:
+		err = impl.redisCli.Persist(ctx, indexKey).Err()
+	case ttl != -1 && ttl < expiration:
+		err = impl.redisCli.Expire(ctx, indexKey, expiration).Err()
+	}
+
+	return err
+}
+
+// get a missing token is not an error, go-oauth2 expects nil info then.
+func (impl *oAuthTokenStoreImpl) get(ctx context.Context, key string) (oauth2.TokenInfo, error) {
+	d, err := impl.redisCli.Get(ctx, key).Bytes()
+	if err == redis.Nil {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	var token usertokenmanagerinters.OAuthTokenInfo
+
+	err = json.Unmarshal(d, &token)
+	if err != nil {
+		return nil, err
+	}
+
+	return &token, nil
+}
@@ -0,0 +1,63 @@
+package redis
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-oauth2/oauth2/v4/models"
+	"github.com/go-redis/redis/v8"
+)
+
+func TestRedisOAuthTokenStoreRemoveByUserClient(t *testing.T) {
+	ctx := context.Background()
+	mr := miniredis.RunT(t)
+
+	s := NewRedisOAuthTokenStore(redis.NewClient(&redis.Options{Addr: mr.Addr()}), "t:", nil)
+
+	now := time.Now()
+
+	for _, token := range []*models.Token{
+		{ClientID: "c", UserID: "1", Access: "a1", AccessCreateAt: now, AccessExpiresIn: time.Hour,
+			Refresh: "r1", RefreshCreateAt: now, RefreshExpiresIn: time.Hour * 24},
+		{ClientID: "c", UserID: "1", Code: "code", CodeCreateAt: now, CodeExpiresIn: time.Minute},
+		{ClientID: "d", UserID: "1", Access: "a2", AccessCreateAt: now, AccessExpiresIn: time.Hour},
+		{ClientID: "c", UserID: "2", Access: "a3", AccessCreateAt: now, AccessExpiresIn: time.Hour},
+	} {
+		if err := s.Create(ctx, token); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the code must not shorten the index below the refresh token
+	if ttl := mr.TTL("t:ouc:1:c"); ttl < time.Hour*23 {
+		t.Fatal("index expires before its tokens", ttl)
+	}
+
+	if err := s.RemoveByUserClient(ctx, 1, "c"); err != nil {
+		t.Fatal(err)
+	}
+
+	if token, _ := s.GetByCode(ctx, "code"); token != nil {
+		t.Fatal("code of the client must be removed")
+	}
+
+	if token, _ := s.GetByAccess(ctx, "a1"); token != nil {
+		t.Fatal("access token of the client must be removed")
+	}
+
+	if token, _ := s.GetByRefresh(ctx, "r1"); token != nil {
+		t.Fatal("refresh token of the client must be removed")
+	}
+
+	for _, access := range []string{"a2", "a3"} {
+		if token, err := s.GetByAccess(ctx, access); err != nil || token == nil {
+			t.Fatal("tokens of other clients and users stay", access, err)
+		}
+	}
+
+	if mr.Exists("t:ouc:1:c") {
+		t.Fatal("index must be removed")
+	}
+}
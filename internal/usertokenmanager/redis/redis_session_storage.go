@@ -0,0 +1,139 @@
+package redis
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/s-min-sys/userbe/internal/usertokenmanager/usertokenmanagerinters"
+	"github.com/sgostarter/i/l"
+)
+
+const (
+	sessionsKeyPrefix = "user_sessions:"
+)
+
+// NewRedisSessionStorage keeps the sessions of one user in one hash, the hash lives as long as its latest session.
+// keyPrefix is prepended to every key.
+func NewRedisSessionStorage(redisCli *redis.Client, keyPrefix string, logger l.Wrapper) usertokenmanagerinters.SessionStorage {
+	if logger == nil {
+		logger = l.NewNopLoggerWrapper()
+	}
+
+	if redisCli == nil {
+		logger.Error("no redis client")
+
+		return nil
+	}
+
+	return &sessionStorageImpl{
+		redisCli:  redisCli,
+		keyPrefix: keyPrefix,
+		logger:    logger.WithFields(l.StringField(l.ClsKey, "sessionStorageImpl")),
+	}
+}
+
+type sessionStorageImpl struct {
+	redisCli  *redis.Client
+	keyPrefix string
+	logger    l.Wrapper
+}
+
+func (impl *sessionStorageImpl) SaveSession(ctx context.Context, session *usertokenmanagerinters.Session) error {
+	d, err := json.Marshal(session)
+	if err != nil {
+		return err
+	}
+
+	key := impl.sessionsKey(session.UserID)
+
+	err = impl.redisCli.HSet(ctx, key, session.ID, d).Err()
+	if err != nil {
+		return err
+	}
+
+	ttl, err := impl.redisCli.TTL(ctx, key).Result()
+	if err != nil {
+		return err
+	}
+
+	if expiration := time.Until(session.ExpireAt); ttl < expiration {
+		err = impl.redisCli.Expire(ctx, key, expiration).Err()
+	}
+
+	return err
+}
+
+func (impl *sessionStorageImpl) GetSession(ctx context.Context, userID uint64, sessionID string) (*usertokenmanagerinters.Session, error) {
+	d, err := impl.redisCli.HGet(ctx, impl.sessionsKey(userID), sessionID).Bytes()
+	if err != nil {
+		if err == redis.Nil {
+			err = nil
+		}
+
+		return nil, err
+	}
+
+	var session usertokenmanagerinters.Session
+
+	err = json.Unmarshal(d, &session)
+	if err != nil {
+		return nil, err
+	}
+
+	if session.ExpireAt.Before(time.Now()) {
+		return nil, nil
+	}
+
+	return &session, nil
+}
+
+func (impl *sessionStorageImpl) ListSessions(ctx context.Context, userID uint64) ([]*usertokenmanagerinters.Session, error) {
+	key := impl.sessionsKey(userID)
+
+	m, err := impl.redisCli.HGetAll(ctx, key).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	sessions := make([]*usertokenmanagerinters.Session, 0, len(m))
+	expiredIDs := make([]string, 0)
+
+	now := time.Now()
+
+	for id, d := range m {
+		var session usertokenmanagerinters.Session
+
+		if err = json.Unmarshal([]byte(d), &session); err != nil {
+			impl.logger.WithFields(l.ErrorField(err), l.StringField("sessionID", id)).Error("bad session data")
+
+			expiredIDs = append(expiredIDs, id)
+
+			continue
+		}
+
+		if session.ExpireAt.Before(now) {
+			expiredIDs = append(expiredIDs, id)
+
+			continue
+		}
+
+		sessions = append(sessions, &session)
+	}
+
+	if len(expiredIDs) > 0 {
+		_ = impl.redisCli.HDel(ctx, key, expiredIDs...).Err()
+	}
+
+	return sessions, nil
+}
+
+func (impl *sessionStorageImpl) DeleteSession(ctx context.Context, userID uint64, sessionID string) error {
+	return impl.redisCli.HDel(ctx, impl.sessionsKey(userID), sessionID).Err()
+}
+
+func (impl *sessionStorageImpl) sessionsKey(userID uint64) string {
+	return impl.keyPrefix + sessionsKeyPrefix + strconv.FormatUint(userID, 10)
+}
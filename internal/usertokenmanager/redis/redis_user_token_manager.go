@@ -0,0 +1,757 @@
+package redis
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/s-min-sys/userbe/internal/usertokenmanager"
+	"github.com/s-min-sys/userbe/internal/usertokenmanager/usertokenmanagerinters"
+	uuid "github.com/satori/go.uuid"
+	"github.com/sbasestarter/bizuserlib/bizuserinters"
+	"github.com/sgostarter/i/l"
+)
+
+const (
+	defaultTokenExpiration       = time.Hour * 24 * 31 * 12
+	defaultAccessTokenExpiration = time.Minute * 15
+	defaultSSOTokenExpiration    = time.Minute
+	// renewGrace the pair a refresh token was rotated from stays usable this long, a client which lost the
+	// renew response may retry
+	renewGrace = time.Minute
+)
+
+// ErrRenewPending a retry came in before the first renewal saved its pair.
+var ErrRenewPending = errors.New("renew pending")
+
+const (
+	keyPrefixToken            = "ot:"
+	keyPrefixSSOToken         = "os:"
+	keyPrefixStepUpToken      = "ou:"
+	keyPrefixRefreshTokenUsed = "ort:"
+	keyPrefixRenewGrace       = "org:"
+	keyPrefixSSOTokenUsed     = "ost:"
+	keyPrefixSessionRevoked   = "osr:"
+	keyPrefixUserGeneration   = "oug:"
+)
+
+// UserTokenManagerConfigs MaxSessionAge, counted from AuthAt, bounds how long renewals may keep a session alive.
+type UserTokenManagerConfigs struct {
+	AccessTokenExpiration time.Duration
+	MaxSessionAge         time.Duration
+}
+
+// NewRedisUserTokenManager issues opaque tokens kept in redis, they survive restarts and are shared by replicas.
+// keyPrefix is prepended to every key.
+func NewRedisUserTokenManager(redisCli *redis.Client, keyPrefix string, sessionStorage usertokenmanagerinters.SessionStorage,
+	configs UserTokenManagerConfigs, logger l.Wrapper) usertokenmanagerinters.UserTokenManager {
+	if logger == nil {
+		logger = l.NewNopLoggerWrapper()
+	}
+
+	if redisCli == nil || sessionStorage == nil {
+		logger.Error("no redis client or session storage")
+
+		return nil
+	}
+
+	if configs.AccessTokenExpiration <= 0 {
+		configs.AccessTokenExpiration = defaultAccessTokenExpiration
+	}
+
+	return &userTokenManagerImpl{
+		redisCli:       redisCli,
+		keyPrefix:      keyPrefix,
+		sessionStorage: sessionStorage,
+		configs:        configs,
+		logger:         logger.WithFields(l.StringField(l.ClsKey, "userTokenManagerImpl")),
+	}
+}
+
+type userTokenManagerImpl struct {
+	redisCli       *redis.Client
+	keyPrefix      string
+	sessionStorage usertokenmanagerinters.SessionStorage
+	configs        UserTokenManagerConfigs
+	logger         l.Wrapper
+}
+
+// tokenEntry AuthTime carries UserInfo.AuthAt which is not marshaled. AccessHash of a refresh token is the hash
+// of the access token issued with it.
+type tokenEntry struct {
+	UserInfo   usertokenmanagerinters.UserTokenInfo `json:"user_info"`
+	AuthTime   int64                                `json:"auth_time"`
+	Refresh    bool                                 `json:"refresh,omitempty"`
+	Generation int64                                `json:"gen,omitempty"`
+	AccessHash string                               `json:"access_hash,omitempty"`
+}
+
+// ssoTokenEntry Parent is the entry of the parent access token, its session is checked on exchange.
+type ssoTokenEntry struct {
+	Parent  tokenEntry                             `json:"parent"`
+	Options usertokenmanagerinters.SSOTokenOptions `json:"options"`
+}
+
+func (impl *userTokenManagerImpl) GenToken(ctx context.Context, userInfo *usertokenmanagerinters.UserTokenInfo) (
+	token *usertokenmanagerinters.UserToken, status bizuserinters.Status) {
+	if userInfo == nil || (userInfo.ID == 0 && userInfo.UserName == "") {
+		status = bizuserinters.MakeStatusByCode(bizuserinters.StatusCodeInvalidArgsError)
+
+		return
+	}
+
+	token, err := impl.generateTokens(ctx, *userInfo, uuid.NewV4().String())
+	if err != nil {
+		status = bizuserinters.MakeStatusByError(bizuserinters.StatusCodeInternalError, err)
+
+		return
+	}
+
+	status = bizuserinters.MakeSuccessStatus()
+
+	return
+}
+
+func (impl *userTokenManagerImpl) ExplainToken(ctx context.Context, token string) (
+	userInfo *usertokenmanagerinters.UserTokenInfo, status bizuserinters.Status) {
+	entry, status := impl.getValidEntry(ctx, keyPrefixToken, token, false)
+	if status.Code != bizuserinters.StatusCodeOk {
+		return
+	}
+
+	userInfo = &entry.UserInfo
+
+	return
+}
+
+func (impl *userTokenManagerImpl) RenewToken(ctx context.Context, refreshToken string) (
+	newToken *usertokenmanagerinters.UserToken, userInfo *usertokenmanagerinters.UserTokenInfo, status bizuserinters.Status) {
+	entry, status := impl.getValidEntry(ctx, keyPrefixToken, refreshToken, true)
+	if status.Code != bizuserinters.StatusCodeOk {
+		return
+	}
+
+	if usertokenmanager.SessionAgeExceeded(&entry.UserInfo, impl.configs.MaxSessionAge) {
+		status = bizuserinters.MakeStatusByCode(bizuserinters.StatusCodeExpiredError)
+
+		return
+	}
+
+	expireAt := entry.UserInfo.StartAt.Add(entry.UserInfo.Expiration)
+
+	newToken, status = impl.rotateRefreshToken(ctx, refreshToken, entry, expireAt)
+	if status.Code != bizuserinters.StatusCodeOk {
+		return
+	}
+
+	userInfo = &entry.UserInfo
+
+	if newToken != nil {
+		return
+	}
+
+	newToken, err := impl.generateTokens(ctx, entry.UserInfo, entry.UserInfo.SessionID)
+	if err != nil {
+		status = bizuserinters.MakeStatusByError(bizuserinters.StatusCodeInternalError, err)
+
+		return
+	}
+
+	// a failure leaves the pending mark, retries fail until the grace period ends
+	err = impl.saveRenewedToken(ctx, refreshToken, newToken)
+	if err != nil {
+		impl.logger.WithFields(l.ErrorField(err)).Error("save renewed token failed")
+	}
+
+	return
+}
+
+func (impl *userTokenManagerImpl) GenSSOToken(ctx context.Context, parentToken string,
+	options *usertokenmanagerinters.SSOTokenOptions) (token string, status bizuserinters.Status) {
+	if options == nil || options.Audience == "" || options.ClientID == "" {
+		status = bizuserinters.MakeStatusByCode(bizuserinters.StatusCodeInvalidArgsError)
+
+		return
+	}
+
+	parent, status := impl.getValidEntry(ctx, keyPrefixToken, parentToken, false)
+	if status.Code != bizuserinters.StatusCodeOk {
+		return
+	}
+
+	expiration := options.Expiration
+	if expiration <= 0 {
+		expiration = defaultSSOTokenExpiration
+	}
+
+	d, err := json.Marshal(&ssoTokenEntry{
+		Parent:  *parent,
+		Options: *options,
+	})
+	if err != nil {
+		status = bizuserinters.MakeStatusByError(bizuserinters.StatusCodeInternalError, err)
+
+		return
+	}
+
+	token = uuid.NewV4().String()
+
+	err = impl.redisCli.Set(ctx, impl.tokenKey(keyPrefixSSOToken, token), d, expiration).Err()
+	if err != nil {
+		status = bizuserinters.MakeStatusByError(bizuserinters.StatusCodeInternalError, err)
+
+		return
+	}
+
+	status = bizuserinters.MakeSuccessStatus()
+
+	return
+}
+
+func (impl *userTokenManagerImpl) ExplainSSOToken(ctx context.Context, token, audience, clientID string) (
+	ssoTokenInfo *usertokenmanagerinters.SSOTokenInfo, status bizuserinters.Status) {
+	d, err := impl.redisCli.Get(ctx, impl.tokenKey(keyPrefixSSOToken, token)).Bytes()
+	if err != nil {
+		if err == redis.Nil {
+			status = bizuserinters.MakeStatusByCode(bizuserinters.StatusCodeNoDataError)
+		} else {
+			status = bizuserinters.MakeStatusByError(bizuserinters.StatusCodeInternalError, err)
+		}
+
+		return
+	}
+
+	var entry ssoTokenEntry
+
+	if err = json.Unmarshal(d, &entry); err != nil {
+		status = bizuserinters.MakeStatusByError(bizuserinters.StatusCodeBadDataError, err)
+
+		return
+	}
+
+	if entry.Options.Audience != audience || entry.Options.ClientID != clientID {
+		status = bizuserinters.MakeStatusByCode(bizuserinters.StatusCodePermissionError)
+
+		return
+	}
+
+	expiration := entry.Options.Expiration
+	if expiration <= 0 {
+		expiration = defaultSSOTokenExpiration
+	}
+
+	recorded, err := impl.redisCli.SetNX(ctx, impl.tokenKey(keyPrefixSSOTokenUsed, token), time.Now().Unix(), expiration).Result()
+	if err != nil {
+		status = bizuserinters.MakeStatusByError(bizuserinters.StatusCodeInternalError, err)
+
+		return
+	}
+
+	if !recorded {
+		status = bizuserinters.MakeStatusByCode(bizuserinters.StatusCodeExpiredError)
+
+		return
+	}
+
+	_ = impl.redisCli.Del(ctx, impl.tokenKey(keyPrefixSSOToken, token)).Err()
+
+	status = impl.checkSSOParent(ctx, &entry.Parent)
+	if status.Code != bizuserinters.StatusCodeOk {
+		return
+	}
+
+	entry.Parent.UserInfo.AuthAt = time.Unix(entry.Parent.AuthTime, 0)
+
+	ssoTokenInfo = &usertokenmanagerinters.SSOTokenInfo{
+		UserTokenInfo: &entry.Parent.UserInfo,
+		Audience:      entry.Options.Audience,
+		ClientID:      entry.Options.ClientID,
+		Scopes:        entry.Options.Scopes,
+	}
+
+	return
+}
+
+func (impl *userTokenManagerImpl) GenStepUpToken(ctx context.Context, userInfo *usertokenmanagerinters.UserTokenInfo,
+	expiration time.Duration) (token string, status bizuserinters.Status) {
+	if userInfo == nil || userInfo.ID == 0 || userInfo.SessionID == "" || expiration <= 0 {
+		status = bizuserinters.MakeStatusByCode(bizuserinters.StatusCodeInvalidArgsError)
+
+		return
+	}
+
+	generation, err := impl.userGeneration(ctx, userInfo.ID)
+	if err != nil {
+		status = bizuserinters.MakeStatusByError(bizuserinters.StatusCodeInternalError, err)
+
+		return
+	}
+
+	d, err := json.Marshal(&tokenEntry{
+		UserInfo:   *userInfo,
+		AuthTime:   userInfo.AuthAt.Unix(),
+		Generation: generation,
+	})
+	if err != nil {
+		status = bizuserinters.MakeStatusByError(bizuserinters.StatusCodeInternalError, err)
+
+		return
+	}
+
+	token = uuid.NewV4().String()
+
+	err = impl.redisCli.Set(ctx, impl.tokenKey(keyPrefixStepUpToken, token), d, expiration).Err()
+	if err != nil {
+		status = bizuserinters.MakeStatusByError(bizuserinters.StatusCodeInternalError, err)
+
+		return
+	}
+
+	status = bizuserinters.MakeSuccessStatus()
+
+	return
+}
+
+func (impl *userTokenManagerImpl) ExplainStepUpToken(ctx context.Context, token string) (
+	userInfo *usertokenmanagerinters.UserTokenInfo, status bizuserinters.Status) {
+	entry, status := impl.getValidEntry(ctx, keyPrefixStepUpToken, token, false)
+	if status.Code != bizuserinters.StatusCodeOk {
+		return
+	}
+
+	userInfo = &entry.UserInfo
+
+	return
+}
+
+func (impl *userTokenManagerImpl) DeleteToken(ctx context.Context, token string) bizuserinters.Status {
+	entry, err := impl.getEntry(ctx, keyPrefixToken, token)
+	if err != nil {
+		return bizuserinters.MakeStatusByError(bizuserinters.StatusCodeInternalError, err)
+	}
+
+	if entry != nil {
+		impl.revokeSession(ctx, entry.UserInfo.ID, entry.UserInfo.SessionID, entry.UserInfo.StartAt.Add(entry.UserInfo.Expiration))
+	}
+
+	_ = impl.redisCli.Del(ctx, impl.tokenKey(keyPrefixToken, token)).Err()
+
+	return bizuserinters.MakeSuccessStatus()
+}
+
+func (impl *userTokenManagerImpl) ListSessions(ctx context.Context, userID uint64) (
+	sessions []*usertokenmanagerinters.Session, status bizuserinters.Status) {
+	sessions, err := impl.sessionStorage.ListSessions(ctx, userID)
+	if err != nil {
+		status = bizuserinters.MakeStatusByError(bizuserinters.StatusCodeInternalError, err)
+
+		return
+	}
+
+	status = bizuserinters.MakeSuccessStatus()
+
+	return
+}
+
+func (impl *userTokenManagerImpl) RevokeSession(ctx context.Context, userID uint64, sessionID string) bizuserinters.Status {
+	session, err := impl.sessionStorage.GetSession(ctx, userID, sessionID)
+	if err != nil {
+		return bizuserinters.MakeStatusByError(bizuserinters.StatusCodeInternalError, err)
+	}
+
+	if session == nil {
+		return bizuserinters.MakeStatusByCode(bizuserinters.StatusCodeNoDataError)
+	}
+
+	impl.revokeSession(ctx, userID, sessionID, session.ExpireAt)
+
+	return bizuserinters.MakeSuccessStatus()
+}
+
+func (impl *userTokenManagerImpl) RevokeSessions(ctx context.Context, userID uint64, exceptSessionID string) bizuserinters.Status {
+	sessions, err := impl.sessionStorage.ListSessions(ctx, userID)
+	if err != nil {
+		return bizuserinters.MakeStatusByError(bizuserinters.StatusCodeInternalError, err)
+	}
+
+	for _, session := range sessions {
+		if session.ID == exceptSessionID {
+			continue
+		}
+
+		impl.revokeSession(ctx, userID, session.ID, session.ExpireAt)
+	}
+
+	return bizuserinters.MakeSuccessStatus()
+}
+
+func (impl *userTokenManagerImpl) RevokeUserTokens(ctx context.Context, userID uint64) bizuserinters.Status {
+	err := impl.redisCli.Incr(ctx, impl.userGenerationKey(userID)).Err()
+	if err != nil {
+		return bizuserinters.MakeStatusByError(bizuserinters.StatusCodeInternalError, err)
+	}
+
+	usertokenmanager.ClearSessions(ctx, impl.sessionStorage, userID)
+
+	return bizuserinters.MakeSuccessStatus()
+}
+
+//
+//
+//
+
+func (impl *userTokenManagerImpl) generateTokens(ctx context.Context, userInfo usertokenmanagerinters.UserTokenInfo,
+	sessionID string) (token *usertokenmanagerinters.UserToken, err error) {
+	userInfo.SessionID = sessionID
+	userInfo.StartAt = time.Now()
+
+	if userInfo.AuthAt.IsZero() {
+		userInfo.AuthAt = userInfo.StartAt
+	}
+
+	if userInfo.Expiration <= 0 {
+		userInfo.Expiration = defaultTokenExpiration
+	}
+
+	usertokenmanager.CapSessionExpiration(&userInfo, impl.configs.MaxSessionAge)
+
+	accessExpiration := impl.configs.AccessTokenExpiration
+	if accessExpiration > userInfo.Expiration {
+		accessExpiration = userInfo.Expiration
+	}
+
+	generation, err := impl.userGeneration(ctx, userInfo.ID)
+	if err != nil {
+		return
+	}
+
+	token = &usertokenmanagerinters.UserToken{
+		AccessToken:       uuid.NewV4().String(),
+		AccessExpiration:  accessExpiration,
+		RefreshToken:      uuid.NewV4().String(),
+		RefreshExpiration: userInfo.Expiration,
+	}
+
+	accessEntry, err := json.Marshal(&tokenEntry{
+		UserInfo:   userInfo,
+		AuthTime:   userInfo.AuthAt.Unix(),
+		Generation: generation,
+	})
+	if err != nil {
+		return
+	}
+
+	refreshEntry, err := json.Marshal(&tokenEntry{
+		UserInfo:   userInfo,
+		AuthTime:   userInfo.AuthAt.Unix(),
+		Refresh:    true,
+		Generation: generation,
+		AccessHash: hashToken(token.AccessToken),
+	})
+	if err != nil {
+		return
+	}
+
+	_, err = impl.redisCli.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Set(ctx, impl.tokenKey(keyPrefixToken, token.AccessToken), accessEntry, token.AccessExpiration)
+		pipe.Set(ctx, impl.tokenKey(keyPrefixToken, token.RefreshToken), refreshEntry, token.RefreshExpiration)
+
+		return nil
+	})
+	if err != nil {
+		return
+	}
+
+	usertokenmanager.RecordSession(ctx, impl.sessionStorage, &userInfo)
+
+	return
+}
+
+// getEntry step-up tokens are kept under a prefix of their own, prefix tells which kind is looked up.
+func (impl *userTokenManagerImpl) getEntry(ctx context.Context, prefix, token string) (*tokenEntry, error) {
+	d, err := impl.redisCli.Get(ctx, impl.tokenKey(prefix, token)).Bytes()
+	if err != nil {
+		if err == redis.Nil {
+			err = nil
+		}
+
+		return nil, err
+	}
+
+	var entry tokenEntry
+
+	err = json.Unmarshal(d, &entry)
+	if err != nil {
+		return nil, err
+	}
+
+	entry.UserInfo.AuthAt = time.Unix(entry.AuthTime, 0)
+
+	return &entry, nil
+}
+
+// rotateRefreshToken the first renewal starts the grace period and shortens the old access token to it, a retry
+// within the grace period gets the pair the first one issued. A refresh token used again after the grace period
+// has leaked. issued is nil for the first renewal, the caller issues the pair then.
+func (impl *userTokenManagerImpl) rotateRefreshToken(ctx context.Context, refreshToken string, entry *tokenEntry,
+	expireAt time.Time) (issued *usertokenmanagerinters.UserToken, status bizuserinters.Status) {
+	graceKey := impl.tokenKey(keyPrefixRenewGrace, refreshToken)
+
+	// empty until the pair is saved
+	first, err := impl.redisCli.SetNX(ctx, graceKey, "", renewGrace).Result()
+	if err != nil {
+		status = bizuserinters.MakeStatusByError(bizuserinters.StatusCodeInternalError, err)
+
+		return
+	}
+
+	if !first {
+		issued, err = impl.loadRenewedToken(ctx, refreshToken)
+		if err != nil {
+			status = bizuserinters.MakeStatusByError(bizuserinters.StatusCodeInternalError, err)
+
+			return
+		}
+
+		status = bizuserinters.MakeSuccessStatus()
+
+		return
+	}
+
+	recorded, err := impl.redisCli.SetNX(ctx, impl.tokenKey(keyPrefixRefreshTokenUsed, refreshToken), time.Now().Unix(),
+		ttlUntil(expireAt)).Result()
+	if err != nil {
+		status = bizuserinters.MakeStatusByError(bizuserinters.StatusCodeInternalError, err)
+
+		return
+	}
+
+	if !recorded {
+		// a refresh token used twice has leaked, nobody in this session can be trusted anymore
+		impl.revokeSession(ctx, entry.UserInfo.ID, entry.UserInfo.SessionID, expireAt)
+
+		_ = impl.redisCli.Del(ctx, graceKey).Err()
+
+		status = bizuserinters.MakeStatusByCode(bizuserinters.StatusCodeExpiredError)
+
+		return
+	}
+
+	if entry.AccessHash != "" {
+		accessKey := impl.key(keyPrefixToken, entry.AccessHash)
+
+		ttl, err := impl.redisCli.TTL(ctx, accessKey).Result()
+		if err == nil && ttl > renewGrace {
+			_ = impl.redisCli.Expire(ctx, accessKey, renewGrace).Err()
+		}
+	}
+
+	status = bizuserinters.MakeSuccessStatus()
+
+	return
+}
+
+// saveRenewedToken the pair is sealed with a key derived from the old refresh token, only its holder can retry.
+func (impl *userTokenManagerImpl) saveRenewedToken(ctx context.Context, refreshToken string,
+	token *usertokenmanagerinters.UserToken) error {
+	d, err := json.Marshal(token)
+	if err != nil {
+		return err
+	}
+
+	sealed, err := sealWithToken(refreshToken, d)
+	if err != nil {
+		return err
+	}
+
+	return impl.redisCli.SetXX(ctx, impl.tokenKey(keyPrefixRenewGrace, refreshToken), sealed, redis.KeepTTL).Err()
+}
+
+func (impl *userTokenManagerImpl) loadRenewedToken(ctx context.Context, refreshToken string) (
+	*usertokenmanagerinters.UserToken, error) {
+	sealed, err := impl.redisCli.Get(ctx, impl.tokenKey(keyPrefixRenewGrace, refreshToken)).Bytes()
+	if err == redis.Nil || (err == nil && len(sealed) == 0) {
+		return nil, ErrRenewPending
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	d, err := openWithToken(refreshToken, sealed)
+	if err != nil {
+		return nil, err
+	}
+
+	var token usertokenmanagerinters.UserToken
+
+	err = json.Unmarshal(d, &token)
+	if err != nil {
+		return nil, err
+	}
+
+	return &token, nil
+}
+
+// getValidEntry storage errors reject the token.
+func (impl *userTokenManagerImpl) getValidEntry(ctx context.Context, prefix, token string, refresh bool) (
+	entry *tokenEntry, status bizuserinters.Status) {
+	entry, err := impl.getEntry(ctx, prefix, token)
+	if err != nil {
+		status = bizuserinters.MakeStatusByError(bizuserinters.StatusCodeInternalError, err)
+
+		return
+	}
+
+	if entry == nil || entry.Refresh != refresh {
+		status = bizuserinters.MakeStatusByCode(bizuserinters.StatusCodeNoDataError)
+
+		return
+	}
+
+	revoked, err := impl.isEntryRevoked(ctx, entry)
+	if err != nil {
+		status = bizuserinters.MakeStatusByError(bizuserinters.StatusCodeInternalError, err)
+
+		return
+	}
+
+	if revoked {
+		status = bizuserinters.MakeStatusByCode(bizuserinters.StatusCodeExpiredError)
+
+		return
+	}
+
+	status = bizuserinters.MakeSuccessStatus()
+
+	return
+}
+
+// checkSSOParent liveness comes from the revocation entries only, the session registry is informational.
+func (impl *userTokenManagerImpl) checkSSOParent(ctx context.Context, parent *tokenEntry) bizuserinters.Status {
+	revoked, err := impl.isEntryRevoked(ctx, parent)
+	if err != nil {
+		return bizuserinters.MakeStatusByError(bizuserinters.StatusCodeInternalError, err)
+	}
+
+	if revoked {
+		return bizuserinters.MakeStatusByCode(bizuserinters.StatusCodeExpiredError)
+	}
+
+	return bizuserinters.MakeSuccessStatus()
+}
+
+func (impl *userTokenManagerImpl) isEntryRevoked(ctx context.Context, entry *tokenEntry) (bool, error) {
+	n, err := impl.redisCli.Exists(ctx, impl.key(keyPrefixSessionRevoked, entry.UserInfo.SessionID)).Result()
+	if err != nil || n > 0 {
+		return n > 0, err
+	}
+
+	generation, err := impl.userGeneration(ctx, entry.UserInfo.ID)
+	if err != nil {
+		return false, err
+	}
+
+	return entry.Generation < generation, nil
+}
+
+// revokeSession expireAt is when the last refresh token of the session expires.
+func (impl *userTokenManagerImpl) revokeSession(ctx context.Context, userID uint64, sessionID string, expireAt time.Time) {
+	err := impl.redisCli.Set(ctx, impl.key(keyPrefixSessionRevoked, sessionID), time.Now().Unix(),
+		ttlUntil(expireAt)).Err()
+	if err != nil {
+		impl.logger.WithFields(l.ErrorField(err), l.StringField("sessionID", sessionID)).Error("revoke session failed")
+	}
+
+	_ = impl.sessionStorage.DeleteSession(ctx, userID, sessionID)
+}
+
+func (impl *userTokenManagerImpl) userGeneration(ctx context.Context, userID uint64) (generation int64, err error) {
+	generation, err = impl.redisCli.Get(ctx, impl.userGenerationKey(userID)).Int64()
+	if err == redis.Nil {
+		err = nil
+	}
+
+	return
+}
+
+func (impl *userTokenManagerImpl) userGenerationKey(userID uint64) string {
+	return impl.key(keyPrefixUserGeneration, strconv.FormatUint(userID, 10))
+}
+
+func (impl *userTokenManagerImpl) key(prefix, s string) string {
+	return impl.keyPrefix + prefix + s
+}
+
+// tokenKey keys never hold the token itself, a dump of redis must not hand out tokens.
+func (impl *userTokenManagerImpl) tokenKey(prefix, token string) string {
+	return impl.key(prefix, hashToken(token))
+}
+
+func hashToken(token string) string {
+	h := sha256.Sum256([]byte(token))
+
+	return hex.EncodeToString(h[:])
+}
+
+// sealKey must differ from hashToken, keys carry that hash.
+func sealKey(token string) []byte {
+	h := sha256.Sum256([]byte("seal:" + token))
+
+	return h[:]
+}
+
+func sealWithToken(token string, d []byte) ([]byte, error) {
+	aead, err := newTokenAEAD(token)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+
+	_, err = rand.Read(nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	return aead.Seal(nonce, nonce, d, nil), nil
+}
+
+func openWithToken(token string, sealed []byte) ([]byte, error) {
+	aead, err := newTokenAEAD(token)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(sealed) < aead.NonceSize() {
+		return nil, ErrRenewPending
+	}
+
+	return aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], nil)
+}
+
+func newTokenAEAD(token string) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(sealKey(token))
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+func ttlUntil(expireAt time.Time) time.Duration {
+	d := time.Until(expireAt)
+	if d < time.Second {
+		d = time.Second
+	}
+
+	return d
+}
@@ -0,0 +1,193 @@
+package redis
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/s-min-sys/userbe/internal/usertokenmanager"
+	"github.com/s-min-sys/userbe/internal/usertokenmanager/usertokenmanagerinters"
+	"github.com/sbasestarter/bizuserlib/bizuserinters"
+)
+
+func newTestUserTokenManager(t *testing.T) (usertokenmanagerinters.UserTokenManager, *miniredis.Miniredis) {
+	t.Helper()
+
+	mr := miniredis.RunT(t)
+
+	m := NewRedisUserTokenManager(redis.NewClient(&redis.Options{Addr: mr.Addr()}), "t:",
+		usertokenmanager.NewMemorySessionStorage(), UserTokenManagerConfigs{}, nil)
+
+	return m, mr
+}
+
+func TestRedisUserToken(t *testing.T) {
+	ctx := context.Background()
+	m, mr := newTestUserTokenManager(t)
+
+	token, status := m.GenToken(ctx, &usertokenmanagerinters.UserTokenInfo{ID: 1, UserName: "u"})
+	if status.Code != bizuserinters.StatusCodeOk {
+		t.Fatal(status)
+	}
+
+	for _, key := range mr.Keys() {
+		if strings.Contains(key, token.AccessToken) || strings.Contains(key, token.RefreshToken) {
+			t.Fatal("token in redis key", key)
+		}
+	}
+
+	userInfo, status := m.ExplainToken(ctx, token.AccessToken)
+	if status.Code != bizuserinters.StatusCodeOk || userInfo.ID != 1 || userInfo.UserName != "u" {
+		t.Fatal(status, userInfo)
+	}
+
+	if _, status = m.ExplainToken(ctx, token.RefreshToken); status.Code == bizuserinters.StatusCodeOk {
+		t.Fatal("refresh token must not be accepted as access token")
+	}
+
+	if status = m.DeleteToken(ctx, token.AccessToken); status.Code != bizuserinters.StatusCodeOk {
+		t.Fatal(status)
+	}
+
+	if _, status = m.ExplainToken(ctx, token.AccessToken); status.Code == bizuserinters.StatusCodeOk {
+		t.Fatal("deleted token must be rejected")
+	}
+
+	if _, _, status = m.RenewToken(ctx, token.RefreshToken); status.Code == bizuserinters.StatusCodeOk {
+		t.Fatal("session of a deleted token must not renew")
+	}
+}
+
+func TestRedisRenewToken(t *testing.T) {
+	ctx := context.Background()
+	m, mr := newTestUserTokenManager(t)
+
+	token, _ := m.GenToken(ctx, &usertokenmanagerinters.UserTokenInfo{ID: 1})
+
+	newToken, _, status := m.RenewToken(ctx, token.RefreshToken)
+	if status.Code != bizuserinters.StatusCodeOk {
+		t.Fatal(status)
+	}
+
+	// a retry within the grace period gets the same pair, the old access token is still readable
+	retried, _, status := m.RenewToken(ctx, token.RefreshToken)
+	if status.Code != bizuserinters.StatusCodeOk {
+		t.Fatal("retry within grace must renew", status)
+	}
+
+	if retried.AccessToken != newToken.AccessToken || retried.RefreshToken != newToken.RefreshToken {
+		t.Fatal("retry within grace must return the issued pair")
+	}
+
+	for _, key := range mr.Keys() {
+		if v, _ := mr.Get(key); strings.Contains(v, newToken.RefreshToken) || strings.Contains(v, newToken.AccessToken) {
+			t.Fatal("tokens must not be stored in clear", key)
+		}
+	}
+
+	if _, status = m.ExplainToken(ctx, token.AccessToken); status.Code != bizuserinters.StatusCodeOk {
+		t.Fatal("old access token must be readable within grace", status)
+	}
+
+	mr.FastForward(renewGrace + time.Second)
+
+	if _, status = m.ExplainToken(ctx, token.AccessToken); status.Code == bizuserinters.StatusCodeOk {
+		t.Fatal("old access token must expire after grace")
+	}
+
+	if _, status = m.ExplainToken(ctx, newToken.AccessToken); status.Code != bizuserinters.StatusCodeOk {
+		t.Fatal(status)
+	}
+
+	// replayed after the grace period, the whole session is revoked
+	if _, _, status = m.RenewToken(ctx, token.RefreshToken); status.Code != bizuserinters.StatusCodeExpiredError {
+		t.Fatal("replay must be detected", status)
+	}
+
+	if _, status = m.ExplainToken(ctx, newToken.AccessToken); status.Code == bizuserinters.StatusCodeOk {
+		t.Fatal("session must be revoked after replay")
+	}
+
+	if _, _, status = m.RenewToken(ctx, newToken.RefreshToken); status.Code == bizuserinters.StatusCodeOk {
+		t.Fatal("session must be revoked after replay")
+	}
+}
+
+func TestRedisSSOToken(t *testing.T) {
+	ctx := context.Background()
+	m, mr := newTestUserTokenManager(t)
+
+	token, _ := m.GenToken(ctx, &usertokenmanagerinters.UserTokenInfo{ID: 1})
+
+	options := &usertokenmanagerinters.SSOTokenOptions{
+		Audience: "a.com",
+		ClientID: "c",
+		Scopes:   []string{"profile"},
+	}
+
+	ssoToken, status := m.GenSSOToken(ctx, token.AccessToken, options)
+	if status.Code != bizuserinters.StatusCodeOk {
+		t.Fatal(status)
+	}
+
+	for _, key := range mr.Keys() {
+		if v, err := mr.Get(key); err == nil && strings.Contains(v, token.AccessToken) {
+			t.Fatal("parent token stored in", key)
+		}
+	}
+
+	if _, status = m.ExplainSSOToken(ctx, ssoToken, "b.com", "c"); status.Code != bizuserinters.StatusCodePermissionError {
+		t.Fatal("other audience must be refused", status)
+	}
+
+	ssoTokenInfo, status := m.ExplainSSOToken(ctx, ssoToken, "a.com", "c")
+	if status.Code != bizuserinters.StatusCodeOk || ssoTokenInfo.UserTokenInfo.ID != 1 || len(ssoTokenInfo.Scopes) != 1 {
+		t.Fatal(status, ssoTokenInfo)
+	}
+
+	if _, status = m.ExplainSSOToken(ctx, ssoToken, "a.com", "c"); status.Code == bizuserinters.StatusCodeOk {
+		t.Fatal("sso token must be used once")
+	}
+
+	ssoToken, _ = m.GenSSOToken(ctx, token.AccessToken, options)
+
+	m.RevokeSession(ctx, 1, ssoTokenInfo.UserTokenInfo.SessionID)
+
+	if _, status = m.ExplainSSOToken(ctx, ssoToken, "a.com", "c"); status.Code == bizuserinters.StatusCodeOk {
+		t.Fatal("sso token of a revoked session must be rejected")
+	}
+}
+
+func TestRedisStepUpToken(t *testing.T) {
+	ctx := context.Background()
+	m, _ := newTestUserTokenManager(t)
+
+	token, _ := m.GenToken(ctx, &usertokenmanagerinters.UserTokenInfo{ID: 1})
+	userInfo, _ := m.ExplainToken(ctx, token.AccessToken)
+
+	stepUpToken, status := m.GenStepUpToken(ctx, userInfo, time.Minute)
+	if status.Code != bizuserinters.StatusCodeOk {
+		t.Fatal(status)
+	}
+
+	if _, status = m.ExplainToken(ctx, stepUpToken); status.Code == bizuserinters.StatusCodeOk {
+		t.Fatal("step-up token must not be accepted as access token")
+	}
+
+	if _, status = m.ExplainStepUpToken(ctx, token.AccessToken); status.Code == bizuserinters.StatusCodeOk {
+		t.Fatal("access token must not be accepted as step-up token")
+	}
+
+	if _, status = m.ExplainStepUpToken(ctx, stepUpToken); status.Code != bizuserinters.StatusCodeOk {
+		t.Fatal(status)
+	}
+
+	m.RevokeUserTokens(ctx, 1)
+
+	if _, status = m.ExplainStepUpToken(ctx, stepUpToken); status.Code == bizuserinters.StatusCodeOk {
+		t.Fatal("step-up token of a revoked user must be rejected")
+	}
+}
@@ -0,0 +1,47 @@
+package sqlstorage
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+type Dialect string
+
+const (
+	DialectPostgres Dialect = "postgres"
+	DialectSQLite   Dialect = "sqlite3"
+)
+
+var (
+	ErrUnknownDialect = errors.New("unknown sql dialect")
+)
+
+func (d Dialect) valid() bool {
+	return d == DialectPostgres || d == DialectSQLite
+}
+
+// rebind queries are written with ?, postgres wants $n.
+func (d Dialect) rebind(query string) string {
+	if d != DialectPostgres {
+		return query
+	}
+
+	var sb strings.Builder
+
+	n := 0
+
+	for _, c := range query {
+		if c != '?' {
+			sb.WriteRune(c)
+
+			continue
+		}
+
+		n++
+
+		sb.WriteString("$" + strconv.Itoa(n))
+	}
+
+	return sb.String()
+}
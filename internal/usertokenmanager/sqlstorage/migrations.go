@@ -0,0 +1,118 @@
+package sqlstorage
+
+import (
+	"context"
+	"database/sql"
+)
+
+// migrations are applied in order and never edited once released, append new ones.
+var migrations = []string{
+	`CREATE TABLE IF NOT EXISTS user_token_records (
+		k VARCHAR(255) PRIMARY KEY,
+		expire_at BIGINT NOT NULL
+	)`,
+	`CREATE INDEX IF NOT EXISTS idx_user_token_records_expire_at ON user_token_records (expire_at)`,
+	`CREATE TABLE IF NOT EXISTS user_token_counters (
+		k VARCHAR(255) PRIMARY KEY,
+		n BIGINT NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS user_sessions (
+		user_id BIGINT NOT NULL,
+		id VARCHAR(64) NOT NULL,
+		data TEXT NOT NULL,
+		expire_at BIGINT NOT NULL,
+		PRIMARY KEY (user_id, id)
+	)`,
+	`CREATE INDEX IF NOT EXISTS idx_user_sessions_expire_at ON user_sessions (expire_at)`,
+	`CREATE TABLE IF NOT EXISTS oauth_tokens (
+		k VARCHAR(255) PRIMARY KEY,
+		data TEXT NOT NULL,
+		expire_at BIGINT NOT NULL
+	)`,
+	`CREATE INDEX IF NOT EXISTS idx_oauth_tokens_expire_at ON oauth_tokens (expire_at)`,
+	`CREATE TABLE IF NOT EXISTS oauth_clients (
+		id VARCHAR(255) PRIMARY KEY,
+		data TEXT NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS oauth_grants (
+		user_id BIGINT NOT NULL,
+		client_id VARCHAR(255) NOT NULL,
+		data TEXT NOT NULL,
+		PRIMARY KEY (user_id, client_id)
+	)`,
+	`CREATE TABLE IF NOT EXISTS biz_flows (
+		biz_id VARCHAR(64) NOT NULL,
+		k VARCHAR(64) NOT NULL,
+		v VARCHAR(64) NOT NULL,
+		expire_at BIGINT NOT NULL,
+		PRIMARY KEY (biz_id, k)
+	)`,
+	`CREATE INDEX IF NOT EXISTS idx_biz_flows_expire_at ON biz_flows (expire_at)`,
+	`CREATE TABLE IF NOT EXISTS users (
+		id BIGINT PRIMARY KEY,
+		user_name VARCHAR(255) NOT NULL UNIQUE,
+		password VARCHAR(255) NOT NULL,
+		google_2fa_secret_key VARCHAR(255) NOT NULL DEFAULT '',
+		admin BOOLEAN NOT NULL DEFAULT FALSE,
+		created_at BIGINT NOT NULL
+	)`,
+	`ALTER TABLE oauth_tokens ADD COLUMN user_id VARCHAR(64) NOT NULL DEFAULT ''`,
+	`ALTER TABLE oauth_tokens ADD COLUMN client_id VARCHAR(255) NOT NULL DEFAULT ''`,
+	`CREATE INDEX IF NOT EXISTS idx_oauth_tokens_user_client ON oauth_tokens (user_id, client_id)`,
+}
+
+// migrationLockID keys the postgres advisory lock taken while migrating.
+const migrationLockID = 0x75736572626531
+
+// Migrate brings the schema up to date, the applied version is kept in user_token_schema. Replicas may start
+// together, on postgres they wait for each other on an advisory lock.
+func Migrate(ctx context.Context, db *sql.DB, dialect Dialect) error {
+	if !dialect.valid() {
+		return ErrUnknownDialect
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	if dialect == DialectPostgres {
+		_, err = tx.ExecContext(ctx, dialect.rebind(`SELECT pg_advisory_xact_lock(?)`), migrationLockID)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS user_token_schema (version INT NOT NULL)`)
+	if err != nil {
+		return err
+	}
+
+	var version int
+
+	err = tx.QueryRowContext(ctx, `SELECT version FROM user_token_schema`).Scan(&version)
+	if err == sql.ErrNoRows {
+		_, err = tx.ExecContext(ctx, `INSERT INTO user_token_schema (version) VALUES (0)`)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	for ; version < len(migrations); version++ {
+		if _, err = tx.ExecContext(ctx, migrations[version]); err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, dialect.rebind(`UPDATE user_token_schema SET version = ?`), version)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
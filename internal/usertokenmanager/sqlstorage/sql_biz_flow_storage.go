@@ -0,0 +1,159 @@
+package sqlstorage
+
+import (
+	"context"
+	"database/sql"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/s-min-sys/userbe/internal/usertokenmanager/usertokenmanagerinters"
+	"github.com/sgostarter/i/l"
+)
+
+const (
+	bizFlowKeyUserID           = "uid"
+	bizFlowKeyAuthMethodPrefix = "amr:"
+)
+
+// NewSQLBizFlowStorage the schema must have been migrated by Migrate. A flow is one row per value, every write
+// restarts the expiration of the whole flow. Expired flows are deleted every sweepInterval until ctx is done.
+func NewSQLBizFlowStorage(ctx context.Context, db *sql.DB, dialect Dialect, sweepInterval time.Duration,
+	logger l.Wrapper) usertokenmanagerinters.BizFlowStorage {
+	if logger == nil {
+		logger = l.NewNopLoggerWrapper()
+	}
+
+	if db == nil || !dialect.valid() {
+		logger.Error("no db or unknown dialect")
+
+		return nil
+	}
+
+	if sweepInterval <= 0 {
+		sweepInterval = defaultSweepInterval
+	}
+
+	impl := &bizFlowStorageImpl{
+		db:      db,
+		dialect: dialect,
+		logger:  logger.WithFields(l.StringField(l.ClsKey, "bizFlowStorageImpl")),
+	}
+
+	go impl.sweepRoutine(ctx, sweepInterval)
+
+	return impl
+}
+
+type bizFlowStorageImpl struct {
+	db      *sql.DB
+	dialect Dialect
+	logger  l.Wrapper
+}
+
+func (impl *bizFlowStorageImpl) AddAuthMethod(ctx context.Context, bizID, authMethod string) error {
+	return impl.set(ctx, bizID, bizFlowKeyAuthMethodPrefix+authMethod, "1")
+}
+
+func (impl *bizFlowStorageImpl) SetUserID(ctx context.Context, bizID string, userID uint64) error {
+	return impl.set(ctx, bizID, bizFlowKeyUserID, strconv.FormatUint(userID, 10))
+}
+
+func (impl *bizFlowStorageImpl) GetFlow(ctx context.Context, bizID string) (*usertokenmanagerinters.BizFlow, error) {
+	rows, err := impl.db.QueryContext(ctx, impl.dialect.rebind(
+		`SELECT k, v FROM biz_flows WHERE biz_id = ? AND expire_at > ?`), bizID, time.Now().UnixMilli())
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	var flow *usertokenmanagerinters.BizFlow
+
+	for rows.Next() {
+		var k, v string
+
+		if err = rows.Scan(&k, &v); err != nil {
+			return nil, err
+		}
+
+		if flow == nil {
+			flow = &usertokenmanagerinters.BizFlow{}
+		}
+
+		if k == bizFlowKeyUserID {
+			flow.UserID, _ = strconv.ParseUint(v, 10, 64)
+
+			continue
+		}
+
+		if strings.HasPrefix(k, bizFlowKeyAuthMethodPrefix) {
+			flow.AuthMethods = append(flow.AuthMethods, strings.TrimPrefix(k, bizFlowKeyAuthMethodPrefix))
+		}
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return flow, nil
+}
+
+func (impl *bizFlowStorageImpl) DeleteFlow(ctx context.Context, bizID string) error {
+	_, err := impl.db.ExecContext(ctx, impl.dialect.rebind(`DELETE FROM biz_flows WHERE biz_id = ?`), bizID)
+
+	return err
+}
+
+//
+//
+//
+
+func (impl *bizFlowStorageImpl) set(ctx context.Context, bizID, k, v string) error {
+	expireAt := expireAt(usertokenmanagerinters.BizFlowExpiration)
+
+	tx, err := impl.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	_, err = tx.ExecContext(ctx, impl.dialect.rebind(
+		`INSERT INTO biz_flows (biz_id, k, v, expire_at) VALUES (?, ?, ?, ?)
+		ON CONFLICT (biz_id, k) DO UPDATE SET v = excluded.v, expire_at = excluded.expire_at`),
+		bizID, k, v, expireAt)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, impl.dialect.rebind(`UPDATE biz_flows SET expire_at = ? WHERE biz_id = ?`),
+		expireAt, bizID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (impl *bizFlowStorageImpl) sweepRoutine(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			_, err := impl.db.ExecContext(ctx, impl.dialect.rebind(`DELETE FROM biz_flows WHERE expire_at <= ?`),
+				time.Now().UnixMilli())
+			if err != nil {
+				impl.logger.WithFields(l.ErrorField(err)).Error("sweep expired biz flows failed")
+			}
+		}
+	}
+}
@@ -0,0 +1,40 @@
+package sqlstorage
+
+import (
+	"context"
+	"testing"
+)
+
+func TestSQLBizFlowStorage(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	s := NewSQLBizFlowStorage(ctx, newTestDB(t), DialectSQLite, 0, nil)
+
+	if flow, err := s.GetFlow(ctx, "b"); err != nil || flow != nil {
+		t.Fatal("unknown flow must be missing", flow, err)
+	}
+
+	for _, m := range []string{"userpass", "google2fa", "userpass"} {
+		if err := s.AddAuthMethod(ctx, "b", m); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := s.SetUserID(ctx, "b", 1); err != nil {
+		t.Fatal(err)
+	}
+
+	flow, err := s.GetFlow(ctx, "b")
+	if err != nil || flow == nil || flow.UserID != 1 || len(flow.AuthMethods) != 2 {
+		t.Fatal(flow, err)
+	}
+
+	if err = s.DeleteFlow(ctx, "b"); err != nil {
+		t.Fatal(err)
+	}
+
+	if flow, err = s.GetFlow(ctx, "b"); err != nil || flow != nil {
+		t.Fatal("deleted flow must be missing", flow, err)
+	}
+}
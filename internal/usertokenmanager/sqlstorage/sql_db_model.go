@@ -0,0 +1,220 @@
+package sqlstorage
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/sbasestarter/bizuserlib/bizuserinters"
+	authenticatorinters "github.com/sbasestarter/bizuserlib/bizuserinters/model/authenticator"
+	"github.com/sgostarter/i/commerr"
+	"github.com/sgostarter/i/l"
+)
+
+// userIDCounterKey user ids come from user_token_counters, sqlite and postgres generate keys differently.
+const userIDCounterKey = "users:id"
+
+var _ authenticatorinters.DBModel = (*dbModelImpl)(nil)
+
+// NewSQLDBModel keeps the bizuserlib users in the users table, the schema must have been migrated by Migrate.
+// Unknown users are commerr.ErrNotFound, a taken user name is commerr.ErrAlreadyExists.
+// Passwords are stored as bizuserlib hands them over, see docs/protorepo.md before relying on it.
+func NewSQLDBModel(db *sql.DB, dialect Dialect, logger l.Wrapper) authenticatorinters.DBModel {
+	if logger == nil {
+		logger = l.NewNopLoggerWrapper()
+	}
+
+	if db == nil || !dialect.valid() {
+		logger.Error("no db or unknown dialect")
+
+		return nil
+	}
+
+	return &dbModelImpl{
+		db:      db,
+		dialect: dialect,
+		logger:  logger.WithFields(l.StringField(l.ClsKey, "dbModelImpl")),
+	}
+}
+
+type dbModelImpl struct {
+	db      *sql.DB
+	dialect Dialect
+	logger  l.Wrapper
+}
+
+func (impl *dbModelImpl) AddUser(ctx context.Context, userName, password string) (userID uint64, err error) {
+	tx, err := impl.db.BeginTx(ctx, nil)
+	if err != nil {
+		return
+	}
+
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	var n int
+
+	err = tx.QueryRowContext(ctx, impl.dialect.rebind(`SELECT COUNT(1) FROM users WHERE user_name = ?`), userName).Scan(&n)
+	if err != nil {
+		return
+	}
+
+	if n > 0 {
+		err = commerr.ErrAlreadyExists
+
+		return
+	}
+
+	var id int64
+
+	err = tx.QueryRowContext(ctx, impl.dialect.rebind(
+		`INSERT INTO user_token_counters (k, n) VALUES (?, 1)
+		ON CONFLICT (k) DO UPDATE SET n = user_token_counters.n + 1 RETURNING n`), userIDCounterKey).Scan(&id)
+	if err != nil {
+		return
+	}
+
+	_, err = tx.ExecContext(ctx, impl.dialect.rebind(
+		`INSERT INTO users (id, user_name, password, created_at) VALUES (?, ?, ?, ?)`),
+		id, userName, password, time.Now().UnixMilli())
+	if err != nil {
+		return
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return
+	}
+
+	userID = uint64(id)
+
+	return
+}
+
+func (impl *dbModelImpl) GetUser(ctx context.Context, userID uint64) (*bizuserinters.UserInfo, error) {
+	row := impl.db.QueryRowContext(ctx, impl.dialect.rebind(
+		`SELECT id, user_name, google_2fa_secret_key, admin FROM users WHERE id = ?`), int64(userID))
+
+	userInfo, err := scanUserInfo(row)
+	if err == sql.ErrNoRows {
+		err = commerr.ErrNotFound
+	}
+
+	return userInfo, err
+}
+
+func (impl *dbModelImpl) GetUserByUserName(ctx context.Context, userName string) (userID uint64, password string, err error) {
+	var id int64
+
+	err = impl.db.QueryRowContext(ctx, impl.dialect.rebind(`SELECT id, password FROM users WHERE user_name = ?`),
+		userName).Scan(&id, &password)
+	if err == sql.ErrNoRows {
+		err = commerr.ErrNotFound
+	}
+
+	if err != nil {
+		return
+	}
+
+	userID = uint64(id)
+
+	return
+}
+
+func (impl *dbModelImpl) ListUsers(ctx context.Context) (users []*bizuserinters.UserInfo, err error) {
+	rows, err := impl.db.QueryContext(ctx, `SELECT id, user_name, google_2fa_secret_key, admin FROM users ORDER BY id`)
+	if err != nil {
+		return
+	}
+
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	for rows.Next() {
+		var userInfo *bizuserinters.UserInfo
+
+		userInfo, err = scanUserInfo(rows)
+		if err != nil {
+			return
+		}
+
+		users = append(users, userInfo)
+	}
+
+	err = rows.Err()
+
+	return
+}
+
+func (impl *dbModelImpl) DeleteUser(ctx context.Context, userID uint64) error {
+	return impl.update(ctx, `DELETE FROM users WHERE id = ?`, int64(userID))
+}
+
+func (impl *dbModelImpl) UpdateUserPassword(ctx context.Context, userID uint64, password string) error {
+	return impl.update(ctx, `UPDATE users SET password = ? WHERE id = ?`, password, int64(userID))
+}
+
+func (impl *dbModelImpl) GetUserGoogle2FASecretKey(ctx context.Context, userID uint64) (secretKey string, err error) {
+	err = impl.db.QueryRowContext(ctx, impl.dialect.rebind(`SELECT google_2fa_secret_key FROM users WHERE id = ?`),
+		int64(userID)).Scan(&secretKey)
+	if err == sql.ErrNoRows {
+		err = commerr.ErrNotFound
+	}
+
+	return
+}
+
+func (impl *dbModelImpl) UpdateUserGoogle2FASecretKey(ctx context.Context, userID uint64, secretKey string) error {
+	return impl.update(ctx, `UPDATE users SET google_2fa_secret_key = ? WHERE id = ?`, secretKey, int64(userID))
+}
+
+func (impl *dbModelImpl) UpdateUserAdminFlag(ctx context.Context, userID uint64, admin bool) error {
+	return impl.update(ctx, `UPDATE users SET admin = ? WHERE id = ?`, admin, int64(userID))
+}
+
+//
+//
+//
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// update a statement touching no user means the user doesn't exist.
+func (impl *dbModelImpl) update(ctx context.Context, query string, args ...interface{}) error {
+	result, err := impl.db.ExecContext(ctx, impl.dialect.rebind(query), args...)
+	if err != nil {
+		return err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if n == 0 {
+		return commerr.ErrNotFound
+	}
+
+	return nil
+}
+
+func scanUserInfo(row rowScanner) (*bizuserinters.UserInfo, error) {
+	var (
+		id              int64
+		google2FASecret string
+		userInfo        bizuserinters.UserInfo
+	)
+
+	err := row.Scan(&id, &userInfo.UserName, &google2FASecret, &userInfo.Admin)
+	if err != nil {
+		return nil, err
+	}
+
+	userInfo.ID = uint64(id)
+	userInfo.HasGoogle2FA = google2FASecret != ""
+
+	return &userInfo, nil
+}
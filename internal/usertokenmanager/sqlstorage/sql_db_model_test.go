@@ -0,0 +1,64 @@
+package sqlstorage
+
+import (
+	"context"
+	"testing"
+
+	"github.com/sgostarter/i/commerr"
+)
+
+func TestSQLDBModel(t *testing.T) {
+	ctx := context.Background()
+	m := NewSQLDBModel(newTestDB(t), DialectSQLite, nil)
+
+	userID, err := m.AddUser(ctx, "u", "p")
+	if err != nil || userID == 0 {
+		t.Fatal(userID, err)
+	}
+
+	if _, err = m.AddUser(ctx, "u", "p"); err != commerr.ErrAlreadyExists {
+		t.Fatal("user name must be unique", err)
+	}
+
+	otherID, _ := m.AddUser(ctx, "o", "p")
+	if otherID == userID {
+		t.Fatal("user ids must differ")
+	}
+
+	if id, password, err := m.GetUserByUserName(ctx, "u"); err != nil || id != userID || password != "p" {
+		t.Fatal(id, password, err)
+	}
+
+	if err = m.UpdateUserPassword(ctx, userID, "p2"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = m.UpdateUserGoogle2FASecretKey(ctx, userID, "s"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = m.UpdateUserAdminFlag(ctx, userID, true); err != nil {
+		t.Fatal(err)
+	}
+
+	userInfo, err := m.GetUser(ctx, userID)
+	if err != nil || userInfo.UserName != "u" || !userInfo.HasGoogle2FA || !userInfo.Admin {
+		t.Fatal(userInfo, err)
+	}
+
+	if users, err := m.ListUsers(ctx); err != nil || len(users) != 2 {
+		t.Fatal(users, err)
+	}
+
+	if err = m.DeleteUser(ctx, userID); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = m.GetUser(ctx, userID); err != commerr.ErrNotFound {
+		t.Fatal("deleted user must be missing", err)
+	}
+
+	if err = m.UpdateUserPassword(ctx, userID, "p"); err != commerr.ErrNotFound {
+		t.Fatal("deleted user must be missing", err)
+	}
+}
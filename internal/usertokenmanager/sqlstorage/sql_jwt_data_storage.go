@@ -0,0 +1,148 @@
+package sqlstorage
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/s-min-sys/userbe/internal/usertokenmanager/usertokenmanagerinters"
+	"github.com/sgostarter/i/l"
+)
+
+const (
+	defaultSweepInterval = time.Minute
+)
+
+// NewSQLJWTDataStorage the schema must have been migrated by Migrate. Expired records are deleted every
+// sweepInterval until ctx is done, they are ignored by the queries before that.
+func NewSQLJWTDataStorage(ctx context.Context, db *sql.DB, dialect Dialect, sweepInterval time.Duration,
+	logger l.Wrapper) usertokenmanagerinters.JWTDataStorage {
+	if logger == nil {
+		logger = l.NewNopLoggerWrapper()
+	}
+
+	if db == nil || !dialect.valid() {
+		logger.Error("no db or unknown dialect")
+
+		return nil
+	}
+
+	if sweepInterval <= 0 {
+		sweepInterval = defaultSweepInterval
+	}
+
+	impl := &jwtDataStorageImpl{
+		db:      db,
+		dialect: dialect,
+		logger:  logger.WithFields(l.StringField(l.ClsKey, "jwtDataStorageImpl")),
+	}
+
+	go impl.sweepRoutine(ctx, sweepInterval)
+
+	return impl
+}
+
+type jwtDataStorageImpl struct {
+	db      *sql.DB
+	dialect Dialect
+	logger  l.Wrapper
+}
+
+func (impl *jwtDataStorageImpl) Record(ctx context.Context, key string, expiration time.Duration) error {
+	_, err := impl.db.ExecContext(ctx, impl.dialect.rebind(
+		`INSERT INTO user_token_records (k, expire_at) VALUES (?, ?)
+		ON CONFLICT (k) DO UPDATE SET expire_at = excluded.expire_at`),
+		key, expireAt(expiration))
+
+	return err
+}
+
+func (impl *jwtDataStorageImpl) RecordIfNotExists(ctx context.Context, key string, expiration time.Duration) (recorded bool, err error) {
+	// an expired record not swept yet counts as missing
+	result, err := impl.db.ExecContext(ctx, impl.dialect.rebind(
+		`INSERT INTO user_token_records (k, expire_at) VALUES (?, ?)
+		ON CONFLICT (k) DO UPDATE SET expire_at = excluded.expire_at WHERE user_token_records.expire_at <= ?`),
+		key, expireAt(expiration), time.Now().UnixMilli())
+	if err != nil {
+		return
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return
+	}
+
+	recorded = n > 0
+
+	return
+}
+
+func (impl *jwtDataStorageImpl) Exists(ctx context.Context, keys ...string) (t bool, err error) {
+	if len(keys) == 0 {
+		return
+	}
+
+	args := make([]interface{}, 0, len(keys)+1)
+	for _, key := range keys {
+		args = append(args, key)
+	}
+
+	args = append(args, time.Now().UnixMilli())
+
+	var n int
+
+	err = impl.db.QueryRowContext(ctx, impl.dialect.rebind(
+		`SELECT COUNT(1) FROM user_token_records WHERE k IN (?`+strings.Repeat(", ?", len(keys)-1)+`) AND expire_at > ?`),
+		args...).Scan(&n)
+	if err != nil {
+		return
+	}
+
+	t = n > 0
+
+	return
+}
+
+func (impl *jwtDataStorageImpl) GetCounter(ctx context.Context, key string) (n int64, err error) {
+	err = impl.db.QueryRowContext(ctx, impl.dialect.rebind(`SELECT n FROM user_token_counters WHERE k = ?`), key).Scan(&n)
+	if err == sql.ErrNoRows {
+		err = nil
+	}
+
+	return
+}
+
+func (impl *jwtDataStorageImpl) IncCounter(ctx context.Context, key string) (n int64, err error) {
+	err = impl.db.QueryRowContext(ctx, impl.dialect.rebind(
+		`INSERT INTO user_token_counters (k, n) VALUES (?, 1)
+		ON CONFLICT (k) DO UPDATE SET n = user_token_counters.n + 1 RETURNING n`), key).Scan(&n)
+
+	return
+}
+
+//
+//
+//
+
+func (impl *jwtDataStorageImpl) sweepRoutine(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			_, err := impl.db.ExecContext(ctx, impl.dialect.rebind(`DELETE FROM user_token_records WHERE expire_at <= ?`),
+				time.Now().UnixMilli())
+			if err != nil {
+				impl.logger.WithFields(l.ErrorField(err)).Error("sweep expired records failed")
+			}
+		}
+	}
+}
+
+func expireAt(expiration time.Duration) int64 {
+	return time.Now().Add(expiration).UnixMilli()
+}
@@ -0,0 +1,92 @@
+package sqlstorage
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func newTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+
+	db, err := sql.Open(string(DialectSQLite), ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// every connection of :memory: is a database of its own
+	db.SetMaxOpenConns(1)
+
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+
+	if err = Migrate(context.Background(), db, DialectSQLite); err != nil {
+		t.Fatal(err)
+	}
+
+	// migrating twice is a no-op
+	if err = Migrate(context.Background(), db, DialectSQLite); err != nil {
+		t.Fatal(err)
+	}
+
+	return db
+}
+
+func TestSQLJWTDataStorage(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	storage := NewSQLJWTDataStorage(ctx, newTestDB(t), DialectSQLite, time.Hour, nil)
+
+	if err := storage.Record(ctx, "a", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := storage.Record(ctx, "expired", -time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	if exists, err := storage.Exists(ctx, "x", "a"); err != nil || !exists {
+		t.Fatal("record must exist", err)
+	}
+
+	if exists, err := storage.Exists(ctx, "x", "expired"); err != nil || exists {
+		t.Fatal("expired record must not exist", err)
+	}
+
+	if recorded, err := storage.RecordIfNotExists(ctx, "a", time.Hour); err != nil || recorded {
+		t.Fatal("existing record must not be recorded again", err)
+	}
+
+	if recorded, err := storage.RecordIfNotExists(ctx, "expired", time.Hour); err != nil || !recorded {
+		t.Fatal("expired record must be recorded again", err)
+	}
+
+	if n, err := storage.GetCounter(ctx, "c"); err != nil || n != 0 {
+		t.Fatal(n, err)
+	}
+
+	for i := int64(1); i <= 2; i++ {
+		if n, err := storage.IncCounter(ctx, "c"); err != nil || n != i {
+			t.Fatal(n, err)
+		}
+	}
+
+	if n, err := storage.GetCounter(ctx, "c"); err != nil || n != 2 {
+		t.Fatal(n, err)
+	}
+}
+
+func TestRebind(t *testing.T) {
+	if q := DialectPostgres.rebind("a = ? AND b IN (?, ?)"); q != "a = $1 AND b IN ($2, $3)" {
+		t.Fatal(q)
+	}
+
+	if q := DialectSQLite.rebind("a = ?"); q != "a = ?" {
+		t.Fatal(q)
+	}
+}
@@ -0,0 +1,78 @@
+package sqlstorage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+
+	"github.com/go-oauth2/oauth2/v4"
+	"github.com/go-oauth2/oauth2/v4/errors"
+	"github.com/go-oauth2/oauth2/v4/models"
+	"github.com/s-min-sys/userbe/internal/usertokenmanager/usertokenmanagerinters"
+	"github.com/sgostarter/i/l"
+)
+
+// NewSQLOAuthClientStore the schema must have been migrated by Migrate.
+func NewSQLOAuthClientStore(db *sql.DB, dialect Dialect, logger l.Wrapper) usertokenmanagerinters.OAuthClientStore {
+	if logger == nil {
+		logger = l.NewNopLoggerWrapper()
+	}
+
+	if db == nil || !dialect.valid() {
+		logger.Error("no db or unknown dialect")
+
+		return nil
+	}
+
+	return &oAuthClientStoreImpl{
+		db:      db,
+		dialect: dialect,
+		logger:  logger.WithFields(l.StringField(l.ClsKey, "oAuthClientStoreImpl")),
+	}
+}
+
+type oAuthClientStoreImpl struct {
+	db      *sql.DB
+	dialect Dialect
+	logger  l.Wrapper
+}
+
+func (impl *oAuthClientStoreImpl) GetByID(ctx context.Context, id string) (oauth2.ClientInfo, error) {
+	var d string
+
+	err := impl.db.QueryRowContext(ctx, impl.dialect.rebind(`SELECT data FROM oauth_clients WHERE id = ?`), id).Scan(&d)
+	if err == sql.ErrNoRows {
+		return nil, errors.ErrInvalidClient
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	var client models.Client
+
+	err = json.Unmarshal([]byte(d), &client)
+	if err != nil {
+		return nil, err
+	}
+
+	return &client, nil
+}
+
+func (impl *oAuthClientStoreImpl) Set(ctx context.Context, client oauth2.ClientInfo) error {
+	d, err := json.Marshal(&models.Client{
+		ID:     client.GetID(),
+		Secret: client.GetSecret(),
+		Domain: client.GetDomain(),
+		UserID: client.GetUserID(),
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = impl.db.ExecContext(ctx, impl.dialect.rebind(
+		`INSERT INTO oauth_clients (id, data) VALUES (?, ?) ON CONFLICT (id) DO UPDATE SET data = excluded.data`),
+		client.GetID(), string(d))
+
+	return err
+}
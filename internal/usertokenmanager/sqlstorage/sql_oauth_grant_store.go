@@ -0,0 +1,113 @@
+package sqlstorage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+
+	"github.com/s-min-sys/userbe/internal/usertokenmanager/usertokenmanagerinters"
+	"github.com/sgostarter/i/l"
+)
+
+// NewSQLOAuthGrantStore the schema must have been migrated by Migrate.
+func NewSQLOAuthGrantStore(db *sql.DB, dialect Dialect, logger l.Wrapper) usertokenmanagerinters.OAuthGrantStore {
+	if logger == nil {
+		logger = l.NewNopLoggerWrapper()
+	}
+
+	if db == nil || !dialect.valid() {
+		logger.Error("no db or unknown dialect")
+
+		return nil
+	}
+
+	return &oAuthGrantStoreImpl{
+		db:      db,
+		dialect: dialect,
+		logger:  logger.WithFields(l.StringField(l.ClsKey, "oAuthGrantStoreImpl")),
+	}
+}
+
+type oAuthGrantStoreImpl struct {
+	db      *sql.DB
+	dialect Dialect
+	logger  l.Wrapper
+}
+
+func (impl *oAuthGrantStoreImpl) SaveGrant(ctx context.Context, grant *usertokenmanagerinters.OAuthGrant) error {
+	d, err := json.Marshal(grant)
+	if err != nil {
+		return err
+	}
+
+	_, err = impl.db.ExecContext(ctx, impl.dialect.rebind(
+		`INSERT INTO oauth_grants (user_id, client_id, data) VALUES (?, ?, ?)
+		ON CONFLICT (user_id, client_id) DO UPDATE SET data = excluded.data`),
+		int64(grant.UserID), grant.ClientID, string(d))
+
+	return err
+}
+
+func (impl *oAuthGrantStoreImpl) GetGrant(ctx context.Context, userID uint64, clientID string) (*usertokenmanagerinters.OAuthGrant, error) {
+	var d string
+
+	err := impl.db.QueryRowContext(ctx, impl.dialect.rebind(
+		`SELECT data FROM oauth_grants WHERE user_id = ? AND client_id = ?`), int64(userID), clientID).Scan(&d)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			err = nil
+		}
+
+		return nil, err
+	}
+
+	var grant usertokenmanagerinters.OAuthGrant
+
+	err = json.Unmarshal([]byte(d), &grant)
+	if err != nil {
+		return nil, err
+	}
+
+	return &grant, nil
+}
+
+func (impl *oAuthGrantStoreImpl) ListGrants(ctx context.Context, userID uint64) ([]*usertokenmanagerinters.OAuthGrant, error) {
+	rows, err := impl.db.QueryContext(ctx, impl.dialect.rebind(`SELECT client_id, data FROM oauth_grants WHERE user_id = ?`),
+		int64(userID))
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	grants := make([]*usertokenmanagerinters.OAuthGrant, 0)
+
+	for rows.Next() {
+		var clientID, d string
+
+		if err = rows.Scan(&clientID, &d); err != nil {
+			return nil, err
+		}
+
+		var grant usertokenmanagerinters.OAuthGrant
+
+		if err = json.Unmarshal([]byte(d), &grant); err != nil {
+			impl.logger.WithFields(l.ErrorField(err), l.StringField("clientID", clientID)).Error("bad grant data")
+
+			continue
+		}
+
+		grants = append(grants, &grant)
+	}
+
+	return grants, rows.Err()
+}
+
+func (impl *oAuthGrantStoreImpl) DeleteGrant(ctx context.Context, userID uint64, clientID string) error {
+	_, err := impl.db.ExecContext(ctx, impl.dialect.rebind(`DELETE FROM oauth_grants WHERE user_id = ? AND client_id = ?`),
+		int64(userID), clientID)
+
+	return err
+}
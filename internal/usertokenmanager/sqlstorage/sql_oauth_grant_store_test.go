@@ -0,0 +1,47 @@
+package sqlstorage
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/s-min-sys/userbe/internal/usertokenmanager/usertokenmanagerinters"
+)
+
+func TestSQLOAuthGrantStore(t *testing.T) {
+	ctx := context.Background()
+
+	s := NewSQLOAuthGrantStore(newTestDB(t), DialectSQLite, nil)
+
+	if grant, err := s.GetGrant(ctx, 1, "c"); err != nil || grant != nil {
+		t.Fatal("unexpected grant", grant, err)
+	}
+
+	for _, scopes := range [][]string{{"openid"}, {"openid", "profile"}} {
+		err := s.SaveGrant(ctx, &usertokenmanagerinters.OAuthGrant{
+			UserID:    1,
+			ClientID:  "c",
+			Scopes:    scopes,
+			GrantedAt: time.Now(),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if grant, err := s.GetGrant(ctx, 1, "c"); err != nil || grant == nil || len(grant.Scopes) != 2 {
+		t.Fatal("grant not updated", grant, err)
+	}
+
+	if grants, err := s.ListGrants(ctx, 1); err != nil || len(grants) != 1 {
+		t.Fatal("unexpected grants", grants, err)
+	}
+
+	if err := s.DeleteGrant(ctx, 1, "c"); err != nil {
+		t.Fatal(err)
+	}
+
+	if grants, err := s.ListGrants(ctx, 1); err != nil || len(grants) != 0 {
+		t.Fatal("grant not deleted", grants, err)
+	}
+}
@@ -0,0 +1,202 @@
+package sqlstorage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/go-oauth2/oauth2/v4"
+	"github.com/s-min-sys/userbe/internal/usertokenmanager/usertokenmanagerinters"
+	"github.com/sgostarter/i/l"
+)
+
+const (
+	keyPrefixOAuthCode    = "oc:"
+	keyPrefixOAuthAccess  = "oa:"
+	keyPrefixOAuthRefresh = "or:"
+
+	neverExpireAt = math.MaxInt64
+)
+
+// NewSQLOAuthTokenStore the schema must have been migrated by Migrate. Expired tokens are deleted every
+// sweepInterval until ctx is done.
+func NewSQLOAuthTokenStore(ctx context.Context, db *sql.DB, dialect Dialect, sweepInterval time.Duration,
+	logger l.Wrapper) usertokenmanagerinters.OAuthTokenStore {
+	if logger == nil {
+		logger = l.NewNopLoggerWrapper()
+	}
+
+	if db == nil || !dialect.valid() {
+		logger.Error("no db or unknown dialect")
+
+		return nil
+	}
+
+	if sweepInterval <= 0 {
+		sweepInterval = defaultSweepInterval
+	}
+
+	impl := &oAuthTokenStoreImpl{
+		db:      db,
+		dialect: dialect,
+		logger:  logger.WithFields(l.StringField(l.ClsKey, "oAuthTokenStoreImpl")),
+	}
+
+	go impl.sweepRoutine(ctx, sweepInterval)
+
+	return impl
+}
+
+type oAuthTokenStoreImpl struct {
+	db      *sql.DB
+	dialect Dialect
+	logger  l.Wrapper
+}
+
+func (impl *oAuthTokenStoreImpl) Create(ctx context.Context, info oauth2.TokenInfo) error {
+	d, err := json.Marshal(info)
+	if err != nil {
+		return err
+	}
+
+	if code := info.GetCode(); code != "" {
+		return impl.set(ctx, impl.db, keyPrefixOAuthCode+code, info, d, expireAt(info.GetCodeExpiresIn()))
+	}
+
+	accessExpireAt := expireAt(info.GetAccessExpiresIn())
+
+	tx, err := impl.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	if refresh := info.GetRefresh(); refresh != "" {
+		// zero keeps the refresh token forever
+		var refreshExpireAt int64 = neverExpireAt
+
+		if info.GetRefreshExpiresIn() != 0 {
+			refreshExpireAt = info.GetRefreshCreateAt().Add(info.GetRefreshExpiresIn()).UnixMilli()
+
+			if accessExpireAt > refreshExpireAt {
+				accessExpireAt = refreshExpireAt
+			}
+		}
+
+		err = impl.set(ctx, tx, keyPrefixOAuthRefresh+refresh, info, d, refreshExpireAt)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = impl.set(ctx, tx, keyPrefixOAuthAccess+info.GetAccess(), info, d, accessExpireAt)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (impl *oAuthTokenStoreImpl) RemoveByCode(ctx context.Context, code string) error {
+	return impl.remove(ctx, keyPrefixOAuthCode+code)
+}
+
+func (impl *oAuthTokenStoreImpl) RemoveByAccess(ctx context.Context, access string) error {
+	return impl.remove(ctx, keyPrefixOAuthAccess+access)
+}
+
+func (impl *oAuthTokenStoreImpl) RemoveByRefresh(ctx context.Context, refresh string) error {
+	return impl.remove(ctx, keyPrefixOAuthRefresh+refresh)
+}
+
+func (impl *oAuthTokenStoreImpl) RemoveByUserClient(ctx context.Context, userID uint64, clientID string) error {
+	_, err := impl.db.ExecContext(ctx, impl.dialect.rebind(`DELETE FROM oauth_tokens WHERE user_id = ? AND client_id = ?`),
+		strconv.FormatUint(userID, 10), clientID)
+
+	return err
+}
+
+func (impl *oAuthTokenStoreImpl) GetByCode(ctx context.Context, code string) (oauth2.TokenInfo, error) {
+	return impl.get(ctx, keyPrefixOAuthCode+code)
+}
+
+func (impl *oAuthTokenStoreImpl) GetByAccess(ctx context.Context, access string) (oauth2.TokenInfo, error) {
+	return impl.get(ctx, keyPrefixOAuthAccess+access)
+}
+
+func (impl *oAuthTokenStoreImpl) GetByRefresh(ctx context.Context, refresh string) (oauth2.TokenInfo, error) {
+	return impl.get(ctx, keyPrefixOAuthRefresh+refresh)
+}
+
+//
+//
+//
+
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+func (impl *oAuthTokenStoreImpl) set(ctx context.Context, db execer, key string, info oauth2.TokenInfo, d []byte,
+	expireAt int64) error {
+	_, err := db.ExecContext(ctx, impl.dialect.rebind(
+		`INSERT INTO oauth_tokens (k, data, expire_at, user_id, client_id) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (k) DO UPDATE SET data = excluded.data, expire_at = excluded.expire_at,
+		user_id = excluded.user_id, client_id = excluded.client_id`),
+		key, string(d), expireAt, info.GetUserID(), info.GetClientID())
+
+	return err
+}
+
+func (impl *oAuthTokenStoreImpl) remove(ctx context.Context, key string) error {
+	_, err := impl.db.ExecContext(ctx, impl.dialect.rebind(`DELETE FROM oauth_tokens WHERE k = ?`), key)
+
+	return err
+}
+
+// get a missing token is not an error, go-oauth2 expects nil info then.
+func (impl *oAuthTokenStoreImpl) get(ctx context.Context, key string) (oauth2.TokenInfo, error) {
+	var d string
+
+	err := impl.db.QueryRowContext(ctx, impl.dialect.rebind(`SELECT data FROM oauth_tokens WHERE k = ? AND expire_at > ?`),
+		key, time.Now().UnixMilli()).Scan(&d)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	var token usertokenmanagerinters.OAuthTokenInfo
+
+	err = json.Unmarshal([]byte(d), &token)
+	if err != nil {
+		return nil, err
+	}
+
+	return &token, nil
+}
+
+func (impl *oAuthTokenStoreImpl) sweepRoutine(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			_, err := impl.db.ExecContext(ctx, impl.dialect.rebind(`DELETE FROM oauth_tokens WHERE expire_at <= ?`),
+				time.Now().UnixMilli())
+			if err != nil {
+				impl.logger.WithFields(l.ErrorField(err)).Error("sweep expired oauth tokens failed")
+			}
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package sqlstorage
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-oauth2/oauth2/v4/models"
+	"github.com/s-min-sys/userbe/internal/usertokenmanager/usertokenmanagerinters"
+)
+
+func TestSQLOAuthTokenStore(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	db := newTestDB(t)
+	s := NewSQLOAuthTokenStore(ctx, db, DialectSQLite, 0, nil)
+
+	now := time.Now()
+
+	err := s.Create(ctx, &models.Token{
+		ClientID:         "c",
+		UserID:           "u",
+		Access:           "a",
+		AccessCreateAt:   now,
+		AccessExpiresIn:  time.Hour,
+		Refresh:          "r",
+		RefreshCreateAt:  now,
+		RefreshExpiresIn: time.Hour * 24,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if token, err := s.GetByAccess(ctx, "a"); err != nil || token == nil || token.GetUserID() != "u" {
+		t.Fatal("access token not found", token, err)
+	}
+
+	if token, err := s.GetByRefresh(ctx, "r"); err != nil || token == nil || token.GetAccess() != "a" {
+		t.Fatal("refresh token not found", token, err)
+	}
+
+	if err = s.RemoveByAccess(ctx, "a"); err != nil {
+		t.Fatal(err)
+	}
+
+	if token, err := s.GetByAccess(ctx, "a"); err != nil || token != nil {
+		t.Fatal("removed access token must be missing", token, err)
+	}
+
+	err = s.Create(ctx, &models.Token{
+		Code:          "code",
+		CodeCreateAt:  now,
+		CodeExpiresIn: -time.Second,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if token, err := s.GetByCode(ctx, "code"); err != nil || token != nil {
+		t.Fatal("expired code must be missing", token, err)
+	}
+
+	err = s.Create(ctx, &usertokenmanagerinters.OAuthTokenInfo{
+		Token: models.Token{
+			Code:          "oidc",
+			CodeCreateAt:  now,
+			CodeExpiresIn: time.Minute,
+		},
+		OIDCLogin: &usertokenmanagerinters.OIDCLogin{Nonce: "n", AuthMethods: []string{"pwd"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token, err := s.GetByCode(ctx, "oidc")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if tokenInfo, ok := token.(*usertokenmanagerinters.OAuthTokenInfo); !ok || tokenInfo.OIDCLogin == nil ||
+		tokenInfo.OIDCLogin.Nonce != "n" || tokenInfo.GetCode() != "oidc" {
+		t.Fatal("code must keep the login", token)
+	}
+
+	clients := NewSQLOAuthClientStore(db, DialectSQLite, nil)
+
+	if err = clients.Set(ctx, &models.Client{ID: "c", Secret: "s"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if client, err := clients.GetByID(ctx, "c"); err != nil || client.GetSecret() != "s" {
+		t.Fatal("client not found", client, err)
+	}
+
+	if _, err = clients.GetByID(ctx, "x"); err == nil {
+		t.Fatal("unknown client must fail")
+	}
+}
+
+func TestSQLOAuthTokenStoreRemoveByUserClient(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	s := NewSQLOAuthTokenStore(ctx, newTestDB(t), DialectSQLite, 0, nil)
+
+	now := time.Now()
+
+	for _, token := range []*models.Token{
+		{ClientID: "c", UserID: "1", Code: "code", CodeCreateAt: now, CodeExpiresIn: time.Minute},
+		{ClientID: "c", UserID: "1", Access: "a1", AccessCreateAt: now, AccessExpiresIn: time.Hour, Refresh: "r1"},
+		{ClientID: "d", UserID: "1", Access: "a2", AccessCreateAt: now, AccessExpiresIn: time.Hour},
+		{ClientID: "c", UserID: "2", Access: "a3", AccessCreateAt: now, AccessExpiresIn: time.Hour},
+	} {
+		if err := s.Create(ctx, token); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := s.RemoveByUserClient(ctx, 1, "c"); err != nil {
+		t.Fatal(err)
+	}
+
+	if token, _ := s.GetByCode(ctx, "code"); token != nil {
+		t.Fatal("code of the client must be removed")
+	}
+
+	if token, _ := s.GetByAccess(ctx, "a1"); token != nil {
+		t.Fatal("access token of the client must be removed")
+	}
+
+	if token, _ := s.GetByRefresh(ctx, "r1"); token != nil {
+		t.Fatal("refresh token of the client must be removed")
+	}
+
+	for _, access := range []string{"a2", "a3"} {
+		if token, err := s.GetByAccess(ctx, access); err != nil || token == nil {
+			t.Fatal("tokens of other clients and users stay", access, err)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package sqlstorage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"github.com/s-min-sys/userbe/internal/usertokenmanager/usertokenmanagerinters"
+	"github.com/sgostarter/i/l"
+)
+
+// NewSQLSessionStorage the schema must have been migrated by Migrate, expired sessions are deleted when listed.
+func NewSQLSessionStorage(db *sql.DB, dialect Dialect, logger l.Wrapper) usertokenmanagerinters.SessionStorage {
+	if logger == nil {
+		logger = l.NewNopLoggerWrapper()
+	}
+
+	if db == nil || !dialect.valid() {
+		logger.Error("no db or unknown dialect")
+
+		return nil
+	}
+
+	return &sessionStorageImpl{
+		db:      db,
+		dialect: dialect,
+		logger:  logger.WithFields(l.StringField(l.ClsKey, "sessionStorageImpl")),
+	}
+}
+
+type sessionStorageImpl struct {
+	db      *sql.DB
+	dialect Dialect
+	logger  l.Wrapper
+}
+
+func (impl *sessionStorageImpl) SaveSession(ctx context.Context, session *usertokenmanagerinters.Session) error {
+	d, err := json.Marshal(session)
+	if err != nil {
+		return err
+	}
+
+	_, err = impl.db.ExecContext(ctx, impl.dialect.rebind(
+		`INSERT INTO user_sessions (user_id, id, data, expire_at) VALUES (?, ?, ?, ?)
+		ON CONFLICT (user_id, id) DO UPDATE SET data = excluded.data, expire_at = excluded.expire_at`),
+		int64(session.UserID), session.ID, string(d), session.ExpireAt.UnixMilli())
+
+	return err
+}
+
+func (impl *sessionStorageImpl) GetSession(ctx context.Context, userID uint64, sessionID string) (*usertokenmanagerinters.Session, error) {
+	var d string
+
+	err := impl.db.QueryRowContext(ctx, impl.dialect.rebind(
+		`SELECT data FROM user_sessions WHERE user_id = ? AND id = ? AND expire_at > ?`),
+		int64(userID), sessionID, time.Now().UnixMilli()).Scan(&d)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			err = nil
+		}
+
+		return nil, err
+	}
+
+	var session usertokenmanagerinters.Session
+
+	err = json.Unmarshal([]byte(d), &session)
+	if err != nil {
+		return nil, err
+	}
+
+	return &session, nil
+}
+
+func (impl *sessionStorageImpl) ListSessions(ctx context.Context, userID uint64) ([]*usertokenmanagerinters.Session, error) {
+	now := time.Now().UnixMilli()
+
+	_, err := impl.db.ExecContext(ctx, impl.dialect.rebind(`DELETE FROM user_sessions WHERE user_id = ? AND expire_at <= ?`),
+		int64(userID), now)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := impl.db.QueryContext(ctx, impl.dialect.rebind(`SELECT id, data FROM user_sessions WHERE user_id = ?`), int64(userID))
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	sessions := make([]*usertokenmanagerinters.Session, 0)
+
+	for rows.Next() {
+		var id, d string
+
+		if err = rows.Scan(&id, &d); err != nil {
+			return nil, err
+		}
+
+		var session usertokenmanagerinters.Session
+
+		if err = json.Unmarshal([]byte(d), &session); err != nil {
+			impl.logger.WithFields(l.ErrorField(err), l.StringField("sessionID", id)).Error("bad session data")
+
+			continue
+		}
+
+		sessions = append(sessions, &session)
+	}
+
+	return sessions, rows.Err()
+}
+
+func (impl *sessionStorageImpl) DeleteSession(ctx context.Context, userID uint64, sessionID string) error {
+	_, err := impl.db.ExecContext(ctx, impl.dialect.rebind(`DELETE FROM user_sessions WHERE user_id = ? AND id = ?`),
+		int64(userID), sessionID)
+
+	return err
+}
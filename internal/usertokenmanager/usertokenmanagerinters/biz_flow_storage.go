@@ -0,0 +1,25 @@
+package usertokenmanagerinters
+
+import (
+	"context"
+	"time"
+)
+
+// BizFlowExpiration a bizuserlib flow not ended by then is forgotten.
+const BizFlowExpiration = time.Minute * 30
+
+// BizFlow what the user server must know when a bizuserlib flow ends. AuthMethods are the authenticators
+// completed in the flow, UserID is the user a change or delete flow was begun for.
+type BizFlow struct {
+	UserID      uint64
+	AuthMethods []string
+}
+
+// BizFlowStorage the steps of a flow may be served by different replicas, the storage is shared by them.
+// GetFlow returns nil without error for unknown flows.
+type BizFlowStorage interface {
+	AddAuthMethod(ctx context.Context, bizID, authMethod string) error
+	SetUserID(ctx context.Context, bizID string, userID uint64) error
+	GetFlow(ctx context.Context, bizID string) (*BizFlow, error)
+	DeleteFlow(ctx context.Context, bizID string) error
+}
@@ -0,0 +1,10 @@
+package usertokenmanagerinters
+
+import "context"
+
+// InvalidationBus spreads the keys changed by one instance to every instance, including the publisher.
+type InvalidationBus interface {
+	Publish(ctx context.Context, key string) error
+	// Subscribe calls handler for every published key until ctx is done.
+	Subscribe(ctx context.Context, handler func(key string))
+}
@@ -7,5 +7,10 @@ import (
 
 type JWTDataStorage interface {
 	Record(ctx context.Context, key string, expiration time.Duration) error
-	Exists(ctx context.Context, key string) (t bool, err error)
+	RecordIfNotExists(ctx context.Context, key string, expiration time.Duration) (recorded bool, err error)
+	Exists(ctx context.Context, keys ...string) (t bool, err error)
+
+	// GetCounter returns 0 for counters never increased, counters do not expire.
+	GetCounter(ctx context.Context, key string) (n int64, err error)
+	IncCounter(ctx context.Context, key string) (n int64, err error)
 }
@@ -0,0 +1,56 @@
+package usertokenmanagerinters
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-oauth2/oauth2/v4"
+	"github.com/go-oauth2/oauth2/v4/models"
+)
+
+// OAuthClientStore the statically configured clients are saved into it at start, replicas sharing the storage
+// see the same clients.
+type OAuthClientStore interface {
+	oauth2.ClientStore
+	Set(ctx context.Context, client oauth2.ClientInfo) error
+}
+
+// OAuthTokenStore RemoveByUserClient deletes the codes and tokens the user got through the client, a revoked
+// grant must not leave them usable.
+type OAuthTokenStore interface {
+	oauth2.TokenStore
+	RemoveByUserClient(ctx context.Context, userID uint64, clientID string) error
+}
+
+// OAuthGrant the scopes a user approved for a client on the consent screen.
+type OAuthGrant struct {
+	UserID     uint64
+	ClientID   string
+	ClientName string
+	Scopes     []string
+	GrantedAt  time.Time
+}
+
+// OAuthGrantStore GetGrant returns nil without error for a missing grant.
+type OAuthGrantStore interface {
+	SaveGrant(ctx context.Context, grant *OAuthGrant) error
+	GetGrant(ctx context.Context, userID uint64, clientID string) (*OAuthGrant, error)
+	ListGrants(ctx context.Context, userID uint64) ([]*OAuthGrant, error)
+	DeleteGrant(ctx context.Context, userID uint64, clientID string) error
+}
+
+// OIDCLogin what the authorize request knew about the login, the id token is built from it when the code is
+// redeemed.
+type OIDCLogin struct {
+	UserName    string   `json:"user_name,omitempty"`
+	Nonce       string   `json:"nonce,omitempty"`
+	AuthTime    int64    `json:"auth_time,omitempty"`
+	AuthMethods []string `json:"amr,omitempty"`
+}
+
+// OAuthTokenInfo token stores decode into it, OIDCLogin is set on codes of openid requests and on the tokens
+// they were exchanged for. It marshals as a models.Token with one more field.
+type OAuthTokenInfo struct {
+	models.Token
+	OIDCLogin *OIDCLogin `json:"OIDCLogin,omitempty"`
+}
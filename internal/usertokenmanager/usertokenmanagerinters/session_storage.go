@@ -0,0 +1,24 @@
+package usertokenmanagerinters
+
+import (
+	"context"
+	"time"
+)
+
+type Session struct {
+	ID         string    `json:"id"`
+	UserID     uint64    `json:"user_id"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	ExpireAt   time.Time `json:"expire_at"`
+	UserAgent  string    `json:"user_agent"`
+	ClientIP   string    `json:"client_ip"`
+}
+
+// SessionStorage GetSession returns nil without error if the session does not exist or has expired.
+type SessionStorage interface {
+	SaveSession(ctx context.Context, session *Session) error
+	GetSession(ctx context.Context, userID uint64, sessionID string) (*Session, error)
+	ListSessions(ctx context.Context, userID uint64) ([]*Session, error)
+	DeleteSession(ctx context.Context, userID uint64, sessionID string) error
+}
@@ -7,20 +7,68 @@ import (
 	"github.com/sbasestarter/bizuserlib/bizuserinters"
 )
 
+// StatusCodeReauthRequired the operation needs a recent authentication, the client runs a step-up login and retries.
+// bizuserlib has no such code, it's kept far from its range.
+const StatusCodeReauthRequired bizuserinters.StatusCode = 1000
+
+// UserTokenInfo SessionID is shared by all tokens issued from one login. AuthMethods (amr) are the authenticators
+// verified by that login at AuthAt, renewed tokens keep both.
 type UserTokenInfo struct {
-	ID         uint64
-	UserName   string
-	StartAt    time.Time
+	ID          uint64
+	UserName    string
+	SessionID   string
+	StartAt     time.Time
+	Expiration  time.Duration
+	Admin       bool      `json:",omitempty"`
+	Roles       []string  `json:",omitempty"`
+	AuthMethods []string  `json:"amr,omitempty"`
+	AuthAt      time.Time `json:"-"`
+}
+
+// UserToken the access token is short-lived, the refresh token lives for the whole session (UserTokenInfo.Expiration)
+// and can be used only once.
+type UserToken struct {
+	AccessToken       string
+	AccessExpiration  time.Duration
+	RefreshToken      string
+	RefreshExpiration time.Duration
+}
+
+// SSOTokenOptions an SSO token is accepted once, by ClientID at Audience. Empty Scopes grants everything the
+// parent token grants.
+type SSOTokenOptions struct {
+	Audience   string
+	ClientID   string
+	Scopes     []string
 	Expiration time.Duration
 }
 
+type SSOTokenInfo struct {
+	UserTokenInfo *UserTokenInfo
+	Audience      string
+	ClientID      string
+	Scopes        []string
+}
+
 type UserTokenManager interface {
-	GenToken(ctx context.Context, userInfo *UserTokenInfo) (string, bizuserinters.Status)
+	GenToken(ctx context.Context, userInfo *UserTokenInfo) (*UserToken, bizuserinters.Status)
 	ExplainToken(ctx context.Context, token string) (*UserTokenInfo, bizuserinters.Status)
-	RenewToken(ctx context.Context, token string) (string, *UserTokenInfo, bizuserinters.Status)
+	RenewToken(ctx context.Context, refreshToken string) (*UserToken, *UserTokenInfo, bizuserinters.Status)
 
-	GenSSOToken(ctx context.Context, parentToken string, expiration time.Duration) (string, bizuserinters.Status)
-	ExplainSSOToken(ctx context.Context, token string) (*UserTokenInfo, bizuserinters.Status)
+	GenSSOToken(ctx context.Context, parentToken string, options *SSOTokenOptions) (string, bizuserinters.Status)
+	// ExplainSSOToken consumes the token, audience and clientID must be the ones it was issued for.
+	ExplainSSOToken(ctx context.Context, token, audience, clientID string) (*SSOTokenInfo, bizuserinters.Status)
 
+	// GenStepUpToken issues a token for the session of userInfo proving the authentication at userInfo.AuthAt,
+	// it is neither an access token nor can it be renewed.
+	GenStepUpToken(ctx context.Context, userInfo *UserTokenInfo, expiration time.Duration) (string, bizuserinters.Status)
+	ExplainStepUpToken(ctx context.Context, token string) (*UserTokenInfo, bizuserinters.Status)
 	DeleteToken(ctx context.Context, token string) bizuserinters.Status
+
+	ListSessions(ctx context.Context, userID uint64) ([]*Session, bizuserinters.Status)
+	RevokeSession(ctx context.Context, userID uint64, sessionID string) bizuserinters.Status
+	RevokeSessions(ctx context.Context, userID uint64, exceptSessionID string) bizuserinters.Status
+
+	// RevokeUserTokens invalidates every token issued to the user so far, including those of unknown sessions.
+	RevokeUserTokens(ctx context.Context, userID uint64) bizuserinters.Status
 }
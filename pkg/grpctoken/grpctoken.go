@@ -2,15 +2,20 @@ package grpctoken
 
 import (
 	"context"
+	"net"
 	"strings"
+	"sync/atomic"
 
 	"github.com/sgostarter/libeasygo/strutils"
 	"github.com/sgostarter/libservicetoolset/grpce/meta"
 	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
 )
 
 const (
-	TokenKeyOnMetadata = "user_token"
+	TokenKeyOnMetadata        = "user_token"
+	RefreshTokenKeyOnMetadata = "user_refresh_token"
+	StepUpTokenKeyOnMetadata  = "user_step_up_token"
 )
 
 func GetCookieStringFromGRPCContext(ctx context.Context, key string) string {
@@ -53,3 +58,106 @@ func GetStringFromGRPCContext(ctx context.Context, key string) string {
 
 	return token
 }
+
+// SetTrustedProxies proxy headers are believed only from peers in cidrs, a plain ip is a single address. Without
+// trusted proxies the peer address is the client ip, the headers are set by the client itself then.
+func SetTrustedProxies(cidrs []string) error {
+	nets := make([]*net.IPNet, 0, len(cidrs))
+
+	for _, cidr := range cidrs {
+		if !strings.Contains(cidr, "/") {
+			if ip := net.ParseIP(cidr); ip != nil && ip.To4() != nil {
+				cidr += "/32"
+			} else {
+				cidr += "/128"
+			}
+		}
+
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return err
+		}
+
+		nets = append(nets, ipNet)
+	}
+
+	trustedProxies.Store(&nets)
+
+	return nil
+}
+
+// GetClientInfoFromGRPCContext the client ip is the peer address unless the peer is a trusted proxy, then it is
+// the last x-forwarded-for entry which isn't a trusted proxy, or x-real-ip.
+func GetClientInfoFromGRPCContext(ctx context.Context) (userAgent, clientIP string) {
+	md, _ := metadata.FromIncomingContext(ctx)
+
+	if values := md.Get("user-agent"); len(values) > 0 {
+		userAgent = values[0]
+	}
+
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		clientIP = p.Addr.String()
+
+		if host, _, err := net.SplitHostPort(clientIP); err == nil {
+			clientIP = host
+		}
+	}
+
+	if !isTrustedProxy(clientIP) {
+		return
+	}
+
+	var forwarded []string
+
+	for _, value := range md.Get("x-forwarded-for") {
+		forwarded = append(forwarded, strings.Split(value, ",")...)
+	}
+
+	// proxies append, entries left of the first untrusted one may be forged
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		ip := strutils.StringTrim(forwarded[i])
+		if ip == "" {
+			continue
+		}
+
+		clientIP = ip
+
+		if !isTrustedProxy(ip) {
+			return
+		}
+	}
+
+	if len(forwarded) == 0 {
+		if values := md.Get("x-real-ip"); len(values) > 0 && strutils.StringTrim(values[0]) != "" {
+			clientIP = strutils.StringTrim(values[0])
+		}
+	}
+
+	return
+}
+
+//
+//
+//
+
+var trustedProxies atomic.Pointer[[]*net.IPNet]
+
+func isTrustedProxy(s string) bool {
+	nets := trustedProxies.Load()
+	if nets == nil {
+		return false
+	}
+
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return false
+	}
+
+	for _, ipNet := range *nets {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,49 @@
+package grpctoken
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+func TestGetClientInfoFromGRPCContext(t *testing.T) {
+	if err := SetTrustedProxies([]string{"10.0.0.0/8", "192.168.1.1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	defer func() {
+		_ = SetTrustedProxies(nil)
+	}()
+
+	cases := []struct {
+		name     string
+		peer     string
+		md       metadata.MD
+		clientIP string
+	}{
+		{"untrusted peer", "1.1.1.1", metadata.Pairs("x-forwarded-for", "2.2.2.2"), "1.1.1.1"},
+		{"untrusted peer real ip", "1.1.1.1", metadata.Pairs("x-real-ip", "2.2.2.2"), "1.1.1.1"},
+		{"trusted peer", "10.0.0.1", metadata.Pairs("x-forwarded-for", "2.2.2.2"), "2.2.2.2"},
+		{"forged entry", "10.0.0.1", metadata.Pairs("x-forwarded-for", "6.6.6.6, 2.2.2.2, 10.0.0.2"), "2.2.2.2"},
+		{"single address", "192.168.1.1", metadata.Pairs("x-forwarded-for", "2.2.2.2"), "2.2.2.2"},
+		{"real ip", "10.0.0.1", metadata.Pairs("x-real-ip", "2.2.2.2"), "2.2.2.2"},
+		{"no header", "10.0.0.1", metadata.MD{}, "10.0.0.1"},
+	}
+
+	for _, c := range cases {
+		ctx := peer.NewContext(metadata.NewIncomingContext(context.Background(), c.md), &peer.Peer{
+			Addr: &net.TCPAddr{IP: net.ParseIP(c.peer), Port: 1234},
+		})
+
+		if _, clientIP := GetClientInfoFromGRPCContext(ctx); clientIP != c.clientIP {
+			t.Error(c.name, clientIP)
+		}
+	}
+
+	if SetTrustedProxies([]string{"x"}) == nil {
+		t.Fatal("bad cidr must be refused")
+	}
+}